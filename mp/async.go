@@ -0,0 +1,277 @@
+package mp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"qingtao/weixin/mp/core"
+	"qingtao/weixin/mp/cs"
+)
+
+const (
+	// DefaultAsyncTimeout 等待Handler被动回复的时间，微信服务器5秒内收不到响应会断开连接并重试
+	DefaultAsyncTimeout = 4 * time.Second
+	// DefaultAsyncWorkers 同时处理消息的最大数量
+	DefaultAsyncWorkers = 64
+)
+
+var (
+	// ErrAsyncBusy 所有worker都在处理消息，超时前没有空闲的worker
+	ErrAsyncBusy = errors.New("async workers busy")
+	// ErrAsyncClosed 已经调用了Shutdown，不再处理新的消息
+	ErrAsyncClosed = errors.New("async closed")
+)
+
+// CSSender 发送客服消息，*cs.Service实现了这个接口
+type CSSender interface {
+	SendMessageContext(ctx context.Context, msg *cs.Message) (*cs.Response, error)
+}
+
+// Async 异步回复：Handler在Timeout内返回时被动回复，
+// 超时后先回复success，Handler返回后把回复转换为客服消息使用Sender发送。
+// Async.Middleware可以作为Router的中间件，也可以直接包装Handler，必须使用NewAsync创建
+type Async struct {
+	// Sender 发送超时的回复
+	Sender CSSender
+	// Timeout 等待被动回复的时间，为0时使用DefaultAsyncTimeout
+	Timeout time.Duration
+	// OnError Handler出错、panic或者客服消息发送失败时调用，可以为nil
+	OnError func(msg *Message, err error)
+	// Logger 记录超时和发送结果，为nil时不输出日志
+	Logger Logger
+
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	closed bool
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewAsync 创建最多使用workers个goroutine同时处理消息的*Async，workers小于等于0时使用DefaultAsyncWorkers
+func NewAsync(sender CSSender, workers int) *Async {
+	if workers <= 0 {
+		workers = DefaultAsyncWorkers
+	}
+	a := &Async{Sender: sender, sem: make(chan struct{}, workers)}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	return a
+}
+
+// NewAsync 创建使用wx.Client()发送客服消息的*Async
+func (wx *WeiXin) NewAsync(workers int) *Async {
+	a := NewAsync(wx.Client().CustomService, workers)
+	a.Logger = wx.log()
+	return a
+}
+
+func (a *Async) logger() Logger {
+	if a.Logger != nil {
+		return a.Logger
+	}
+	return core.NopLogger
+}
+
+func (a *Async) timeout() time.Duration {
+	if a.Timeout > 0 {
+		return a.Timeout
+	}
+	return DefaultAsyncTimeout
+}
+
+func (a *Async) fail(msg *Message, err error) {
+	a.logger().Error("weixin async reply failed", "msgtype", string(msg.MsgType),
+		"openid", core.HashOpenID(string(msg.FromUserName)), "error", err)
+	if a.OnError != nil {
+		a.OnError(msg, err)
+	}
+}
+
+// Middleware 返回异步执行next的Handler，实现Middleware
+func (a *Async) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, msg *Message) *ResponseMessage {
+		return a.serve(ctx, next, msg)
+	})
+}
+
+// serve 在worker中执行h，超时后返回nil
+func (a *Async) serve(ctx context.Context, h Handler, msg *Message) *ResponseMessage {
+	timer := time.NewTimer(a.timeout())
+	defer timer.Stop()
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		a.fail(msg, ErrAsyncClosed)
+		return nil
+	}
+	a.wg.Add(1)
+	a.mu.Unlock()
+
+	select {
+	case a.sem <- struct{}{}:
+	case <-timer.C:
+		a.wg.Done()
+		a.fail(msg, ErrAsyncBusy)
+		return nil
+	case <-ctx.Done():
+		a.wg.Done()
+		return nil
+	}
+
+	// 请求结束后Handler继续执行，不使用请求的ctx取消，Shutdown超时时取消
+	wctx, cancel := context.WithCancel(a.ctx)
+	result := make(chan *ResponseMessage, 1)
+	var mu sync.Mutex
+	timedOut := false
+	go func() {
+		defer a.wg.Done()
+		defer func() { <-a.sem }()
+		defer cancel()
+		rmsg, err := a.call(wctx, h, msg)
+		mu.Lock()
+		late := timedOut
+		if !late {
+			result <- rmsg
+		}
+		mu.Unlock()
+		if !late {
+			if err != nil {
+				a.fail(msg, err)
+			}
+			return
+		}
+		if err != nil {
+			a.fail(msg, err)
+			return
+		}
+		if rmsg == nil {
+			return
+		}
+		if err := a.send(wctx, rmsg); err != nil {
+			a.fail(msg, err)
+			return
+		}
+		a.logger().Info("weixin async reply sent", "msgtype", string(rmsg.MsgType),
+			"openid", core.HashOpenID(string(msg.FromUserName)))
+	}()
+
+	select {
+	case rmsg := <-result:
+		return rmsg
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	select {
+	case rmsg := <-result:
+		// Handler恰好在超时时返回
+		return rmsg
+	default:
+	}
+	timedOut = true
+	a.logger().Debug("weixin reply deferred", "msgtype", string(msg.MsgType),
+		"openid", core.HashOpenID(string(msg.FromUserName)))
+	return nil
+}
+
+// call 执行h，把panic转换为错误
+func (a *Async) call(ctx context.Context, h Handler, msg *Message) (rmsg *ResponseMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	return h.ServeMessage(ctx, msg), nil
+}
+
+// send 把rmsg转换为客服消息并发送
+func (a *Async) send(ctx context.Context, rmsg *ResponseMessage) error {
+	if a.Sender == nil {
+		return errors.New("async sender is nil")
+	}
+	m, err := ToCSMessage(rmsg)
+	if err != nil {
+		return err
+	}
+	_, err = a.Sender.SendMessageContext(ctx, m)
+	return err
+}
+
+// Shutdown 不再接收新的消息，等待正在处理的消息和客服消息发送完成，
+// ctx取消时取消Handler和发送使用的ctx并返回ctx.Err()
+func (a *Async) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		a.cancel()
+		return nil
+	case <-ctx.Done():
+		a.cancel()
+		return ctx.Err()
+	}
+}
+
+// ToCSMessage 把被动回复的消息转换为同样内容的客服消息，
+// 支持text、image、voice、video、music和news，视频消息没有缩略图
+func ToCSMessage(rmsg *ResponseMessage) (*cs.Message, error) {
+	m := &cs.Message{ToUser: string(rmsg.ToUserName), MsgType: string(rmsg.MsgType)}
+	switch m.MsgType {
+	case MsgTypeText:
+		m.Text = &cs.Text{Content: string(rmsg.Content)}
+	case MsgTypeImage, MsgTypeVoice, MsgTypeVideo:
+		var media *Media
+		switch m.MsgType {
+		case MsgTypeImage:
+			media = rmsg.Image
+		case MsgTypeVoice:
+			media = rmsg.Voice
+		default:
+			media = rmsg.Video
+		}
+		if media == nil {
+			return nil, fmt.Errorf("%s message without media", m.MsgType)
+		}
+		cm := &cs.Media{MediaID: string(media.MediaID)}
+		if m.MsgType == MsgTypeVideo {
+			cm.Title, cm.Description = string(media.Title), string(media.Description)
+		}
+		switch m.MsgType {
+		case MsgTypeImage:
+			m.Image = cm
+		case MsgTypeVoice:
+			m.Voice = cm
+		default:
+			m.Video = cm
+		}
+	case "music":
+		if rmsg.Music == nil {
+			return nil, errors.New("music message without music")
+		}
+		m.Music = cs.NewMusic(string(rmsg.Music.Title), string(rmsg.Music.Description),
+			string(rmsg.Music.MusicURL), string(rmsg.Music.HQMusicURL), string(rmsg.Music.ThumbMediaID))
+	case "news":
+		if rmsg.Articles == nil || len(rmsg.Articles.Item) == 0 {
+			return nil, errors.New("news message without articles")
+		}
+		m.News = &cs.News{}
+		for _, art := range rmsg.Articles.Item {
+			m.News.Articles = append(m.News.Articles, cs.NewArticle(string(art.Title),
+				string(art.Description), string(art.URL), string(art.PicURL)))
+		}
+	default:
+		return nil, fmt.Errorf("cannot send %s message by custom service", m.MsgType)
+	}
+	return m, nil
+}
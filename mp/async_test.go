@@ -0,0 +1,87 @@
+package mp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"qingtao/weixin/mp/cs"
+)
+
+// fakeSender 记录发送的客服消息
+type fakeSender struct {
+	mu   sync.Mutex
+	msgs []*cs.Message
+}
+
+func (s *fakeSender) SendMessageContext(ctx context.Context, msg *cs.Message) (*cs.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msgs = append(s.msgs, msg)
+	return &cs.Response{}, nil
+}
+
+func TestAsync(t *testing.T) {
+	sender := &fakeSender{}
+	a := NewAsync(sender, 1)
+	a.Timeout = 50 * time.Millisecond
+	var errs []error
+	var mu sync.Mutex
+	a.OnError = func(msg *Message, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+	release := make(chan struct{})
+	h := a.Middleware(HandlerFunc(func(ctx context.Context, msg *Message) *ResponseMessage {
+		if msg.Content == "slow" {
+			<-release
+		}
+		return NewTextMessage(msg.FromUserName, msg.ToUserName, "reply "+string(msg.Content))
+	}))
+
+	// 及时返回时被动回复
+	rmsg := h.ServeMessage(context.Background(), &Message{FromUserName: "user", Content: "fast"})
+	if rmsg == nil || rmsg.Content != "reply fast" {
+		t.Fatalf("fast reply %+v", rmsg)
+	}
+
+	// 超时后返回nil，唯一的worker被占用时返回ErrAsyncBusy
+	if rmsg := h.ServeMessage(context.Background(), &Message{FromUserName: "user", Content: "slow"}); rmsg != nil {
+		t.Fatalf("slow reply %+v", rmsg)
+	}
+	if rmsg := h.ServeMessage(context.Background(), &Message{FromUserName: "user", Content: "fast"}); rmsg != nil {
+		t.Fatalf("busy reply %+v", rmsg)
+	}
+	close(release)
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.msgs) != 1 || sender.msgs[0].ToUser != "user" || sender.msgs[0].Text.Content != "reply slow" {
+		t.Fatalf("sent %+v", sender.msgs)
+	}
+	h.ServeMessage(context.Background(), &Message{FromUserName: "user", Content: "fast"})
+	if len(errs) != 2 || errs[0] != ErrAsyncBusy || errs[1] != ErrAsyncClosed {
+		t.Fatalf("errors %v", errs)
+	}
+}
+
+func TestToCSMessage(t *testing.T) {
+	rmsg := &ResponseMessage{ToUserName: "user", MsgType: "news",
+		Articles: &Articles{Item: []*Article{NewArticle("title", "desc", "pic", "url")}}}
+	m, err := ToCSMessage(rmsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.MsgType != "news" || len(m.News.Articles) != 1 || m.News.Articles[0].URL != "url" || m.News.Articles[0].PicURL != "pic" {
+		t.Fatalf("news %+v", m.News.Articles[0])
+	}
+	m, err = ToCSMessage(&ResponseMessage{ToUserName: "user", MsgType: "video", Video: NewMedia("id", "t", "d")})
+	if err != nil || m.Video.MediaID != "id" || m.Video.Title != "t" {
+		t.Fatalf("video %+v %v", m, err)
+	}
+	if _, err := ToCSMessage(&ResponseMessage{MsgType: "transfer_customer_service"}); err == nil {
+		t.Fatal("expect error")
+	}
+}
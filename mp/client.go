@@ -0,0 +1,68 @@
+package mp
+
+import (
+	"context"
+	"fmt"
+
+	"qingtao/weixin/mp/core"
+	"qingtao/weixin/mp/cs"
+	"qingtao/weixin/mp/mass"
+	"qingtao/weixin/mp/media"
+	"qingtao/weixin/mp/qrcode"
+	"qingtao/weixin/mp/subscribe"
+	"qingtao/weixin/mp/template"
+	"qingtao/weixin/mp/users"
+)
+
+// Client 公众平台API客户端，统一配置主机名、access_token来源和*http.Client，
+// 各接口按照功能分为子服务，子服务共用同一个*core.Client
+type Client struct {
+	*core.Client
+	// Users 用户管理
+	Users *users.Service
+	// Media 素材管理
+	Media *media.Service
+	// CustomService 客服消息
+	CustomService *cs.Service
+	// Menu 自定义菜单
+	Menu *MenuService
+	// Template 模板消息
+	Template *template.Service
+	// Subscribe 订阅通知
+	Subscribe *subscribe.Service
+	// Mass 群发消息
+	Mass *mass.Service
+	// QRCode 带参数二维码
+	QRCode *qrcode.Service
+}
+
+// NewClient 使用host和tokens创建*Client，
+// 需要设置Transport、超时或者BaseURL时，在使用前修改返回值的HTTPClient和BaseURL
+func NewClient(host string, tokens core.TokenSource) *Client {
+	c := core.NewClient(host, tokens)
+	return &Client{
+		Client:        c,
+		Users:         users.NewService(c),
+		Media:         media.NewService(c),
+		CustomService: cs.NewService(c),
+		Menu:          NewMenuService(c),
+		Template:      template.NewService(c),
+		Subscribe:     subscribe.NewService(c),
+		Mass:          mass.NewService(c),
+		QRCode:        qrcode.NewService(c),
+	}
+}
+
+// GetCallBackIP 获取微信服务器IP地址
+func (c *Client) GetCallBackIP() (*CallBackIP, error) {
+	return c.GetCallBackIPContext(context.Background())
+}
+
+// GetCallBackIPContext 获取微信服务器IP地址，ctx取消时中止请求
+func (c *Client) GetCallBackIPContext(ctx context.Context) (*CallBackIP, error) {
+	var ips CallBackIP
+	if err := c.Get(ctx, WxGetCallBackIPPath, nil, &ips); err != nil {
+		return nil, fmt.Errorf("get callback ip address of weixin: %w", err)
+	}
+	return &ips, nil
+}
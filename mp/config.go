@@ -0,0 +1,213 @@
+package mp
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultEnvPrefix LoadEnv使用的环境变量前缀
+const DefaultEnvPrefix = "WX_"
+
+// 环境变量的名称，加上前缀后使用，例如WX_APPID
+const (
+	// EnvHost 微信服务器主机名
+	EnvHost = "HOST"
+	// EnvAppID 应用ID
+	EnvAppID = "APPID"
+	// EnvAppSecret 应用密钥
+	EnvAppSecret = "APPSECRET"
+	// EnvToken 服务器配置中的令牌
+	EnvToken = "TOKEN"
+	// EnvAESKey 消息加解密密钥
+	EnvAESKey = "AESKEY"
+	// EnvOldAESKey 旧的消息加解密密钥，多个密钥使用逗号分隔
+	EnvOldAESKey = "OLD_AESKEY"
+)
+
+// Validate 检查配置，AppID和AppSecret不能为空，
+// EncodingAESKey和旧的密钥必须是43个字符的base64编码，Host只能是主机名和端口
+func (wx *WeiXin) Validate() error {
+	var errs []string
+	if wx.AppID == "" {
+		errs = append(errs, "AppID is empty")
+	}
+	if wx.AppSecret == "" {
+		errs = append(errs, "AppSecret is empty")
+	}
+	if wx.Host != "" {
+		if u, err := url.Parse("https://" + wx.Host); err != nil || u.Host != wx.Host {
+			errs = append(errs, fmt.Sprintf("invalid Host %q, must be a host name without scheme and path", wx.Host))
+		}
+	}
+	keys := append([]string{wx.EncodingAESKey, wx.OldEncodingAESKey}, wx.OldEncodingAESKeys...)
+	names := []string{"EncodingAESKey", "OldEncodingAESKey"}
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		name := "OldEncodingAESKeys"
+		if i < len(names) {
+			name = names[i]
+		}
+		if _, _, err := newCipher(key); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid %s: length %d, must be 43 base64 characters", name, len(key)))
+		}
+	}
+	if wx.EncodingAESKey == "" && (wx.OldEncodingAESKey != "" || len(wx.OldEncodingAESKeys) > 0) {
+		errs = append(errs, "EncodingAESKey is empty but old keys are set")
+	}
+	if len(errs) > 0 {
+		if wx.AppID != "" {
+			return fmt.Errorf("weixin config %s: %s", wx.AppID, strings.Join(errs, "; "))
+		}
+		return fmt.Errorf("weixin config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// isJSON 按照文件扩展名或者第一个非空白字符判断是否是JSON
+func isJSON(filename string, b []byte) bool {
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		return true
+	}
+	s := strings.TrimSpace(string(b))
+	return strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")
+}
+
+// Load 读取XML或者JSON格式的配置文件，扩展名为.json或者内容以{开头时按照JSON解析，
+// 解析后检查配置
+func Load(filename string) (*WeiXin, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read weixin config: %s", err)
+	}
+	var wx WeiXin
+	if isJSON(filename, b) {
+		err = json.Unmarshal(b, &wx)
+	} else {
+		err = xml.Unmarshal(b, &wx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read weixin config %s: %s", filename, err)
+	}
+	if err := wx.Validate(); err != nil {
+		return nil, err
+	}
+	return &wx, nil
+}
+
+// LoadEnv 从prefix开头的环境变量读取配置，prefix为空时使用DefaultEnvPrefix，
+// 例如WX_APPID、WX_APPSECRET、WX_TOKEN、WX_AESKEY，读取后检查配置
+func LoadEnv(prefix string) (*WeiXin, error) {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	wx := &WeiXin{
+		Host:           os.Getenv(prefix + EnvHost),
+		AppID:          os.Getenv(prefix + EnvAppID),
+		AppSecret:      os.Getenv(prefix + EnvAppSecret),
+		Token:          os.Getenv(prefix + EnvToken),
+		EncodingAESKey: os.Getenv(prefix + EnvAESKey),
+	}
+	for _, key := range strings.Split(os.Getenv(prefix+EnvOldAESKey), ",") {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		if wx.OldEncodingAESKey == "" {
+			wx.OldEncodingAESKey = key
+		} else {
+			wx.OldEncodingAESKeys = append(wx.OldEncodingAESKeys, key)
+		}
+	}
+	if err := wx.Validate(); err != nil {
+		return nil, fmt.Errorf("%w (from %s* environment variables)", err, prefix)
+	}
+	return wx, nil
+}
+
+// Accounts 多个公众号的配置，key是公众号的名称
+type Accounts map[string]*WeiXin
+
+// LoadAccounts 读取多个公众号的JSON配置文件，
+// 文件内容是以名称为key的对象，或者是配置的数组，数组使用AppID作为名称，读取后检查每个配置
+func LoadAccounts(filename string) (Accounts, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read weixin accounts: %s", err)
+	}
+	accounts := make(Accounts)
+	if strings.HasPrefix(strings.TrimSpace(string(b)), "[") {
+		var list []*WeiXin
+		if err := json.Unmarshal(b, &list); err != nil {
+			return nil, fmt.Errorf("read weixin accounts %s: %s", filename, err)
+		}
+		for _, wx := range list {
+			if wx == nil {
+				continue
+			}
+			if _, ok := accounts[wx.AppID]; ok {
+				return nil, fmt.Errorf("read weixin accounts %s: duplicate AppID %q", filename, wx.AppID)
+			}
+			accounts[wx.AppID] = wx
+		}
+	} else if err := json.Unmarshal(b, &accounts); err != nil {
+		return nil, fmt.Errorf("read weixin accounts %s: %s", filename, err)
+	}
+	if err := accounts.Validate(); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// Validate 检查每个公众号的配置，AppID不能重复
+func (a Accounts) Validate() error {
+	if len(a) == 0 {
+		return errors.New("weixin accounts: no account")
+	}
+	appids := make(map[string]string, len(a))
+	for _, name := range a.Names() {
+		wx := a[name]
+		if wx == nil {
+			return fmt.Errorf("weixin account %s: config is null", name)
+		}
+		if err := wx.Validate(); err != nil {
+			return fmt.Errorf("weixin account %s: %w", name, err)
+		}
+		if other, ok := appids[wx.AppID]; ok {
+			return fmt.Errorf("weixin account %s: AppID %s is also used by %s", name, wx.AppID, other)
+		}
+		appids[wx.AppID] = name
+	}
+	return nil
+}
+
+// Names 返回排序后的公众号名称
+func (a Accounts) Names() []string {
+	names := make([]string, 0, len(a))
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup 按照名称或者AppID查找公众号的配置
+func (a Accounts) Lookup(nameOrAppID string) (*WeiXin, bool) {
+	if wx, ok := a[nameOrAppID]; ok {
+		return wx, true
+	}
+	for _, wx := range a {
+		if wx != nil && wx.AppID == nameOrAppID {
+			return wx, true
+		}
+	}
+	return nil, false
+}
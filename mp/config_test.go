@@ -0,0 +1,171 @@
+package mp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *WeiXin {
+		return &WeiXin{AppID: appid, AppSecret: "secret", Host: "api.weixin.qq.com:443", EncodingAESKey: encodingAESKey}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		change func(wx *WeiXin)
+		want   string
+	}{
+		{func(wx *WeiXin) { wx.AppSecret = "" }, "AppSecret is empty"},
+		{func(wx *WeiXin) { wx.EncodingAESKey = "abc" }, "invalid EncodingAESKey: length 3"},
+		{func(wx *WeiXin) { wx.OldEncodingAESKeys = []string{encodingAESKey + "x"} }, "invalid OldEncodingAESKeys"},
+		{func(wx *WeiXin) { wx.Host = "https://api.weixin.qq.com" }, "invalid Host"},
+		{func(wx *WeiXin) { wx.Host = "api.weixin.qq.com/cgi-bin" }, "invalid Host"},
+	}
+	for _, tt := range tests {
+		wx := valid()
+		tt.change(wx)
+		if err := wx.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("want %q, got %v", tt.want, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "weixin.json")
+	if err := CreateWeiXinFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(name); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("template %v %v", fi.Mode(), err)
+	}
+	if err := CreateWeiXinFile(name); err == nil {
+		t.Fatal("overwrite existing file")
+	}
+	// 模版中的AppSecret为空
+	if _, err := Load(name); err == nil || !strings.Contains(err.Error(), "AppSecret is empty") {
+		t.Fatalf("load template %v", err)
+	}
+
+	b, _ := json.Marshal(map[string]string{"AppId": appid, "AppSecret": "secret", "Token": token, "EncodingAESKey": encodingAESKey})
+	ioutil.WriteFile(name, b, 0600)
+	wx, err := Load(name)
+	if err != nil || wx.AppID != appid || wx.Token != token {
+		t.Fatalf("load json %+v %v", wx, err)
+	}
+
+	xmlName := filepath.Join(dir, "weixin.xml")
+	ioutil.WriteFile(xmlName, []byte(`<weixin><AppId>`+appid+`</AppId><AppSecret>secret</AppSecret></weixin>`), 0600)
+	if wx, err := New(xmlName); err != nil || wx.AppSecret != "secret" {
+		t.Fatalf("load xml %+v %v", wx, err)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("TEST_WX_APPID", appid)
+	t.Setenv("TEST_WX_APPSECRET", "secret")
+	t.Setenv("TEST_WX_AESKEY", encodingAESKey)
+	t.Setenv("TEST_WX_OLD_AESKEY", newEncodingAESKey+", "+encodingAESKey)
+	wx, err := LoadEnv("TEST_WX_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wx.AppSecret != "secret" || wx.OldEncodingAESKey != newEncodingAESKey || len(wx.OldEncodingAESKeys) != 1 {
+		t.Fatalf("%+v", wx)
+	}
+	t.Setenv("TEST_WX_APPSECRET", "")
+	if _, err := LoadEnv("TEST_WX_"); err == nil || !strings.Contains(err.Error(), "TEST_WX_") {
+		t.Fatalf("missing secret %v", err)
+	}
+}
+
+func TestLoadAccounts(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "accounts.json")
+	ioutil.WriteFile(name, []byte(`{
+		"news": {"AppId": "wx1", "AppSecret": "s1"},
+		"shop": {"AppId": "wx2", "AppSecret": "s2", "EncodingAESKey": "`+encodingAESKey+`"}
+	}`), 0600)
+	accounts, err := LoadAccounts(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := accounts.Names(); len(names) != 2 || names[0] != "news" {
+		t.Fatalf("names %v", names)
+	}
+	if wx, ok := accounts.Lookup("wx2"); !ok || wx.AppSecret != "s2" {
+		t.Fatalf("lookup by appid %+v", wx)
+	}
+	if wx, ok := accounts.Lookup("news"); !ok || wx.AppID != "wx1" {
+		t.Fatalf("lookup by name %+v", wx)
+	}
+
+	ioutil.WriteFile(name, []byte(`[{"AppId": "wx1", "AppSecret": "s1"}, {"AppId": "wx1", "AppSecret": "s2"}]`), 0600)
+	if _, err := LoadAccounts(name); err == nil || !strings.Contains(err.Error(), "duplicate AppID") {
+		t.Fatalf("duplicate %v", err)
+	}
+	ioutil.WriteFile(name, []byte(`{"a": {"AppId": "wx1", "AppSecret": "s1"}, "b": {"AppId": "wx1", "AppSecret": "s1"}}`), 0600)
+	if _, err := LoadAccounts(name); err == nil || !strings.Contains(err.Error(), "also used by") {
+		t.Fatalf("duplicate %v", err)
+	}
+}
+
+func TestSetLimiter(t *testing.T) {
+	wx := &WeiXin{AppID: "wxappid", AppSecret: "secret"}
+	l := NewLimiter(DefaultLimits())
+	if err := wx.SetLimiter(l); err != nil {
+		t.Fatal(err)
+	}
+	if wx.Client().Limiter != l {
+		t.Fatal("limiter not used by client")
+	}
+	if err := wx.SetLimiter(nil); err != ErrClientCreated {
+		t.Fatalf("SetLimiter() after Client() error = %v", err)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	wx := &WeiXin{AppID: "wxappid", AppSecret: "secret"}
+	// 先创建的组件在SetLogger之后也使用新的Logger
+	client := wx.Client().Logger
+	server := wx.Server().Logger
+	async := wx.NewAsync(1)
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			client.Debug("before")
+		}
+	}()
+	wx.SetLogger(l)
+	wg.Wait()
+
+	client.Info("client")
+	server.Info("server")
+	async.Logger.Info("async")
+	out := buf.String()
+	for _, s := range []string{"msg=client appid=wxappid", "msg=server appid=wxappid", "msg=async appid=wxappid"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("log missing %q:\n%s", s, out)
+		}
+	}
+
+	wx.SetLogger(nil)
+	buf.Reset()
+	client.Info("client")
+	if buf.Len() != 0 {
+		t.Errorf("log after SetLogger(nil): %s", buf.String())
+	}
+}
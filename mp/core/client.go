@@ -0,0 +1,315 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHost 微信公众平台接口的主机名
+	DefaultHost = "api.weixin.qq.com"
+	// JSONContentType POST json数据时使用的Content-Type
+	JSONContentType = "application/json; charset=utf-8"
+)
+
+// Client 调用公众平台接口的客户端，统一配置主机名、access_token来源和*http.Client，
+// 可以被多个goroutine同时使用，使用后不应再修改字段
+type Client struct {
+	// Host 微信服务器主机名，为空时使用DefaultHost
+	Host string
+	// BaseURL 接口的基础地址，例如https://api.weixin.qq.com，非空时代替Host，可用于代理或者测试
+	BaseURL string
+	// HTTPClient 发送请求使用的*http.Client，可以设置Transport和Timeout，为nil时使用http.DefaultClient
+	HTTPClient *http.Client
+	// Tokens access_token的来源，实现TokenRefresher时，access_token失效后自动刷新并重试一次
+	Tokens TokenSource
+	// Retry 系统繁忙、调用太频繁和网络错误时的重试策略，为nil时不重试
+	Retry *RetryPolicy
+	// Limiter 按接口限制调用频率和每日次数，为nil时不限制
+	Limiter *Limiter
+	// Logger 记录接口调用的路径、耗时、重试和errcode，不记录access_token，为nil时不输出日志
+	Logger Logger
+}
+
+// NewClient 使用host和tokens创建*Client，使用DefaultRetryPolicy
+func NewClient(host string, tokens TokenSource) *Client {
+	return &Client{Host: host, Tokens: tokens, Retry: DefaultRetryPolicy}
+}
+
+// Request 一次接口调用
+type Request struct {
+	// Method HTTP方法，为空时使用GET
+	Method string
+	// Path 接口的路径，例如cgi-bin/user/info
+	Path string
+	// Query 除access_token以外的查询参数
+	Query url.Values
+	// ContentType 请求内容的类型
+	ContentType string
+	// Body 请求的内容，重试时会再次发送
+	Body []byte
+	// NoToken 为true时不添加access_token，例如获取access_token的接口
+	NoToken bool
+	// Idempotent 为true时POST请求可以重复发送，例如只查询数据的接口，
+	// 否则POST请求只在确定微信服务器没有处理时重试
+	Idempotent bool
+	// NoRetry 为true时不自动重试，例如网页授权的code只能使用一次，
+	// 第一次请求已经到达微信服务器后重试只会得到40163
+	NoRetry bool
+}
+
+// status 只包含errcode和errmsg的响应
+type status struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// URL 返回path和query组成的完整地址
+func (c *Client) URL(path string, query url.Values) string {
+	base := c.BaseURL
+	if base == "" {
+		host := c.Host
+		if host == "" {
+			host = DefaultHost
+		}
+		base = "https://" + host
+	}
+	uri := strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	return uri
+}
+
+func (c *Client) logger() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return NopLogger
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// send 使用accessToken发送一次请求
+func (c *Client) send(ctx context.Context, req *Request, accessToken string) (*http.Response, error) {
+	query := url.Values{}
+	for k, v := range req.Query {
+		query[k] = v
+	}
+	if !req.NoToken {
+		query.Set("access_token", accessToken)
+	}
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	if c.Limiter != nil {
+		if err := c.Limiter.Allow(ctx, req.Path); err != nil {
+			return nil, err
+		}
+	}
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewReader(req.Body)
+	}
+	hreq, err := http.NewRequest(method, c.URL(req.Path, query), body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", req.Path, err)
+	}
+	if req.ContentType != "" {
+		hreq.Header.Set("Content-Type", req.ContentType)
+	}
+	res, err := c.httpClient().Do(hreq.WithContext(ctx))
+	if err != nil {
+		// 错误信息中的URL包含access_token，只保留path
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%s %s: %w", method, req.Path, err)
+	}
+	return res, nil
+}
+
+// GetURL 使用HTTPClient获取完整的地址rawurl，不添加access_token，
+// 用于下载接口返回的视频等外部链接，调用者负责关闭Body
+func (c *Client) GetURL(ctx context.Context, rawurl string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.httpClient().Do(req.WithContext(ctx))
+}
+
+// IsJSON 响应的内容是json或者文本，可能包含errcode
+func IsJSON(res *http.Response) bool {
+	typ := res.Header.Get("Content-Type")
+	return typ == "" || strings.Contains(typ, "json") || strings.Contains(typ, "text")
+}
+
+// readBody 读取响应的全部内容，并替换res.Body以便再次读取
+func readBody(res *http.Response) ([]byte, error) {
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// Do 发送请求并返回*http.Response，调用者负责关闭Body。
+// 响应是json并且errcode说明access_token已经失效时，
+// 如果Tokens实现了TokenRefresher，刷新access_token后重试一次；
+// 系统繁忙、调用太频繁和网络错误按照c.Retry重试
+func (c *Client) Do(ctx context.Context, req *Request) (*http.Response, error) {
+	policy := c.Retry
+	if policy == nil || req.NoRetry {
+		policy = NoRetry
+	}
+	log := c.logger()
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		res, err := c.do(ctx, req)
+		if attempt >= policy.MaxAttempts || !policy.shouldRetry(ctx, req, res, err) {
+			if err != nil {
+				log.Warn("weixin api failed", "path", req.Path, "attempts", attempt,
+					"latency", time.Since(start), "error", err)
+			} else {
+				log.Debug("weixin api", "path", req.Path, "status", res.StatusCode, "attempts", attempt,
+					"latency", time.Since(start))
+			}
+			return res, err
+		}
+		backoff := policy.backoff(attempt)
+		log.Info("weixin api retry", "path", req.Path, "attempt", attempt, "backoff", backoff, "error", err)
+		if res != nil {
+			res.Body.Close()
+		}
+		if err = sleep(ctx, backoff); err != nil {
+			return nil, fmt.Errorf("%s: %w", req.Path, err)
+		}
+	}
+}
+
+// do 发送一次请求，access_token失效时刷新后重试一次
+func (c *Client) do(ctx context.Context, req *Request) (*http.Response, error) {
+	if req.NoToken {
+		return c.send(ctx, req, "")
+	}
+	if c.Tokens == nil {
+		return nil, errors.New("core: Client.Tokens is nil")
+	}
+	token, err := c.Tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.send(ctx, req, token)
+	if err != nil {
+		return nil, err
+	}
+	refresher, ok := c.Tokens.(TokenRefresher)
+	if !ok || res.StatusCode != http.StatusOK || !IsJSON(res) {
+		return res, nil
+	}
+	b, err := readBody(res)
+	if err != nil {
+		return nil, fmt.Errorf("%s: read response %s", req.Path, err)
+	}
+	var st status
+	if json.Unmarshal(b, &st) != nil || !IsTokenInvalid(st.ErrCode) {
+		return res, nil
+	}
+	c.logger().Info("weixin access_token invalid, refresh", "path", req.Path, "errcode", st.ErrCode)
+	if token, err = refresher.Refresh(ctx, token); err != nil {
+		return nil, err
+	}
+	return c.send(ctx, req, token)
+}
+
+// Call 发送请求并将json响应写入v，v为nil时忽略响应内容，
+// 响应的errcode不为0时返回*APIError，不写入v
+func (c *Client) Call(ctx context.Context, req *Request, v interface{}) error {
+	res, err := c.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", req.Path, res.Status)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("%s: read response %s", req.Path, err)
+	}
+	// 先检查errcode，错误响应的字段类型可能与v不一致
+	if err = CheckResponse(req.Path, b); err != nil {
+		e := err.(*APIError)
+		c.logger().Warn("weixin api error", "path", req.Path, "errcode", e.ErrCode, "errmsg", e.ErrMsg)
+		if c.Limiter != nil && e.ErrCode == ErrCodeAPIFreqOutOfLimit {
+			c.Limiter.Exhaust(req.Path)
+		}
+		return err
+	}
+	if v != nil {
+		if err = json.Unmarshal(b, v); err != nil {
+			return fmt.Errorf("%s: unmarshal response %s", req.Path, err)
+		}
+	}
+	return nil
+}
+
+// Get 使用GET方法调用path，json响应写入v
+func (c *Client) Get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	return c.Call(ctx, &Request{Path: path, Query: query}, v)
+}
+
+// Post 使用POST方法提交contentType类型的body，json响应写入v
+func (c *Client) Post(ctx context.Context, path string, query url.Values, contentType string, body []byte, v interface{}) error {
+	return c.Call(ctx, &Request{
+		Method:      "POST",
+		Path:        path,
+		Query:       query,
+		ContentType: contentType,
+		Body:        body,
+	}, v)
+}
+
+// QueryJSON 与PostJSON相同，用于只查询不修改数据的接口，失败时可以安全的重试
+func (c *Client) QueryJSON(ctx context.Context, path string, query url.Values, body interface{}, v interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("%s: marshal request %s", path, err)
+	}
+	return c.Call(ctx, &Request{
+		Method:      "POST",
+		Path:        path,
+		Query:       query,
+		ContentType: JSONContentType,
+		Body:        b,
+		Idempotent:  true,
+	}, v)
+}
+
+// PostJSON 将body序列化为json后POST到path，json响应写入v
+func (c *Client) PostJSON(ctx context.Context, path string, query url.Values, body interface{}, v interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("%s: marshal request %s", path, err)
+	}
+	return c.Post(ctx, path, query, JSONContentType, b, v)
+}
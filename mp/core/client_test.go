@@ -0,0 +1,103 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testTokens 每次Refresh生成新的access_token
+type testTokens struct {
+	mu      sync.Mutex
+	n       int
+	refresh int
+}
+
+func (t *testTokens) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return fmt.Sprintf("token%d", t.n), nil
+}
+
+func (t *testTokens) Refresh(ctx context.Context, old string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n++
+	t.refresh++
+	return fmt.Sprintf("token%d", t.n), nil
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("access_token") != "token1" {
+			fmt.Fprint(w, `{"errcode":42001,"errmsg":"access_token expired"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","path":"%s"}`, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	tokens := &testTokens{}
+	c := &Client{BaseURL: srv.URL, Tokens: tokens}
+	var resp struct {
+		ErrCode int    `json:"errcode"`
+		Path    string `json:"path"`
+	}
+	if err := c.Get(context.Background(), "cgi-bin/test", nil, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ErrCode != 0 || resp.Path != "/cgi-bin/test" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if tokens.refresh != 1 {
+		t.Fatalf("refresh %d times, want 1", tokens.refresh)
+	}
+
+	// 不能刷新的access_token不重试，返回*APIError，不写入resp
+	c = NewClient("", StaticToken("token0"))
+	c.BaseURL = srv.URL
+	resp.ErrCode, resp.Path = 0, ""
+	err := c.Get(context.Background(), "cgi-bin/test", nil, &resp)
+	if !IsTokenExpired(err) {
+		t.Fatalf("err = %v, want access_token expired", err)
+	}
+	if resp.ErrCode != 0 {
+		t.Fatalf("error response written to v: %+v", resp)
+	}
+}
+
+func TestClientErrorHidesToken(t *testing.T) {
+	c := &Client{BaseURL: "http://127.0.0.1:1", Tokens: StaticToken("secret-token")}
+	err := c.Get(context.Background(), "cgi-bin/test", nil, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Fatalf("error leaks access_token: %s", err)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	c := &Client{BaseURL: srv.URL, Tokens: StaticToken("token")}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.PostJSON(ctx, "cgi-bin/test", nil, struct{}{}, nil)
+	}()
+	cancel()
+	if err := <-done; err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("err = %v, want context canceled", err)
+	}
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// 公众平台全局返回码，完整的列表见公众平台文档“全局返回码说明”
+const (
+	// ErrCodeSystemBusy 系统繁忙，此时请开发者稍候再试
+	ErrCodeSystemBusy = -1
+	// ErrCodeOK 请求成功
+	ErrCodeOK = 0
+	// ErrCodeInvalidCredential 获取access_token时AppSecret错误，或者access_token无效
+	ErrCodeInvalidCredential = 40001
+	// ErrCodeInvalidGrantType 不合法的凭证类型
+	ErrCodeInvalidGrantType = 40002
+	// ErrCodeInvalidOpenID 不合法的OpenID
+	ErrCodeInvalidOpenID = 40003
+	// ErrCodeInvalidMediaType 不合法的媒体文件类型
+	ErrCodeInvalidMediaType = 40004
+	// ErrCodeInvalidFileType 不合法的文件类型
+	ErrCodeInvalidFileType = 40005
+	// ErrCodeInvalidFileSize 不合法的文件大小
+	ErrCodeInvalidFileSize = 40006
+	// ErrCodeInvalidMediaID 不合法的媒体文件id
+	ErrCodeInvalidMediaID = 40007
+	// ErrCodeInvalidImageSize 不合法的图片文件大小
+	ErrCodeInvalidImageSize = 40009
+	// ErrCodeInvalidVoiceSize 不合法的语音文件大小
+	ErrCodeInvalidVoiceSize = 40010
+	// ErrCodeInvalidVideoSize 不合法的视频文件大小
+	ErrCodeInvalidVideoSize = 40011
+	// ErrCodeInvalidThumbSize 不合法的缩略图文件大小
+	ErrCodeInvalidThumbSize = 40012
+	// ErrCodeInvalidAppID 不合法的AppID
+	ErrCodeInvalidAppID = 40013
+	// ErrCodeInvalidAccessToken 不合法的access_token
+	ErrCodeInvalidAccessToken = 40014
+	// ErrCodeInvalidAppSecret 不合法的AppSecret
+	ErrCodeInvalidAppSecret = 40125
+	// ErrCodeInvalidIP 调用接口的IP地址不在白名单中
+	ErrCodeInvalidIP = 40164
+	// ErrCodeAccessTokenMissing 缺少access_token参数
+	ErrCodeAccessTokenMissing = 41001
+	// ErrCodeAccessTokenExpired access_token超时
+	ErrCodeAccessTokenExpired = 42001
+	// ErrCodeRequireSubscribe 需要接收者关注
+	ErrCodeRequireSubscribe = 43004
+	// ErrCodeMediaSizeOutOfLimit 多媒体文件大小超过限制
+	ErrCodeMediaSizeOutOfLimit = 45001
+	// ErrCodeContentSizeOutOfLimit 消息内容超过限制
+	ErrCodeContentSizeOutOfLimit = 45002
+	// ErrCodeAPIFreqOutOfLimit 接口调用超过每日限制
+	ErrCodeAPIFreqOutOfLimit = 45009
+	// ErrCodeAPIMinuteOutOfLimit API调用太频繁，请稍候再试
+	ErrCodeAPIMinuteOutOfLimit = 45011
+	// ErrCodeResponseOutOfTime 回复时间超过限制，用户48小时内没有互动
+	ErrCodeResponseOutOfTime = 45015
+	// ErrCodeOutOfResponseCount 客服接口下行条数超过上限
+	ErrCodeOutOfResponseCount = 45047
+	// ErrCodeMenuNotExist 不存在菜单数据
+	ErrCodeMenuNotExist = 46003
+	// ErrCodeUserNotExist 不存在的用户
+	ErrCodeUserNotExist = 46004
+	// ErrCodeAPIUnauthorized api功能未授权，请确认公众号已获得该接口
+	ErrCodeAPIUnauthorized = 48001
+	// ErrCodeUserUnauthorized 用户未授权该api
+	ErrCodeUserUnauthorized = 50001
+)
+
+// errMessages 返回码的中文说明
+var errMessages = map[int]string{
+	ErrCodeSystemBusy:            "系统繁忙",
+	ErrCodeInvalidCredential:     "AppSecret错误或者access_token无效",
+	ErrCodeInvalidGrantType:      "不合法的凭证类型",
+	ErrCodeInvalidOpenID:         "不合法的OpenID",
+	ErrCodeInvalidMediaType:      "不合法的媒体文件类型",
+	ErrCodeInvalidFileType:       "不合法的文件类型",
+	ErrCodeInvalidFileSize:       "不合法的文件大小",
+	ErrCodeInvalidMediaID:        "不合法的媒体文件id",
+	ErrCodeInvalidImageSize:      "不合法的图片文件大小",
+	ErrCodeInvalidVoiceSize:      "不合法的语音文件大小",
+	ErrCodeInvalidVideoSize:      "不合法的视频文件大小",
+	ErrCodeInvalidThumbSize:      "不合法的缩略图文件大小",
+	ErrCodeInvalidAppID:          "不合法的AppID",
+	ErrCodeInvalidAccessToken:    "不合法的access_token",
+	ErrCodeInvalidAppSecret:      "不合法的AppSecret",
+	ErrCodeInvalidIP:             "调用接口的IP地址不在白名单中",
+	ErrCodeAccessTokenMissing:    "缺少access_token参数",
+	ErrCodeAccessTokenExpired:    "access_token超时",
+	ErrCodeRequireSubscribe:      "需要接收者关注",
+	ErrCodeMediaSizeOutOfLimit:   "多媒体文件大小超过限制",
+	ErrCodeContentSizeOutOfLimit: "消息内容超过限制",
+	ErrCodeAPIFreqOutOfLimit:     "接口调用超过每日限制",
+	ErrCodeAPIMinuteOutOfLimit:   "接口调用太频繁",
+	ErrCodeResponseOutOfTime:     "回复时间超过限制",
+	ErrCodeOutOfResponseCount:    "客服接口下行条数超过上限",
+	ErrCodeMenuNotExist:          "不存在菜单数据",
+	ErrCodeUserNotExist:          "不存在的用户",
+	ErrCodeAPIUnauthorized:       "api功能未授权",
+	ErrCodeUserUnauthorized:      "用户未授权该api",
+}
+
+// ErrText 返回errcode的中文说明，未知的返回码返回空字符串
+func ErrText(errcode int) string {
+	return errMessages[errcode]
+}
+
+// APIError 公众平台接口返回的errcode不为0时的错误
+type APIError struct {
+	// Path 接口的路径
+	Path string
+	// ErrCode 错误代码
+	ErrCode int `json:"errcode"`
+	// ErrMsg 错误信息
+	ErrMsg string `json:"errmsg"`
+}
+
+// Error 实现error接口
+func (e *APIError) Error() string {
+	s := fmt.Sprintf("errcode: %d, errmsg: %s", e.ErrCode, e.ErrMsg)
+	if text := ErrText(e.ErrCode); text != "" {
+		s += " (" + text + ")"
+	}
+	if e.Path != "" {
+		s = e.Path + ": " + s
+	}
+	return s
+}
+
+// CheckResponse 检查json响应b中的errcode，不为0时返回*APIError
+func CheckResponse(path string, b []byte) error {
+	var st status
+	if json.Unmarshal(b, &st) != nil || st.ErrCode == ErrCodeOK {
+		return nil
+	}
+	return &APIError{Path: path, ErrCode: st.ErrCode, ErrMsg: st.ErrMsg}
+}
+
+// ErrCode 返回err中*APIError的错误代码，err不包含*APIError时返回0
+func ErrCode(err error) int {
+	var e *APIError
+	if errors.As(err, &e) {
+		return e.ErrCode
+	}
+	return ErrCodeOK
+}
+
+// IsTokenInvalid errcode说明access_token已经失效时返回true
+func IsTokenInvalid(errcode int) bool {
+	switch errcode {
+	case ErrCodeInvalidCredential, ErrCodeInvalidAccessToken, ErrCodeAccessTokenExpired:
+		return true
+	}
+	return false
+}
+
+// IsTokenExpired err说明access_token已经失效或者超时
+func IsTokenExpired(err error) bool {
+	return IsTokenInvalid(ErrCode(err))
+}
+
+// IsRateLimited err说明接口调用超过频率或者每日次数的限制
+func IsRateLimited(err error) bool {
+	switch ErrCode(err) {
+	case ErrCodeAPIFreqOutOfLimit, ErrCodeAPIMinuteOutOfLimit, ErrCodeOutOfResponseCount:
+		return true
+	}
+	return false
+}
+
+// IsSystemBusy err说明微信服务器繁忙，可以稍候重试
+func IsSystemBusy(err error) bool {
+	return ErrCode(err) == ErrCodeSystemBusy
+}
+
+// IsInvalidOpenID err说明OpenID不合法或者用户不存在
+func IsInvalidOpenID(err error) bool {
+	switch ErrCode(err) {
+	case ErrCodeInvalidOpenID, ErrCodeUserNotExist:
+		return true
+	}
+	return false
+}
+
+// IsMediaTooLarge err说明上传的文件或者消息内容超过大小限制
+func IsMediaTooLarge(err error) bool {
+	switch ErrCode(err) {
+	case ErrCodeInvalidFileSize, ErrCodeInvalidImageSize, ErrCodeInvalidVoiceSize,
+		ErrCodeInvalidVideoSize, ErrCodeInvalidThumbSize,
+		ErrCodeMediaSizeOutOfLimit, ErrCodeContentSizeOutOfLimit:
+		return true
+	}
+	return false
+}
+
+// IsUnauthorized err说明公众号或者用户没有调用接口的权限
+func IsUnauthorized(err error) bool {
+	switch ErrCode(err) {
+	case ErrCodeAPIUnauthorized, ErrCodeUserUnauthorized, ErrCodeInvalidIP:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestAPIError(t *testing.T) {
+	if err := CheckResponse("cgi-bin/test", []byte(`{"errcode":0,"errmsg":"ok"}`)); err != nil {
+		t.Fatalf("errcode 0: %s", err)
+	}
+	if err := CheckResponse("cgi-bin/test", []byte(`{"access_token":"token"}`)); err != nil {
+		t.Fatalf("no errcode: %s", err)
+	}
+	err := CheckResponse("cgi-bin/test", []byte(`{"errcode":45009,"errmsg":"reach max api daily quota limit"}`))
+	// 被其他错误包装后仍然可以取得*APIError
+	err = fmt.Errorf("send message: %w", err)
+	var e *APIError
+	if !errors.As(err, &e) || e.ErrCode != ErrCodeAPIFreqOutOfLimit || e.Path != "cgi-bin/test" {
+		t.Fatalf("errors.As: %#v", e)
+	}
+	t.Log(err)
+
+	tests := []struct {
+		errcode int
+		is      func(error) bool
+	}{
+		{ErrCodeAccessTokenExpired, IsTokenExpired},
+		{ErrCodeInvalidAccessToken, IsTokenExpired},
+		{ErrCodeAPIFreqOutOfLimit, IsRateLimited},
+		{ErrCodeAPIMinuteOutOfLimit, IsRateLimited},
+		{ErrCodeSystemBusy, IsSystemBusy},
+		{ErrCodeInvalidOpenID, IsInvalidOpenID},
+		{ErrCodeMediaSizeOutOfLimit, IsMediaTooLarge},
+		{ErrCodeAPIUnauthorized, IsUnauthorized},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("wrapped: %w", &APIError{ErrCode: tt.errcode})
+		if !tt.is(err) {
+			t.Errorf("errcode %d not matched", tt.errcode)
+		}
+		if tt.is(errors.New("other")) || tt.is(nil) {
+			t.Errorf("errcode %d: matched non-API error", tt.errcode)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRateLimited 调用频率超过Limit.Rate，Limiter.Wait为false时返回
+	ErrRateLimited = errors.New("rate limited")
+	// ErrQuotaExceeded 当天的调用次数已经达到Limit.Daily
+	ErrQuotaExceeded = errors.New("daily quota exceeded")
+)
+
+// QuotaLocation 公众平台每日调用次数在北京时间0点清零
+var QuotaLocation = time.FixedZone("CST", 8*60*60)
+
+// Limit 一个接口的调用限制
+type Limit struct {
+	// Rate 每秒允许的调用次数，0表示不限制
+	Rate float64
+	// Burst 令牌桶的容量，允许的突发调用次数，小于1时为1
+	Burst int
+	// Daily 每日允许的调用次数，0表示不限制，通常设置为略小于公众平台的配额
+	Daily int
+}
+
+// Usage 一个接口当天的调用情况
+type Usage struct {
+	// Path 接口的路径
+	Path string `json:"path"`
+	// Day 统计的日期，格式为2006-01-02
+	Day string `json:"day"`
+	// Count 当天已经调用的次数
+	Count int `json:"count"`
+	// Daily 每日允许的调用次数，0表示不限制
+	Daily int `json:"daily"`
+	// Rejected 当天被Limiter拒绝的次数
+	Rejected int `json:"rejected"`
+	// Exhausted 公众平台已经返回了45009，当天不再调用
+	Exhausted bool `json:"exhausted"`
+}
+
+// endpoint 一个接口的令牌桶和计数
+type endpoint struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+	usage  Usage
+}
+
+// Limiter 按接口路径限制调用频率，并统计每日调用次数，
+// 可以被多个goroutine同时使用
+type Limiter struct {
+	// Wait 为true时超过频率的调用排队等待，否则立即返回ErrRateLimited；
+	// 超过每日次数的调用总是立即返回ErrQuotaExceeded
+	Wait bool
+
+	mu        sync.Mutex
+	now       func() time.Time
+	limits    map[string]Limit
+	endpoints map[string]*endpoint
+}
+
+// NewLimiter 创建*Limiter，limits的key是接口的路径，例如cgi-bin/menu/create，
+// 也可以是路径的前缀，例如cgi-bin/menu，这时前缀下的每个接口分别使用同样的限制
+func NewLimiter(limits map[string]Limit) *Limiter {
+	l := &Limiter{
+		now:       time.Now,
+		limits:    make(map[string]Limit),
+		endpoints: make(map[string]*endpoint),
+	}
+	for path, limit := range limits {
+		l.limits[strings.Trim(path, "/")] = limit
+	}
+	return l
+}
+
+// SetLimit 设置path的调用限制
+func (l *Limiter) SetLimit(path string, limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	path = strings.Trim(path, "/")
+	l.limits[path] = limit
+	// 已经创建的接口重新查找限制
+	for p, ep := range l.endpoints {
+		ep.limit = l.lookup(p)
+		ep.usage.Daily = ep.limit.Daily
+	}
+}
+
+// lookup 查找path的限制，先查找完整路径，再按照路径的分段查找最长的前缀
+func (l *Limiter) lookup(path string) Limit {
+	for p := path; p != ""; {
+		if limit, ok := l.limits[p]; ok {
+			return limit
+		}
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return Limit{}
+}
+
+// endpoint 调用时必须持有l.mu
+func (l *Limiter) endpoint(path string, now time.Time) *endpoint {
+	ep, ok := l.endpoints[path]
+	if !ok {
+		limit := l.lookup(path)
+		ep = &endpoint{limit: limit, last: now, usage: Usage{Path: path, Daily: limit.Daily}}
+		ep.tokens = float64(ep.burst())
+		l.endpoints[path] = ep
+	}
+	// 跨过北京时间0点后重新计数
+	if day := now.In(QuotaLocation).Format("2006-01-02"); ep.usage.Day != day {
+		ep.usage = Usage{Path: path, Day: day, Daily: ep.limit.Daily}
+	}
+	return ep
+}
+
+func (ep *endpoint) burst() int {
+	if ep.limit.Burst < 1 {
+		return 1
+	}
+	return ep.limit.Burst
+}
+
+// reserve 取得一个令牌，返回需要等待的时间，调用时必须持有l.mu
+func (ep *endpoint) reserve(now time.Time) time.Duration {
+	if ep.limit.Rate <= 0 {
+		return 0
+	}
+	ep.tokens += now.Sub(ep.last).Seconds() * ep.limit.Rate
+	if max := float64(ep.burst()); ep.tokens > max {
+		ep.tokens = max
+	}
+	ep.last = now
+	ep.tokens--
+	if ep.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-ep.tokens / ep.limit.Rate * float64(time.Second))
+}
+
+// Allow 在调用path之前检查限制并计数，超过每日次数时返回ErrQuotaExceeded，
+// 超过频率时按照l.Wait等待或者返回ErrRateLimited，ctx取消时返回ctx.Err()
+func (l *Limiter) Allow(ctx context.Context, path string) error {
+	path = strings.Trim(path, "/")
+	l.mu.Lock()
+	now := l.now()
+	ep := l.endpoint(path, now)
+	if ep.usage.Exhausted || ep.limit.Daily > 0 && ep.usage.Count >= ep.limit.Daily {
+		ep.usage.Rejected++
+		l.mu.Unlock()
+		return fmt.Errorf("%s: %w", path, ErrQuotaExceeded)
+	}
+	wait := ep.reserve(now)
+	if wait > 0 && !l.Wait {
+		// 没有使用令牌，归还
+		ep.tokens++
+		ep.usage.Rejected++
+		l.mu.Unlock()
+		return fmt.Errorf("%s: %w", path, ErrRateLimited)
+	}
+	ep.usage.Count++
+	l.mu.Unlock()
+	if wait > 0 {
+		if err := sleep(ctx, wait); err != nil {
+			l.cancel(path)
+			return err
+		}
+	}
+	return nil
+}
+
+// cancel 等待中的调用被取消，归还令牌和计数
+func (l *Limiter) cancel(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ep := l.endpoint(path, l.now())
+	ep.tokens++
+	if ep.usage.Count > 0 {
+		ep.usage.Count--
+	}
+}
+
+// Exhaust 公众平台返回45009时调用，当天不再允许调用path
+func (l *Limiter) Exhaust(path string) {
+	path = strings.Trim(path, "/")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.endpoint(path, l.now()).usage.Exhausted = true
+}
+
+// Usage 返回path当天的调用情况
+func (l *Limiter) Usage(path string) Usage {
+	path = strings.Trim(path, "/")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.endpoint(path, l.now()).usage
+}
+
+// Usages 返回所有调用过的接口当天的调用情况，按照路径排序，可用于监控
+func (l *Limiter) Usages() []Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	usages := make([]Usage, 0, len(l.endpoints))
+	for path := range l.endpoints {
+		usages = append(usages, l.endpoint(path, now).usage)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Path < usages[j].Path })
+	return usages
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2018, 3, 18, 23, 59, 0, 0, QuotaLocation)
+	l := NewLimiter(map[string]Limit{
+		"cgi-bin/menu":                {Daily: 2},
+		"cgi-bin/message/custom/send": {Rate: 10, Burst: 2},
+	})
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// 前缀cgi-bin/menu下的接口分别计数
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(ctx, "cgi-bin/menu/create"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Allow(ctx, "cgi-bin/menu/create"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("err = %v, want ErrQuotaExceeded", err)
+	}
+	if err := l.Allow(ctx, "cgi-bin/menu/get"); err != nil {
+		t.Fatal(err)
+	}
+	u := l.Usage("cgi-bin/menu/create")
+	if u.Count != 2 || u.Daily != 2 || u.Rejected != 1 || u.Day != "2018-03-18" {
+		t.Fatalf("usage %+v", u)
+	}
+
+	// 令牌桶
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(ctx, "cgi-bin/message/custom/send"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Allow(ctx, "cgi-bin/message/custom/send"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	now = now.Add(100 * time.Millisecond)
+	if err := l.Allow(ctx, "cgi-bin/message/custom/send"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 公众平台返回45009后当天不再调用
+	l.Exhaust("cgi-bin/user/info")
+	if err := l.Allow(ctx, "cgi-bin/user/info"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("err = %v, want ErrQuotaExceeded", err)
+	}
+
+	// 北京时间0点后重新计数
+	now = now.Add(time.Minute)
+	if err := l.Allow(ctx, "cgi-bin/menu/create"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow(ctx, "cgi-bin/user/info"); err != nil {
+		t.Fatal(err)
+	}
+	usages := l.Usages()
+	if len(usages) != 4 || usages[0].Path != "cgi-bin/menu/create" || usages[0].Count != 1 {
+		t.Fatalf("usages %+v", usages)
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	l := NewLimiter(map[string]Limit{"cgi-bin/test": {Rate: 50}})
+	l.Wait = true
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Allow(context.Background(), "cgi-bin/test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("3 calls at 50/s took %s", d)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Allow(ctx, "cgi-bin/test"); err == nil {
+		t.Fatal("expected context canceled")
+	}
+	if u := l.Usage("cgi-bin/test"); u.Count != 3 {
+		t.Fatalf("usage %+v", u)
+	}
+}
+
+func TestClientLimiter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errcode":45009,"errmsg":"reach max api daily quota limit"}`)
+	}))
+	defer srv.Close()
+	c := NewClient("", StaticToken("token"))
+	c.BaseURL = srv.URL
+	c.Limiter = NewLimiter(nil)
+	if err := c.Get(context.Background(), "cgi-bin/test", nil, nil); ErrCode(err) != ErrCodeAPIFreqOutOfLimit {
+		t.Fatalf("err = %v, want 45009", err)
+	}
+	if err := c.Get(context.Background(), "cgi-bin/test", nil, nil); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("err = %v, want ErrQuotaExceeded", err)
+	}
+	if u := c.Limiter.Usage("cgi-bin/test"); u.Count != 1 || !u.Exhausted {
+		t.Fatalf("usage %+v", u)
+	}
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Logger 分级的结构化日志接口，方法与log/slog的*slog.Logger相同，
+// 可以直接使用slog.Default()，args是交替的key和value
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// nopLogger 不输出任何日志
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// NopLogger 不输出任何日志的Logger，未设置Logger时使用
+var NopLogger Logger = nopLogger{}
+
+// withArgs 每条日志都添加固定字段的Logger
+type withArgs struct {
+	l    Logger
+	args []interface{}
+}
+
+func (w *withArgs) join(args []interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(w.args)+len(args)), w.args...), args...)
+}
+
+func (w *withArgs) Debug(msg string, args ...interface{}) { w.l.Debug(msg, w.join(args)...) }
+func (w *withArgs) Info(msg string, args ...interface{})  { w.l.Info(msg, w.join(args)...) }
+func (w *withArgs) Warn(msg string, args ...interface{})  { w.l.Warn(msg, w.join(args)...) }
+func (w *withArgs) Error(msg string, args ...interface{}) { w.l.Error(msg, w.join(args)...) }
+
+// WithArgs 返回每条日志都添加args字段的Logger，例如appid，l为nil时返回NopLogger
+func WithArgs(l Logger, args ...interface{}) Logger {
+	if l == nil || l == NopLogger {
+		return NopLogger
+	}
+	if len(args) == 0 {
+		return l
+	}
+	return &withArgs{l, args}
+}
+
+// HashOpenID 返回openid的sha256摘要的前16个字符，用于日志中关联同一个用户而不记录openid
+func HashOpenID(openid string) string {
+	if openid == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(openid))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Redact 隐藏access_token、AppSecret等敏感字符串，只保留前4个字符用于区分
+func Redact(secret string) string {
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return secret[:4] + "****"
+}
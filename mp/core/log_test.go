@@ -0,0 +1,52 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// *slog.Logger可以直接作为Logger使用
+var _ Logger = slog.Default()
+
+func TestClientLogger(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errcode":40003,"errmsg":"invalid openid"}`)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c := NewClient("", StaticToken("secret-token"))
+	c.BaseURL = srv.URL
+	c.Logger = WithArgs(l, "appid", "wx123")
+	c.Get(context.Background(), "cgi-bin/user/info", nil, nil)
+
+	out := buf.String()
+	t.Log(out)
+	for _, s := range []string{"appid=wx123", "path=cgi-bin/user/info", "errcode=40003", "latency="} {
+		if !strings.Contains(out, s) {
+			t.Errorf("log missing %q", s)
+		}
+	}
+	if strings.Contains(out, "secret-token") {
+		t.Error("log leaks access_token")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	if s := Redact("ACCESS_TOKEN_VALUE"); s != "ACCE****" {
+		t.Fatal(s)
+	}
+	if s := Redact("abc"); s != "****" {
+		t.Fatal(s)
+	}
+	if h := HashOpenID("oia2TjjewbmiOUlr6X-1crbLOvLw"); len(h) != 16 || h == HashOpenID("other") {
+		t.Fatal(h)
+	}
+}
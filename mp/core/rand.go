@@ -0,0 +1,22 @@
+package core
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// randChars 随机字符串使用的字符
+const randChars = `ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789`
+
+// RandomString 使用crypto/rand生成n个字母和数字组成的随机字符串，
+// 用于消息加密、JS-SDK签名的nonce和网页授权的state，读取随机数失败时panic
+func RandomString(n int) string {
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, bs); err != nil {
+		panic("weixin: read random bytes: " + err.Error())
+	}
+	for i := range bs {
+		bs[i] = randChars[int(bs[i])%len(randChars)]
+	}
+	return string(bs)
+}
@@ -0,0 +1,18 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRandomString(t *testing.T) {
+	s := RandomString(32)
+	if len(s) != 32 || s == RandomString(32) {
+		t.Fatal(s)
+	}
+	for _, c := range s {
+		if !strings.ContainsRune(randChars, c) {
+			t.Fatalf("%q contains %q", s, c)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy 接口调用失败后的重试策略，使用指数退避和随机抖动计算等待时间
+type RetryPolicy struct {
+	// MaxAttempts 最多调用的次数，包括第一次调用，小于等于1时不重试
+	MaxAttempts int
+	// MinBackoff 第一次重试前的等待时间，之后每次重试加倍
+	MinBackoff time.Duration
+	// MaxBackoff 等待时间的最大值
+	MaxBackoff time.Duration
+	// Jitter 随机抖动的比例，取值0到1，实际等待时间在(1-Jitter)*backoff到backoff之间
+	Jitter float64
+	// Codes 可以重试的errcode，为nil时使用DefaultRetryCodes
+	Codes []int
+}
+
+// DefaultRetryCodes 默认重试的errcode：系统繁忙和调用太频繁，
+// 45009是每日调用次数超过限制，当天内重试没有意义，需要时可以加入Codes
+var DefaultRetryCodes = []int{ErrCodeSystemBusy, ErrCodeAPIMinuteOutOfLimit}
+
+// DefaultRetryPolicy NewClient使用的默认重试策略
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  200 * time.Millisecond,
+	MaxBackoff:  5 * time.Second,
+	Jitter:      0.2,
+}
+
+// NoRetry 不重试的策略
+var NoRetry = &RetryPolicy{MaxAttempts: 1}
+
+// backoff 第attempt次调用失败后的等待时间，attempt从1开始
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 && d > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// retryCode errcode是否在可以重试的列表中
+func (p *RetryPolicy) retryCode(errcode int) bool {
+	codes := p.Codes
+	if codes == nil {
+		codes = DefaultRetryCodes
+	}
+	for _, code := range codes {
+		if code == errcode {
+			return true
+		}
+	}
+	return false
+}
+
+// isRejected 返回码说明微信服务器拒绝了请求，没有执行接口的操作，
+// 这时即使是不幂等的请求也可以安全的重试
+func isRejected(errcode int) bool {
+	switch errcode {
+	case ErrCodeAPIFreqOutOfLimit, ErrCodeAPIMinuteOutOfLimit:
+		return true
+	}
+	return IsTokenInvalid(errcode)
+}
+
+// isDialError 连接服务器失败，请求没有发送出去
+func isDialError(err error) bool {
+	var operr *net.OpError
+	return errors.As(err, &operr) && operr.Op == "dial"
+}
+
+// idempotent 请求可以重复发送，GET请求以及设置了Idempotent的请求
+func (req *Request) idempotent() bool {
+	return req.Idempotent || req.Method == "" || req.Method == "GET" || req.Method == "HEAD"
+}
+
+// shouldRetry 检查一次调用的结果是否需要重试，需要读取响应时替换res.Body以便调用者再次读取
+func (p *RetryPolicy) shouldRetry(ctx context.Context, req *Request, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		// 连接失败时请求没有到达服务器，其他网络错误只重试幂等的请求
+		if isDialError(err) {
+			return true
+		}
+		var nerr net.Error
+		return req.idempotent() && errors.As(err, &nerr)
+	}
+	if res.StatusCode >= http.StatusInternalServerError {
+		return req.idempotent()
+	}
+	if res.StatusCode != http.StatusOK || !IsJSON(res) {
+		return false
+	}
+	b, err := readBody(res)
+	if err != nil {
+		return false
+	}
+	errcode := ErrCode(CheckResponse(req.Path, b))
+	if errcode == ErrCodeOK || !p.retryCode(errcode) {
+		return false
+	}
+	return req.idempotent() || isRejected(errcode)
+}
+
+// sleep 等待d，ctx取消时提前返回ctx.Err()
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
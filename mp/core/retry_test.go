@@ -0,0 +1,88 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	// 前两次调用返回errcode，之后成功
+	var errcode int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			fmt.Fprintf(w, `{"errcode":%d,"errmsg":"retry"}`, errcode)
+			return
+		}
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+	}))
+	defer srv.Close()
+
+	c := NewClient("", StaticToken("token"))
+	c.BaseURL = srv.URL
+	c.Retry = &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Jitter: 0.5}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		errcode int
+		call    func() error
+		calls   int32
+		ok      bool
+	}{
+		{"get system busy", ErrCodeSystemBusy, func() error { return c.Get(ctx, "cgi-bin/test", nil, nil) }, 3, true},
+		{"post system busy", ErrCodeSystemBusy, func() error { return c.PostJSON(ctx, "cgi-bin/test", nil, nil, nil) }, 1, false},
+		{"query system busy", ErrCodeSystemBusy, func() error { return c.QueryJSON(ctx, "cgi-bin/test", nil, nil, nil) }, 3, true},
+		{"post rate limited", ErrCodeAPIMinuteOutOfLimit, func() error { return c.PostJSON(ctx, "cgi-bin/test", nil, nil, nil) }, 3, true},
+		{"daily quota", ErrCodeAPIFreqOutOfLimit, func() error { return c.Get(ctx, "cgi-bin/test", nil, nil) }, 1, false},
+		{"invalid openid", ErrCodeInvalidOpenID, func() error { return c.Get(ctx, "cgi-bin/test", nil, nil) }, 1, false},
+		{"no retry", ErrCodeSystemBusy, func() error { return c.Call(ctx, &Request{Path: "cgi-bin/test", NoRetry: true}, nil) }, 1, false},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&calls, 0)
+		errcode = tt.errcode
+		err := tt.call()
+		if got := atomic.LoadInt32(&calls); got != tt.calls {
+			t.Errorf("%s: %d calls, want %d", tt.name, got, tt.calls)
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if d := p.backoff(i + 1); d != w*time.Millisecond {
+			t.Errorf("attempt %d: backoff %s, want %s", i+1, d, w*time.Millisecond)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 1; i < 10; i++ {
+		if d := p.backoff(3); d < 200*time.Millisecond || d > 400*time.Millisecond {
+			t.Fatalf("backoff with jitter %s", d)
+		}
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errcode":-1,"errmsg":"system error"}`)
+	}))
+	defer srv.Close()
+	c := NewClient("", StaticToken("token"))
+	c.BaseURL = srv.URL
+	c.Retry = &RetryPolicy{MaxAttempts: 10, MinBackoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Get(ctx, "cgi-bin/test", nil, nil); err == nil || ctx.Err() == nil {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+}
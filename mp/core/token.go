@@ -0,0 +1,31 @@
+// Package core 公众平台各接口共用的基础部分：调用接口凭证access_token的来源和调用接口的客户端
+package core
+
+import (
+	"context"
+	"errors"
+)
+
+// TokenSource 提供调用接口的凭证access_token
+type TokenSource interface {
+	// Token 返回当前有效的access_token
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenRefresher 可以强制刷新access_token的TokenSource
+type TokenRefresher interface {
+	TokenSource
+	// Refresh 作废old并返回新的access_token，如果old已经被其他调用者替换，直接返回当前的access_token
+	Refresh(ctx context.Context, old string) (string, error)
+}
+
+// StaticToken 固定的access_token，用于兼容直接传入access_token字符串的函数
+type StaticToken string
+
+// Token 实现TokenSource接口
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	if t == "" {
+		return "", errors.New("access_token is empty")
+	}
+	return string(t), nil
+}
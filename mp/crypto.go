@@ -5,15 +5,16 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/xml"
-	"errors"
 	"fmt"
 	"io"
-	mrand "math/rand"
 	"strconv"
 	"time"
+
+	"qingtao/weixin/mp/core"
 )
 
 const (
@@ -25,26 +26,29 @@ const (
 	wxAESHeader = 16
 	//xml内容的大小网络长度
 	wxAESLength = 4
-
-	//用于随机数
-	randString = `ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789`
 )
 
 // NewCipherBlock 根据生成AES密钥
 func NewCipherBlock(encodingAESKey string) (cipher.Block, error) {
+	block, _, err := newCipher(encodingAESKey)
+	return block, err
+}
+
+// newCipher 返回AES密钥生成的cipher.Block和CBC模式的iv，iv是AES密钥的前16字节
+func newCipher(encodingAESKey string) (cipher.Block, []byte, error) {
 	if len(encodingAESKey) != 43 {
-		return nil, errors.New("EncodingAESKey must be 43 bytes")
+		return nil, nil, fmt.Errorf("%w: EncodingAESKey must be 43 bytes", ErrIllegalAESKey)
 	}
 	// AES密钥： AESKey=Base64_Decode(EncodingAESKey + “=”), EncodingAESKey尾部填充一个字符的“=”, 用Base64_Decode生成32个字节的AESKey；
 	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("%w: %s", ErrIllegalAESKey, err)
 	}
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("%w: %s", ErrIllegalAESKey, err)
 	}
-	return block, nil
+	return block, key[:aes.BlockSize], nil
 }
 
 // EncryptMessage 微信服务器推送的加密消息
@@ -71,16 +75,6 @@ type EncryptResponse struct {
 	Nonce CDATA
 }
 
-// random生成随机字符串
-func random(n int) []byte {
-	bs := make([]byte, n)
-	mrand.Seed(time.Now().UnixNano())
-	for i := 0; i < len(bs); i++ {
-		bs[i] = randString[mrand.Intn(len(randString))]
-	}
-	return bs
-}
-
 // NewEncryptResponse 使用appid、token、nonce和ciphertext生成加密的应答消息
 func NewEncryptResponse(appid, token, timestamp, nonce, ciphertext string) *EncryptResponse {
 	// 如果timestamp为空，使用当前时间的unix时间戳设置timestamp
@@ -89,7 +83,7 @@ func NewEncryptResponse(appid, token, timestamp, nonce, ciphertext string) *Encr
 	}
 	// 如果nonce为空，生成随机字符串
 	if nonce == "" {
-		nonce = string(random(wxNonceLength))
+		nonce = core.RandomString(wxNonceLength)
 	}
 	// 生成签名
 	signature := Sign(token, timestamp, nonce, ciphertext)
@@ -102,102 +96,204 @@ func NewEncryptResponse(appid, token, timestamp, nonce, ciphertext string) *Encr
 	}
 }
 
-// Decrypt 解密密文
+// Decrypt 解密密文，返回包含随机字符、长度、消息、appid和PKCS#7填充的明文，
+// 使用ParseDecryptMessage解析
 func Decrypt(key, ciphertext string) ([]byte, error) {
-	// base64解码密文
-	bs, err := base64.StdEncoding.DecodeString(ciphertext)
+	block, iv, err := newCipher(key)
 	if err != nil {
-		return nil, fmt.Errorf("decrypt ciphertext %s", err)
+		return nil, err
 	}
+	return decrypt(block, iv, ciphertext)
+}
 
-	block, err := NewCipherBlock(key)
+// decrypt 使用block和iv解密base64编码的密文
+func decrypt(block cipher.Block, iv []byte, ciphertext string) ([]byte, error) {
+	// base64解码密文
+	bs, err := base64.StdEncoding.DecodeString(ciphertext)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s", ErrDecodeBase64, err)
 	}
 	// 加密文本长度必须大于BlockSize
 	if len(bs) < block.BlockSize() {
-		return nil, fmt.Errorf("ciphertext too short: %d", len(bs))
+		return nil, fmt.Errorf("%w: ciphertext too short: %d", ErrDecryptAES, len(bs))
 	}
 	// 加密文本的长度必须是BlockSize的正数倍
 	if len(bs)%block.BlockSize() != 0 {
-		return nil, fmt.Errorf("ciphertext is not a multiple of the block size")
+		return nil, fmt.Errorf("%w: ciphertext is not a multiple of the block size", ErrDecryptAES)
 	}
-	iv := bs[:aes.BlockSize]
-	//bs = bs[aes.BlockSize:]
+	// iv使用AES密钥的前16字节，与官方WXBizMsgCrypt一致
 	mode := cipher.NewCBCDecrypter(block, iv)
 	mode.CryptBlocks(bs, bs)
 	return bs, nil
 }
 
-// ParseDecryptMessage 解析解密后的加密消息的主体和appid
+// ParseDecryptMessage 解析解密后的加密消息的主体和appid，校验PKCS#7填充，
+// 调用者需要比较返回的appid与公众号的AppID
 func ParseDecryptMessage(b []byte) ([]byte, string, error) {
 	textstart := wxAESHeader + wxAESLength
-	buf := bytes.NewReader(b[wxAESHeader:textstart])
-	// PKCS#7填充字符长度
+	if len(b) < textstart || len(b)%aes.BlockSize != 0 {
+		return nil, "", fmt.Errorf("%w: decrypted message length %d", ErrIllegalBuffer, len(b))
+	}
+	// PKCS#7填充字符长度，按照32字节填充，取值为1到32
 	padlen := int(b[len(b)-1])
-	// 如果padding长度超过32，返回错误
-	if padlen < 0 || padlen > wxAESKeyLength {
-		return nil, "", fmt.Errorf("error read length of padding %d", padlen)
+	if padlen < 1 || padlen > wxAESKeyLength || padlen > len(b)-textstart {
+		return nil, "", fmt.Errorf("%w: length of padding %d", ErrIllegalBuffer, padlen)
 	}
 	padstart := len(b) - padlen
-
-	var length int32
-	if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
-		return nil, "", fmt.Errorf("read content of xml length failed")
+	for _, c := range b[padstart:] {
+		if int(c) != padlen {
+			return nil, "", fmt.Errorf("%w: invalid padding", ErrIllegalBuffer)
+		}
 	}
+
 	// xml内容的长度
-	xmllen := int(length)
-	appidstart := textstart + xmllen
-	if xmllen < 0 || appidstart > padstart {
-		return nil, "", errors.New("read length of content failed")
+	xmllen := int(binary.BigEndian.Uint32(b[wxAESHeader:textstart]))
+	if xmllen > padstart-textstart {
+		return nil, "", fmt.Errorf("%w: length of content %d", ErrIllegalBuffer, xmllen)
 	}
+	appidstart := textstart + xmllen
 	return b[textstart:appidstart], string(b[appidstart:padstart]), nil
 }
 
-// Encrypt 加密普通文本
+// Encrypt 加密普通文本，前16字节使用随机字符
 func Encrypt(key, appid string, plaintext []byte) (string, error) {
-	block, err := NewCipherBlock(key)
+	block, iv, err := newCipher(key)
 	if err != nil {
 		return "", err
 	}
+	return encrypt(block, iv, rand.Reader, appid, plaintext)
+}
+
+// encrypt 使用block和iv加密plaintext，从r读取16字节随机字符
+func encrypt(block cipher.Block, iv []byte, r io.Reader, appid string, plaintext []byte) (string, error) {
 	// 随机16位字符
 	rb := make([]byte, wxAESHeader)
-	if _, err := io.ReadFull(rand.Reader, rb); err != nil {
-		return "", fmt.Errorf("encrypt when read 16 rand bytes %s", err)
-	}
-	var buf bytes.Buffer
-	if err := binary.Write(&buf, binary.BigEndian, int32(len(plaintext))); err != nil {
-		return "", fmt.Errorf("encrypt when generate len(plaintext)")
+	if _, err := io.ReadFull(r, rb); err != nil {
+		return "", fmt.Errorf("%w: read 16 rand bytes %s", ErrEncryptAES, err)
 	}
-	// 消息的网络长度4字节
-	length := make([]byte, wxAESLength)
-	copy(length, buf.Bytes())
+	// PKCS#7补齐位数，按照32字节填充，已经对齐时填充32个字节
+	size := wxAESHeader + wxAESLength + len(plaintext) + len(appid)
+	n := wxAESKeyLength - size%wxAESKeyLength
 
-	buf.Reset()
+	buf := make([]byte, 0, size+n)
 	// 写入16位随机字符
-	buf.Write(rb)
+	buf = append(buf, rb...)
 	// 写入4位网络长度
-	buf.Write(length)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(plaintext)))
 	// 写入消息文本
-	buf.Write(plaintext)
+	buf = append(buf, plaintext...)
 	// 写入appid
-	buf.Write([]byte(appid))
-	plaintext = buf.Bytes()
-
-	// PKCS#7补齐位数
-	n := wxAESKeyLength - len(plaintext)%wxAESKeyLength
-	// 如果位数是32的整数,填充数32位
-	if n == 0 {
-		n = wxNonceLength
-	}
+	buf = append(buf, appid...)
 	// n个相同byte(n)
-	padding := bytes.Repeat([]byte{byte(n)}, n)
-	plaintext = append(plaintext, padding...)
+	buf = append(buf, bytes.Repeat([]byte{byte(n)}, n)...)
 
-	// iv使用前16位随机的字符
-	iv := plaintext[:aes.BlockSize]
+	// iv使用AES密钥的前16字节，与官方WXBizMsgCrypt一致
 	mode := cipher.NewCBCEncrypter(block, iv)
-	mode.CryptBlocks(plaintext, plaintext)
+	mode.CryptBlocks(buf, buf)
 	// 返回base64编码的加密文本
-	return base64.StdEncoding.EncodeToString(plaintext), nil
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// CryptError 消息加解密的错误，Code与官方WXBizMsgCrypt的错误码相同
+type CryptError struct {
+	// Code 错误码
+	Code int
+	// Msg 错误信息
+	Msg string
+}
+
+// Error 实现error接口
+func (e *CryptError) Error() string {
+	return fmt.Sprintf("wxbizmsgcrypt %d: %s", e.Code, e.Msg)
+}
+
+// 消息加解密的错误，使用errors.Is判断，使用errors.As取得错误码
+var (
+	// ErrValidateSignature 签名校验失败
+	ErrValidateSignature = &CryptError{-40001, "validate signature error"}
+	// ErrParseXML 解析加密消息的xml失败
+	ErrParseXML = &CryptError{-40002, "parse xml error"}
+	// ErrIllegalAESKey EncodingAESKey非法
+	ErrIllegalAESKey = &CryptError{-40004, "illegal aes key"}
+	// ErrValidateAppID 解密后的appid与公众号的AppID不一致
+	ErrValidateAppID = &CryptError{-40005, "validate appid error"}
+	// ErrEncryptAES 加密失败
+	ErrEncryptAES = &CryptError{-40006, "encrypt aes error"}
+	// ErrDecryptAES 解密失败
+	ErrDecryptAES = &CryptError{-40007, "decrypt aes error"}
+	// ErrIllegalBuffer 解密后的明文格式错误，包括填充和长度
+	ErrIllegalBuffer = &CryptError{-40008, "illegal buffer"}
+	// ErrDecodeBase64 密文base64解码失败
+	ErrDecodeBase64 = &CryptError{-40010, "decode base64 error"}
+)
+
+// MsgCrypt 与官方WXBizMsgCrypt兼容的消息加解密，可以被多个goroutine同时使用
+type MsgCrypt struct {
+	token string
+	appid string
+	block cipher.Block
+	iv    []byte
+	// rand 加密时读取随机字符，测试时替换
+	rand io.Reader
+}
+
+// NewMsgCrypt 使用服务器配置的token、EncodingAESKey和公众号的appid创建*MsgCrypt
+func NewMsgCrypt(token, encodingAESKey, appid string) (*MsgCrypt, error) {
+	block, iv, err := newCipher(encodingAESKey)
+	if err != nil {
+		return nil, err
+	}
+	return &MsgCrypt{token: token, appid: appid, block: block, iv: iv, rand: rand.Reader}, nil
+}
+
+// Decrypt 解密base64编码的密文，校验填充和appid，返回消息明文
+func (c *MsgCrypt) Decrypt(ciphertext string) ([]byte, error) {
+	b, err := decrypt(c.block, c.iv, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, appid, err := ParseDecryptMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(appid), []byte(c.appid)) != 1 {
+		return nil, fmt.Errorf("%w: %q", ErrValidateAppID, appid)
+	}
+	return plaintext, nil
+}
+
+// Encrypt 加密消息明文，返回base64编码的密文
+func (c *MsgCrypt) Encrypt(plaintext []byte) (string, error) {
+	return encrypt(c.block, c.iv, c.rand, c.appid, plaintext)
+}
+
+// DecryptMessage 校验msg_signature并解密微信服务器推送的加密消息，
+// body是请求的内容，返回消息明文，与WXBizMsgCrypt的DecryptMsg相同
+func (c *MsgCrypt) DecryptMessage(msgSignature, timestamp, nonce string, body []byte) ([]byte, error) {
+	var emsg EncryptMessage
+	if err := xml.Unmarshal(body, &emsg); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrParseXML, err)
+	}
+	if emsg.Encrypt == "" {
+		return nil, fmt.Errorf("%w: Encrypt is empty", ErrParseXML)
+	}
+	sign := Sign(c.token, timestamp, nonce, string(emsg.Encrypt))
+	if subtle.ConstantTimeCompare([]byte(sign), []byte(msgSignature)) != 1 {
+		return nil, ErrValidateSignature
+	}
+	return c.Decrypt(string(emsg.Encrypt))
+}
+
+// EncryptMessage 加密被动回复的消息，返回包含Encrypt、MsgSignature、TimeStamp和Nonce的xml，
+// 与WXBizMsgCrypt的EncryptMsg相同，timestamp和nonce为空时自动生成
+func (c *MsgCrypt) EncryptMessage(reply []byte, timestamp, nonce string) ([]byte, error) {
+	ciphertext, err := c.Encrypt(reply)
+	if err != nil {
+		return nil, err
+	}
+	b, err := xml.Marshal(NewEncryptResponse(c.appid, c.token, timestamp, nonce, ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("marshal encrypt response %w", err)
+	}
+	return b, nil
 }
@@ -2,6 +2,8 @@ package mp
 
 import (
 	"encoding/xml"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -90,3 +92,83 @@ func TestAES(t *testing.T) {
 
 	})
 }
+
+// TestMsgCrypt 使用官方WXBizMsgCrypt示例中的token、EncodingAESKey、appid和密文
+func TestMsgCrypt(t *testing.T) {
+	const (
+		// 官方示例密文解密后的16字节随机字符
+		sampleRandom = "89465c840c5f116f"
+		samplePlain  = "<xml><ToUserName><![CDATA[gh_10f6c3c3ac5a]]></ToUserName>\n<FromUserName><![CDATA[oyORnuP8q7ou2gfYjqLzSIWZf0rs]]></FromUserName>\n<CreateTime>1409735668</CreateTime>\n<MsgType><![CDATA[text]]></MsgType>\n<Content><![CDATA[abcdteT]]></Content>\n<MsgId>6054768590064713728</MsgId>\n</xml>"
+	)
+	c, err := NewMsgCrypt(token, encodingAESKey, appid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := c.DecryptMessage(msgSign, timestamp, nonce, []byte(fromXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != samplePlain {
+		t.Fatalf("plaintext %q", plaintext)
+	}
+
+	// 使用同样的随机字符加密，密文与官方示例相同
+	var emsg EncryptMessage
+	if err := xml.Unmarshal([]byte(fromXML), &emsg); err != nil {
+		t.Fatal(err)
+	}
+	c.rand = strings.NewReader(sampleRandom)
+	b, err := c.EncryptMessage(plaintext, timestamp, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<xml><Encrypt><![CDATA[" + string(emsg.Encrypt) + "]]></Encrypt><MsgSignature><![CDATA[" + msgSign +
+		"]]></MsgSignature><TimeStamp>" + timestamp + "</TimeStamp><Nonce><![CDATA[" + nonce + "]]></Nonce></xml>"
+	if string(b) != want {
+		t.Fatalf("encrypt message\n%s\nwant\n%s", b, want)
+	}
+
+	if _, err := c.DecryptMessage("0"+msgSign[1:], timestamp, nonce, []byte(fromXML)); !errors.Is(err, ErrValidateSignature) {
+		t.Fatalf("signature error %v", err)
+	}
+	other, _ := NewMsgCrypt(token, encodingAESKey, "wx0000000000000000")
+	_, err = other.DecryptMessage(msgSign, timestamp, nonce, []byte(fromXML))
+	var cerr *CryptError
+	if !errors.As(err, &cerr) || cerr.Code != -40005 {
+		t.Fatalf("appid error %v", err)
+	}
+	if _, err := NewMsgCrypt(token, "short", appid); !errors.Is(err, ErrIllegalAESKey) {
+		t.Fatalf("aes key error %v", err)
+	}
+}
+
+func TestParseDecryptMessage(t *testing.T) {
+	// 长度已经对齐时填充32个字节
+	text := make([]byte, 2*wxAESKeyLength-wxAESHeader-wxAESLength-len(appid))
+	ciphertext, err := Encrypt(encodingAESKey, appid, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Decrypt(encodingAESKey, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 96 || b[len(b)-1] != 32 {
+		t.Fatalf("padding %d of %d bytes", b[len(b)-1], len(b))
+	}
+	if _, id, err := ParseDecryptMessage(b); err != nil || id != appid {
+		t.Fatalf("parse %q %v", id, err)
+	}
+
+	for _, b := range [][]byte{
+		nil,
+		make([]byte, 10),
+		append(make([]byte, 31), 0),
+		append(make([]byte, 31), 33),
+		append(make([]byte, 30), 1, 2),
+	} {
+		if _, _, err := ParseDecryptMessage(b); !errors.Is(err, ErrIllegalBuffer) {
+			t.Fatalf("parse %v: %v", b, err)
+		}
+	}
+}
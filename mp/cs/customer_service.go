@@ -2,59 +2,80 @@ package cs
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"mime/multipart"
-	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"qingtao/weixin/mp/core"
 )
 
+// Service 客服消息接口，通过*core.Client取得access_token和发送请求
+type Service struct {
+	c *core.Client
+}
+
+// NewService 使用c创建客服消息接口
+func NewService(c *core.Client) *Service {
+	return &Service{c}
+}
+
+// newService 兼容直接传入host和accessToken的函数
+func newService(host, accessToken string) *Service {
+	return NewService(core.NewClient(host, core.StaticToken(accessToken)))
+}
+
 // postAcount 微信客服消息接口管理客服帐号，action: add/update/del
-func postAcount(host, action, accessToken string, acc *Account) (*Response, error) {
-	b, err := json.Marshal(acc)
-	if err != nil {
-		return nil, err
-	}
-	uri := fmt.Sprintf("https://%s/%s/%s/?access_token=%s",
-		host, WxKfPath, action, accessToken)
-	res, err := http.Post(uri, "application/json; charset=utf-8",
-		bytes.NewReader(b))
-	if err != nil {
-		return nil, err
-	}
-	b, err = ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("%s kfacount %s", action, err)
-	}
-	defer res.Body.Close()
+func (s *Service) postAcount(ctx context.Context, action string, acc *Account) (*Response, error) {
 	var status Response
-	if err = json.Unmarshal(b, &status); err != nil {
-		return nil, fmt.Errorf("%s kfacount %s", action, err)
+	path := WxKfPath + "/" + action
+	if err := s.c.PostJSON(ctx, path, nil, acc, &status); err != nil {
+		return nil, fmt.Errorf("%s kfacount %w", action, err)
 	}
 	return &status, nil
 }
 
 // AddAccount 新增客服帐号
-func AddAccount(host, accessToken string, acc *Account) (*Response, error) {
-	return postAcount(host, WxKfAdd, accessToken, acc)
+func (s *Service) AddAccount(acc *Account) (*Response, error) {
+	return s.AddAccountContext(context.Background(), acc)
+}
+
+// AddAccountContext 新增客服帐号，ctx取消时中止请求
+func (s *Service) AddAccountContext(ctx context.Context, acc *Account) (*Response, error) {
+	return s.postAcount(ctx, WxKfAdd, acc)
 }
 
 // UpdateAccount 修改客服帐号
-func UpdateAccount(host, accessToken string, acc *Account) (*Response, error) {
-	return postAcount(host, WxKfUpdate, accessToken, acc)
+func (s *Service) UpdateAccount(acc *Account) (*Response, error) {
+	return s.UpdateAccountContext(context.Background(), acc)
+}
+
+// UpdateAccountContext 修改客服帐号，ctx取消时中止请求
+func (s *Service) UpdateAccountContext(ctx context.Context, acc *Account) (*Response, error) {
+	return s.postAcount(ctx, WxKfUpdate, acc)
 }
 
 // DelAccount 删除客服帐号
-func DelAccount(host, accessToken string, acc *Account) (*Response, error) {
-	return postAcount(host, WxKfDel, accessToken, acc)
+func (s *Service) DelAccount(acc *Account) (*Response, error) {
+	return s.DelAccountContext(context.Background(), acc)
+}
+
+// DelAccountContext 删除客服帐号，ctx取消时中止请求
+func (s *Service) DelAccountContext(ctx context.Context, acc *Account) (*Response, error) {
+	return s.postAcount(ctx, WxKfDel, acc)
 }
 
 // UploadHeadImage 上传客服头像
-func UploadHeadImage(host, accessToken, account, filename string) (*Response, error) {
+func (s *Service) UploadHeadImage(account, filename string) (*Response, error) {
+	return s.UploadHeadImageContext(context.Background(), account, filename)
+}
+
+// UploadHeadImageContext 上传客服头像，ctx取消时中止请求
+func (s *Service) UploadHeadImageContext(ctx context.Context, account, filename string) (*Response, error) {
 	ext := strings.ToLower(filepath.Ext(filename))
 	// 检查图片扩展名
 	if ext != ".jpg" {
@@ -87,86 +108,127 @@ func UploadHeadImage(host, accessToken, account, filename string) (*Response, er
 	// 获取http头部的Content-Type
 	contentType := multiWriter.FormDataContentType()
 
-	uri := fmt.Sprintf("https://%s/%s/%s?access_token=%skf_account=%s",
-		host, WxKfPath, WxKfHeadImg, accessToken, account)
-	res, err := http.Post(uri, contentType, buf)
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
 	var status Response
-	if err := json.Unmarshal(b, &status); err != nil {
+	path := WxKfPath + "/" + WxKfHeadImg
+	query := url.Values{"kf_account": {account}}
+	if err = s.c.Post(ctx, path, query, contentType, buf.Bytes(), &status); err != nil {
 		return nil, err
 	}
 	return &status, nil
 }
 
 // GetList 获取所有客户帐号
-func GetList(host, accessToken string) (*Lists, error) {
-	uri := fmt.Sprintf("https://%s/%s?access_token=%s",
-		host, WxKfGetKfList, accessToken)
-	res, err := http.Get(uri)
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("getkflist %s", err)
-	}
-	defer res.Body.Close()
+func (s *Service) GetList() (*Lists, error) {
+	return s.GetListContext(context.Background())
+}
+
+// GetListContext 获取所有客户帐号，ctx取消时中止请求
+func (s *Service) GetListContext(ctx context.Context) (*Lists, error) {
 	var list Lists
-	if err = json.Unmarshal(b, &list); err != nil {
-		return nil, fmt.Errorf("getkflist %s", err)
+	if err := s.c.Get(ctx, WxKfGetKfList, nil, &list); err != nil {
+		return nil, fmt.Errorf("getkflist %w", err)
 	}
 	return &list, nil
 }
 
 // SendMessage 发送客服消息
-func SendMessage(host, accessToken string, msg *Message) (*Response, error) {
-	b, err := json.Marshal(msg)
-	if err != nil {
-		return nil, fmt.Errorf("send custom message %s", err)
-	}
-	uri := fmt.Sprintf("https://%s/%s?access_token=%s", host,
-		WxKfSend, accessToken)
-	res, err := http.Post(uri, "application/json; charset=utf-8",
-		bytes.NewReader(b))
-	if err != nil {
-		return nil, err
-	}
-	b, err = ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("send custom message %s", err)
-	}
+func (s *Service) SendMessage(msg *Message) (*Response, error) {
+	return s.SendMessageContext(context.Background(), msg)
+}
+
+// SendMessageContext 发送客服消息，ctx取消时中止请求
+func (s *Service) SendMessageContext(ctx context.Context, msg *Message) (*Response, error) {
 	var status Response
-	if err = json.Unmarshal(b, &status); err != nil {
-		return nil, fmt.Errorf("send custom message, read response %s", err)
+	if err := s.c.PostJSON(ctx, WxKfSend, nil, msg, &status); err != nil {
+		return nil, fmt.Errorf("send custom message %w", err)
 	}
 	return &status, nil
 }
 
 // SendTyping 发送输入状态
-func SendTyping(host, accessToken, toUser string) (*Response, error) {
-	typing := `{"touser":"` + toUser + `", "command":"typing"}`
-	uri := fmt.Sprintf("https://%s/%s?access_token=%s", host,
-		WxKftyping, accessToken)
-	res, err := http.Post(uri, "application/json; charset=utf-8",
-		bytes.NewReader([]byte(typing)))
+func (s *Service) SendTyping(toUser string) (*Response, error) {
+	return s.SendTypingContext(context.Background(), toUser)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("send typing %s", err)
-	}
+// SendTypingContext 发送输入状态，ctx取消时中止请求
+func (s *Service) SendTypingContext(ctx context.Context, toUser string) (*Response, error) {
+	typing := struct {
+		ToUser  string `json:"touser"`
+		Command string `json:"command"`
+	}{toUser, "typing"}
 	var status Response
-	if err = json.Unmarshal(b, &status); err != nil {
-		return nil, fmt.Errorf("send typing read response %s", err)
+	if err := s.c.PostJSON(ctx, WxKftyping, nil, typing, &status); err != nil {
+		return nil, fmt.Errorf("send typing %w", err)
 	}
 	return &status, nil
 }
+
+// AddAccount 新增客服帐号
+func AddAccount(host, accessToken string, acc *Account) (*Response, error) {
+	return newService(host, accessToken).AddAccount(acc)
+}
+
+// AddAccountContext 新增客服帐号，ctx取消时中止请求
+func AddAccountContext(ctx context.Context, host, accessToken string, acc *Account) (*Response, error) {
+	return newService(host, accessToken).AddAccountContext(ctx, acc)
+}
+
+// UpdateAccount 修改客服帐号
+func UpdateAccount(host, accessToken string, acc *Account) (*Response, error) {
+	return newService(host, accessToken).UpdateAccount(acc)
+}
+
+// UpdateAccountContext 修改客服帐号，ctx取消时中止请求
+func UpdateAccountContext(ctx context.Context, host, accessToken string, acc *Account) (*Response, error) {
+	return newService(host, accessToken).UpdateAccountContext(ctx, acc)
+}
+
+// DelAccount 删除客服帐号
+func DelAccount(host, accessToken string, acc *Account) (*Response, error) {
+	return newService(host, accessToken).DelAccount(acc)
+}
+
+// DelAccountContext 删除客服帐号，ctx取消时中止请求
+func DelAccountContext(ctx context.Context, host, accessToken string, acc *Account) (*Response, error) {
+	return newService(host, accessToken).DelAccountContext(ctx, acc)
+}
+
+// UploadHeadImage 上传客服头像
+func UploadHeadImage(host, accessToken, account, filename string) (*Response, error) {
+	return newService(host, accessToken).UploadHeadImage(account, filename)
+}
+
+// UploadHeadImageContext 上传客服头像，ctx取消时中止请求
+func UploadHeadImageContext(ctx context.Context, host, accessToken, account, filename string) (*Response, error) {
+	return newService(host, accessToken).UploadHeadImageContext(ctx, account, filename)
+}
+
+// GetList 获取所有客户帐号
+func GetList(host, accessToken string) (*Lists, error) {
+	return newService(host, accessToken).GetList()
+}
+
+// GetListContext 获取所有客户帐号，ctx取消时中止请求
+func GetListContext(ctx context.Context, host, accessToken string) (*Lists, error) {
+	return newService(host, accessToken).GetListContext(ctx)
+}
+
+// SendMessage 发送客服消息
+func SendMessage(host, accessToken string, msg *Message) (*Response, error) {
+	return newService(host, accessToken).SendMessage(msg)
+}
+
+// SendMessageContext 发送客服消息，ctx取消时中止请求
+func SendMessageContext(ctx context.Context, host, accessToken string, msg *Message) (*Response, error) {
+	return newService(host, accessToken).SendMessageContext(ctx, msg)
+}
+
+// SendTyping 发送输入状态
+func SendTyping(host, accessToken, toUser string) (*Response, error) {
+	return newService(host, accessToken).SendTyping(toUser)
+}
+
+// SendTypingContext 发送输入状态，ctx取消时中止请求
+func SendTypingContext(ctx context.Context, host, accessToken, toUser string) (*Response, error) {
+	return newService(host, accessToken).SendTypingContext(ctx, toUser)
+}
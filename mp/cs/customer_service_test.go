@@ -0,0 +1,35 @@
+package cs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"qingtao/weixin/mp/core"
+)
+
+func TestSendTyping(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Method != "POST" || r.URL.Path != "/"+WxKftyping {
+			t.Errorf("%s %s", r.Method, r.URL.Path)
+		}
+		fmt.Fprint(w, `{"errcode":-1,"errmsg":"system error"}`)
+	}))
+	defer srv.Close()
+	c := core.NewClient("", core.StaticToken("token"))
+	c.BaseURL = srv.URL
+	c.Retry = &core.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+
+	// 系统繁忙时微信服务器可能已经处理了请求，不重试
+	if _, err := NewService(c).SendTyping("openid"); !core.IsSystemBusy(err) {
+		t.Fatalf("err %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("typing sent %d times", n)
+	}
+}
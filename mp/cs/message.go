@@ -16,7 +16,7 @@ const (
 	// WxKfSend 发送消息
 	WxKfSend = "cgi-bin/message/custom/send"
 	// WxKftyping 发送输入状态接口
-	WxKftyping = "cgi-bin/message/cutom/typing"
+	WxKftyping = "cgi-bin/message/custom/typing"
 )
 
 // Account 帐号管理
@@ -44,7 +44,7 @@ type List struct {
 
 // Lists getkflist返回的客户帐号信息, 错误时返回错误码和错误信息
 type Lists struct {
-	KfList  []*List `json:"kf_lsit,omitempty"`
+	KfList  []*List `json:"kf_list,omitempty"`
 	Errcode int     `json:"errcode,omitempty"`
 	Errmsg  string  `json:"errmsg,omitempty"`
 }
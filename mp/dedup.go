@@ -0,0 +1,147 @@
+package mp
+
+import (
+	"container/list"
+	"context"
+	"encoding/xml"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDedupTTL 消息处理记录的保存时间，微信服务器在15秒内重试3次
+	DefaultDedupTTL = 5 * time.Minute
+	// DefaultDedupSize MemoryDedupStore默认保存的消息数量
+	DefaultDedupSize = 10000
+)
+
+// DedupStore 保存消息的处理状态和回复，用于识别微信服务器重试推送的消息，
+// 多个进程接收同一个公众号的消息时，需要使用共享的实现，例如redis
+type DedupStore interface {
+	// Claim 标记key开始处理，key不存在或者已经过期时返回true；
+	// key已经存在时返回false和第一次处理保存的回复，回复为nil表示第一次处理仍在进行或者没有回复
+	Claim(ctx context.Context, key string, ttl time.Duration) (claimed bool, reply []byte, err error)
+	// Save 保存key第一次处理的回复，reply为nil表示没有回复
+	Save(ctx context.Context, key string, reply []byte, ttl time.Duration) error
+}
+
+// DedupKey 返回msg的去重key：普通消息使用MsgId，事件使用FromUserName、CreateTime和Event，
+// 都以ToUserName开头，多个公众号可以共用一个DedupStore
+func DedupKey(msg *Message) string {
+	if msg.MsgID != 0 {
+		return string(msg.ToUserName) + ":msg:" + strconv.FormatInt(msg.MsgID, 10)
+	}
+	return string(msg.ToUserName) + ":event:" + string(msg.FromUserName) + ":" +
+		strconv.FormatInt(msg.CreateTime, 10) + ":" + string(msg.Event)
+}
+
+// Dedup 消息去重的中间件，重试推送的消息不再调用下一个Handler，
+// 返回第一次处理的回复，第一次处理仍在进行时返回nil，Server回复success。
+// store出错时继续处理消息，ttl为0时使用DefaultDedupTTL
+func Dedup(store DedupStore, ttl time.Duration) Middleware {
+	if ttl <= 0 {
+		ttl = DefaultDedupTTL
+	}
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) *ResponseMessage {
+			key := DedupKey(msg)
+			claimed, reply, err := store.Claim(ctx, key, ttl)
+			if err != nil {
+				return next.ServeMessage(ctx, msg)
+			}
+			if !claimed {
+				if reply == nil {
+					return nil
+				}
+				var rmsg ResponseMessage
+				if xml.Unmarshal(reply, &rmsg) != nil {
+					return nil
+				}
+				return &rmsg
+			}
+			rmsg := next.ServeMessage(ctx, msg)
+			var b []byte
+			if rmsg != nil {
+				b, _ = xml.Marshal(rmsg)
+			}
+			store.Save(ctx, key, b, ttl)
+			return rmsg
+		})
+	}
+}
+
+// dedupEntry MemoryDedupStore中的一条记录
+type dedupEntry struct {
+	key     string
+	reply   []byte
+	expires time.Time
+}
+
+// MemoryDedupStore 保存在内存中的DedupStore，超过容量时淘汰最久未使用的记录
+type MemoryDedupStore struct {
+	size int
+	now  func() time.Time
+
+	mu      sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemoryDedupStore 创建最多保存size条记录的*MemoryDedupStore，size小于等于0时使用DefaultDedupSize
+func NewMemoryDedupStore(size int) *MemoryDedupStore {
+	if size <= 0 {
+		size = DefaultDedupSize
+	}
+	return &MemoryDedupStore{
+		size:    size,
+		now:     time.Now,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Claim 实现DedupStore接口
+func (s *MemoryDedupStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*dedupEntry)
+		if now.Before(e.expires) {
+			s.ll.MoveToFront(el)
+			return false, e.reply, nil
+		}
+		s.remove(el)
+	}
+	s.entries[key] = s.ll.PushFront(&dedupEntry{key: key, expires: now.Add(ttl)})
+	for s.ll.Len() > s.size {
+		s.remove(s.ll.Back())
+	}
+	return true, nil, nil
+}
+
+// Save 实现DedupStore接口
+func (s *MemoryDedupStore) Save(ctx context.Context, key string, reply []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*dedupEntry)
+		e.reply = reply
+		e.expires = s.now().Add(ttl)
+	}
+	return nil
+}
+
+// Len 返回保存的记录数量，包括已经过期但还没有淘汰的记录
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// remove 调用时必须持有s.mu
+func (s *MemoryDedupStore) remove(el *list.Element) {
+	s.ll.Remove(el)
+	delete(s.entries, el.Value.(*dedupEntry).key)
+}
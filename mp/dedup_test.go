@@ -0,0 +1,89 @@
+package mp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := HandlerFunc(func(ctx context.Context, msg *Message) *ResponseMessage {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return NewTextMessage(msg.FromUserName, msg.ToUserName, "reply "+string(msg.Content))
+	})
+	store := NewMemoryDedupStore(0)
+	d := Dedup(store, time.Minute)(h)
+	msg := &Message{ToUserName: "gh_test", FromUserName: "user", MsgID: 1, MsgType: "text", Content: "hi"}
+
+	// 第一次处理仍在进行时，重试的消息返回nil
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var first *ResponseMessage
+	go func() {
+		defer wg.Done()
+		first = d.ServeMessage(context.Background(), msg)
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if rmsg := d.ServeMessage(context.Background(), msg); rmsg != nil {
+		t.Fatalf("in flight: %+v", rmsg)
+	}
+	close(release)
+	wg.Wait()
+	if first == nil || first.Content != "reply hi" {
+		t.Fatalf("first reply %+v", first)
+	}
+
+	// 第一次处理完成后，重试的消息返回同样的回复
+	rmsg := d.ServeMessage(context.Background(), msg)
+	if rmsg == nil || rmsg.Content != "reply hi" || rmsg.ToUserName != "user" {
+		t.Fatalf("cached reply %+v", rmsg)
+	}
+	if calls != 1 {
+		t.Fatalf("handler called %d times", calls)
+	}
+
+	// 事件使用FromUserName、CreateTime和Event
+	e1 := &Message{ToUserName: "gh_test", FromUserName: "user", CreateTime: 1, MsgType: "event", Event: "subscribe"}
+	e2 := &Message{ToUserName: "gh_test", FromUserName: "user", CreateTime: 2, MsgType: "event", Event: "subscribe"}
+	d.ServeMessage(context.Background(), e1)
+	d.ServeMessage(context.Background(), e2)
+	d.ServeMessage(context.Background(), e1)
+	if calls != 3 {
+		t.Fatalf("handler called %d times, want 3", calls)
+	}
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := NewMemoryDedupStore(2)
+	s.now = func() time.Time { return now }
+	for _, key := range []string{"a", "b", "c"} {
+		if ok, _, _ := s.Claim(ctx, key, time.Minute); !ok {
+			t.Fatalf("claim %s", key)
+		}
+	}
+	// 超过容量时淘汰a
+	if s.Len() != 2 {
+		t.Fatalf("len %d", s.Len())
+	}
+	if ok, _, _ := s.Claim(ctx, "a", time.Minute); !ok {
+		t.Fatal("a should be evicted")
+	}
+	s.Save(ctx, "a", []byte("reply"), time.Minute)
+	if ok, reply, _ := s.Claim(ctx, "a", time.Minute); ok || string(reply) != "reply" {
+		t.Fatalf("claim a: %v %q", ok, reply)
+	}
+	// 过期后可以重新处理
+	now = now.Add(2 * time.Minute)
+	if ok, _, _ := s.Claim(ctx, "a", time.Minute); !ok {
+		t.Fatal("a should be expired")
+	}
+}
@@ -0,0 +1,42 @@
+package mp
+
+import "qingtao/weixin/mp/core"
+
+// APIError 公众平台接口返回的errcode不为0时的错误，所有接口调用在errcode不为0时都返回*APIError，
+// 可以使用errors.As取得错误代码
+type APIError = core.APIError
+
+// ErrCode 返回err中*APIError的错误代码，err不包含*APIError时返回0
+func ErrCode(err error) int {
+	return core.ErrCode(err)
+}
+
+// IsTokenExpired err说明access_token已经失效或者超时
+func IsTokenExpired(err error) bool {
+	return core.IsTokenExpired(err)
+}
+
+// IsRateLimited err说明接口调用超过频率或者每日次数的限制
+func IsRateLimited(err error) bool {
+	return core.IsRateLimited(err)
+}
+
+// IsSystemBusy err说明微信服务器繁忙，可以稍候重试
+func IsSystemBusy(err error) bool {
+	return core.IsSystemBusy(err)
+}
+
+// IsInvalidOpenID err说明OpenID不合法或者用户不存在
+func IsInvalidOpenID(err error) bool {
+	return core.IsInvalidOpenID(err)
+}
+
+// IsMediaTooLarge err说明上传的文件或者消息内容超过大小限制
+func IsMediaTooLarge(err error) bool {
+	return core.IsMediaTooLarge(err)
+}
+
+// IsUnauthorized err说明公众号或者用户没有调用接口的权限
+func IsUnauthorized(err error) bool {
+	return core.IsUnauthorized(err)
+}
@@ -0,0 +1,468 @@
+package mp
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"qingtao/weixin/mp/qrcode"
+)
+
+// InboundMessage ParseMessage返回的具体消息或者事件类型，
+// 例如*TextMessage、*SubscribeEvent，处理时使用type switch区分
+type InboundMessage interface {
+	// Header 返回所有消息共有的字段
+	Header() *MessageHeader
+}
+
+// MessageHeader 所有消息和事件共有的字段
+type MessageHeader struct {
+	// ToUserName 开发者微信号
+	ToUserName string
+	// FromUserName 发送方帐号（一个OpenID）
+	FromUserName string
+	// CreateTime 消息创建时间
+	CreateTime int64
+	// MsgType 消息类型
+	MsgType string
+}
+
+// Header 实现InboundMessage接口
+func (h *MessageHeader) Header() *MessageHeader {
+	return h
+}
+
+// EventHeader 所有事件共有的字段
+type EventHeader struct {
+	MessageHeader
+	// Event 事件类型
+	Event string
+}
+
+// TextMessage 文本消息
+type TextMessage struct {
+	MessageHeader
+	MsgID   int64 `xml:"MsgId"`
+	Content string
+}
+
+// ImageMessage 图片消息
+type ImageMessage struct {
+	MessageHeader
+	MsgID   int64  `xml:"MsgId"`
+	PicURL  string `xml:"PicUrl"`
+	MediaID string `xml:"MediaId"`
+}
+
+// VoiceMessage 语音消息，开通语音识别后Recognition为识别结果
+type VoiceMessage struct {
+	MessageHeader
+	MsgID      int64  `xml:"MsgId"`
+	MediaID    string `xml:"MediaId"`
+	MediaID16K string `xml:"MediaId16K"`
+	// Format 语音格式，如amr，speex等
+	Format      string
+	Recognition string
+}
+
+// VideoMessage 视频消息
+type VideoMessage struct {
+	MessageHeader
+	MsgID        int64  `xml:"MsgId"`
+	MediaID      string `xml:"MediaId"`
+	ThumbMediaID string `xml:"ThumbMediaId"`
+}
+
+// ShortVideoMessage 小视频消息
+type ShortVideoMessage struct {
+	MessageHeader
+	MsgID        int64  `xml:"MsgId"`
+	MediaID      string `xml:"MediaId"`
+	ThumbMediaID string `xml:"ThumbMediaId"`
+}
+
+// LocationMessage 地理位置消息，与LocationEvent一样使用Latitude和Longitude
+type LocationMessage struct {
+	MessageHeader
+	MsgID     int64   `xml:"MsgId"`
+	Latitude  float64 `xml:"Location_X"`
+	Longitude float64 `xml:"Location_Y"`
+	// Scale 地图缩放大小
+	Scale int64
+	// Label 地理位置信息
+	Label string
+}
+
+// LinkMessage 链接消息
+type LinkMessage struct {
+	MessageHeader
+	MsgID       int64 `xml:"MsgId"`
+	Title       string
+	Description string
+	URL         string `xml:"Url"`
+}
+
+// SubscribeEvent 关注事件，扫描带参数二维码关注时EventKey和Ticket不为空
+type SubscribeEvent struct {
+	EventHeader
+	EventKey string
+	Ticket   string
+}
+
+// SceneKey 返回二维码的参数，即去掉qrscene_前缀的EventKey
+func (e *SubscribeEvent) SceneKey() string {
+	return strings.TrimPrefix(e.EventKey, SceneKeyPrefix)
+}
+
+// Scene 返回二维码的参数，不是扫描带参数二维码关注时返回false
+func (e *SubscribeEvent) Scene() (qrcode.Scene, bool) {
+	if e.Ticket == "" || !strings.HasPrefix(e.EventKey, SceneKeyPrefix) {
+		return qrcode.Scene{}, false
+	}
+	return qrcode.ParseScene(e.EventKey), true
+}
+
+// UnsubscribeEvent 取消关注事件
+type UnsubscribeEvent struct {
+	EventHeader
+}
+
+// ScanEvent 已关注用户扫描带参数二维码，EventKey是二维码的参数
+type ScanEvent struct {
+	EventHeader
+	EventKey string
+	Ticket   string
+}
+
+// Scene 返回二维码的参数
+func (e *ScanEvent) Scene() qrcode.Scene {
+	return qrcode.ParseScene(e.EventKey)
+}
+
+// QRCodeScan 记录扫描带参数二维码的中间件，用于统计渠道来源，
+// 扫描二维码关注和已关注用户扫描二维码时调用f，subscribed为true表示这次扫描带来了新关注，
+// f返回后继续调用下一个Handler
+func QRCodeScan(f func(ctx context.Context, openid string, scene qrcode.Scene, subscribed bool)) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) *ResponseMessage {
+			if msg.MsgType == MsgTypeEvent && msg.Ticket != "" {
+				switch {
+				case strings.EqualFold(string(msg.Event), EventSubscribe) && strings.HasPrefix(string(msg.EventKey), SceneKeyPrefix):
+					f(ctx, string(msg.FromUserName), qrcode.ParseScene(string(msg.EventKey)), true)
+				case strings.EqualFold(string(msg.Event), EventScan):
+					f(ctx, string(msg.FromUserName), qrcode.ParseScene(string(msg.EventKey)), false)
+				}
+			}
+			return next.ServeMessage(ctx, msg)
+		})
+	}
+}
+
+// LocationEvent 上报地理位置事件
+type LocationEvent struct {
+	EventHeader
+	Latitude  float64
+	Longitude float64
+	Precision float64
+}
+
+// ClickEvent 点击菜单拉取消息事件，EventKey是菜单的key
+type ClickEvent struct {
+	EventHeader
+	EventKey string
+}
+
+// ViewEvent 点击菜单跳转链接事件，EventKey是跳转的URL
+type ViewEvent struct {
+	EventHeader
+	EventKey string
+	MenuID   string `xml:"MenuId"`
+}
+
+// ViewMiniProgramEvent 点击菜单跳转小程序事件，EventKey是小程序的页面路径
+type ViewMiniProgramEvent struct {
+	EventHeader
+	EventKey string
+	MenuID   string `xml:"MenuId"`
+}
+
+// ScanCodeEvent 扫码推事件，Event为scancode_push或者scancode_waitmsg
+type ScanCodeEvent struct {
+	EventHeader
+	EventKey     string
+	ScanCodeInfo ScanCodeInfo
+}
+
+// PicEvent 弹出拍照或者相册发图事件，Event为pic_sysphoto、pic_photo_or_album或者pic_weixin
+type PicEvent struct {
+	EventHeader
+	EventKey     string
+	SendPicsInfo SendPicsInfo
+}
+
+// LocationSelectEvent 弹出地理位置选择器事件
+type LocationSelectEvent struct {
+	EventHeader
+	EventKey         string
+	SendLocationInfo SendLocationInfo
+}
+
+// TemplateSendJobFinishEvent 模版消息发送结果，
+// Status为success、failed:user block或者failed: system failed
+type TemplateSendJobFinishEvent struct {
+	EventHeader
+	MsgID  int64 `xml:"MsgID"`
+	Status string
+}
+
+// HandleTemplateSendJobFinish 注册处理模板消息发送结果的函数，用于记录送达状态，事件不需要回复
+func (r *Router) HandleTemplateSendJobFinish(f func(ctx context.Context, e *TemplateSendJobFinishEvent)) {
+	r.HandleEventFunc(EventTemplateSendJobFinish, func(ctx context.Context, msg *Message) *ResponseMessage {
+		if m, err := msg.Parse(); err == nil {
+			if e, ok := m.(*TemplateSendJobFinishEvent); ok {
+				f(ctx, e)
+			}
+		}
+		return nil
+	})
+}
+
+// MassSendJobFinishEvent 群发结果，Status为send success、send fail或者err(num)
+type MassSendJobFinishEvent struct {
+	EventHeader
+	MsgID       int64 `xml:"MsgID"`
+	Status      string
+	TotalCount  int
+	FilterCount int
+	SentCount   int
+	ErrorCount  int
+	// CopyrightCheckResult 图文消息的原创校验结果，其他类型的群发为nil
+	CopyrightCheckResult *CopyrightCheckResult
+}
+
+// 原创校验的整体结果CheckState
+const (
+	// CopyrightCheckPass 未被判为转载，可以群发
+	CopyrightCheckPass = 1
+	// CopyrightCheckReprint 被判为转载，可以群发
+	CopyrightCheckReprint = 2
+	// CopyrightCheckReject 被判为转载，不能群发
+	CopyrightCheckReject = 3
+)
+
+// CopyrightCheckResult 群发图文消息的原创校验结果
+type CopyrightCheckResult struct {
+	// Count 校验的图文数量
+	Count int
+	// ResultList 每篇图文的校验结果
+	ResultList []*CopyrightCheckItem `xml:"ResultList>item"`
+	// CheckState 整体校验结果，CopyrightCheckPass、CopyrightCheckReprint或者CopyrightCheckReject
+	CheckState int
+}
+
+// CopyrightCheckItem 单篇图文的原创校验结果
+type CopyrightCheckItem struct {
+	// ArticleIdx 图文在消息中的位置，从1开始
+	ArticleIdx int
+	// UserDeclareState 用户声明的原创状态
+	UserDeclareState int
+	// AuditState 系统校验的状态
+	AuditState int
+	// OriginalArticleURL 相似原创文的链接
+	OriginalArticleURL string `xml:"OriginalArticleUrl"`
+	// OriginalArticleType 相似原创文的类型
+	OriginalArticleType int
+	// CanReprint 是否能转载
+	CanReprint int
+	// NeedReplaceContent 是否需要替换成原创文内容
+	NeedReplaceContent int
+	// NeedShowReprintSource 是否需要注明转载来源
+	NeedShowReprintSource int
+}
+
+// HandleMassSendJobFinish 注册处理群发结果的函数，事件不需要回复
+func (r *Router) HandleMassSendJobFinish(f func(ctx context.Context, e *MassSendJobFinishEvent)) {
+	r.HandleEventFunc(EventMassSendJobFinish, func(ctx context.Context, msg *Message) *ResponseMessage {
+		if m, err := msg.Parse(); err == nil {
+			if e, ok := m.(*MassSendJobFinishEvent); ok {
+				f(ctx, e)
+			}
+		}
+		return nil
+	})
+}
+
+// UserEnterTempSessionEvent 用户进入客服会话事件
+type UserEnterTempSessionEvent struct {
+	EventHeader
+	SessionFrom string
+}
+
+// 订阅通知的订阅状态SubscribeStatusString
+const (
+	// SubscribeStatusAccept 用户同意订阅
+	SubscribeStatusAccept = "accept"
+	// SubscribeStatusReject 用户拒绝或者取消订阅
+	SubscribeStatusReject = "reject"
+)
+
+// SubscribeMsgStatus 用户对一个订阅通知模板的订阅状态
+type SubscribeMsgStatus struct {
+	// TemplateID 私有模板id
+	TemplateID string `xml:"TemplateId"`
+	// SubscribeStatusString accept或者reject
+	SubscribeStatusString string
+	// PopupScene 弹窗场景，0为h5页面，1为图文，2为消息，只有弹窗事件有
+	PopupScene int
+}
+
+// Accepted 用户是否同意订阅
+func (s *SubscribeMsgStatus) Accepted() bool {
+	return s.SubscribeStatusString == SubscribeStatusAccept
+}
+
+// SubscribeMsgPopupEvent 用户在订阅通知弹窗中同意或者拒绝订阅
+type SubscribeMsgPopupEvent struct {
+	EventHeader
+	List []*SubscribeMsgStatus `xml:"SubscribeMsgPopupEvent>List"`
+}
+
+// SubscribeMsgChangeEvent 用户在服务通知管理页面修改订阅，只有取消订阅时推送
+type SubscribeMsgChangeEvent struct {
+	EventHeader
+	List []*SubscribeMsgStatus `xml:"SubscribeMsgChangeEvent>List"`
+}
+
+// SubscribeMsgSentResult 一条订阅通知的发送结果
+type SubscribeMsgSentResult struct {
+	// TemplateID 私有模板id
+	TemplateID string `xml:"TemplateId"`
+	// MsgID 消息id
+	MsgID int64 `xml:"MsgID"`
+	// ErrorCode 推送结果状态码，0表示成功
+	ErrorCode int
+	// ErrorStatus 推送结果状态，例如success、fail_user_refuse_accept
+	ErrorStatus string
+}
+
+// SubscribeMsgSentEvent 订阅通知发送结果
+type SubscribeMsgSentEvent struct {
+	EventHeader
+	List []*SubscribeMsgSentResult `xml:"SubscribeMsgSentEvent>List"`
+}
+
+// HandleSubscribeMsgStatus 注册处理订阅状态变化的函数，弹窗事件和管理页面修改事件都调用f，
+// openid是用户，statuses是用户对每个模板的订阅状态，可用于记录用户同意了哪些模板，事件不需要回复
+func (r *Router) HandleSubscribeMsgStatus(f func(ctx context.Context, openid string, statuses []*SubscribeMsgStatus)) {
+	h := func(ctx context.Context, msg *Message) *ResponseMessage {
+		m, err := msg.Parse()
+		if err != nil {
+			return nil
+		}
+		switch e := m.(type) {
+		case *SubscribeMsgPopupEvent:
+			f(ctx, e.FromUserName, e.List)
+		case *SubscribeMsgChangeEvent:
+			f(ctx, e.FromUserName, e.List)
+		}
+		return nil
+	}
+	r.HandleEventFunc(EventSubscribeMsgPopup, h)
+	r.HandleEventFunc(EventSubscribeMsgChange, h)
+}
+
+// UnknownMessage 不支持的消息或者事件，Raw是消息的明文
+type UnknownMessage struct {
+	EventHeader
+	Raw []byte `xml:"-"`
+}
+
+// newInbound 按照msgType和event创建消息类型，不支持时返回nil
+func newInbound(msgType, event string) InboundMessage {
+	switch msgType {
+	case MsgTypeText:
+		return &TextMessage{}
+	case MsgTypeImage:
+		return &ImageMessage{}
+	case MsgTypeVoice:
+		return &VoiceMessage{}
+	case MsgTypeVideo:
+		return &VideoMessage{}
+	case MsgTypeShortVideo:
+		return &ShortVideoMessage{}
+	case MsgTypeLocation:
+		return &LocationMessage{}
+	case MsgTypeLink:
+		return &LinkMessage{}
+	case MsgTypeEvent:
+	default:
+		return nil
+	}
+	// 事件类型不区分大小写
+	switch strings.ToLower(event) {
+	case EventSubscribe:
+		return &SubscribeEvent{}
+	case EventUnsubscribe:
+		return &UnsubscribeEvent{}
+	case strings.ToLower(EventScan):
+		return &ScanEvent{}
+	case strings.ToLower(EventLocation):
+		return &LocationEvent{}
+	case strings.ToLower(EventClick):
+		return &ClickEvent{}
+	case strings.ToLower(EventView):
+		return &ViewEvent{}
+	case EventViewMiniProgram:
+		return &ViewMiniProgramEvent{}
+	case EventScanCodePush, EventScanCodeWaitMsg:
+		return &ScanCodeEvent{}
+	case EventPicSysPhoto, EventPicPhotoOrAlbum, EventPicWeixin:
+		return &PicEvent{}
+	case EventLocationSelect:
+		return &LocationSelectEvent{}
+	case strings.ToLower(EventTemplateSendJobFinish):
+		return &TemplateSendJobFinishEvent{}
+	case strings.ToLower(EventMassSendJobFinish):
+		return &MassSendJobFinishEvent{}
+	case EventUserEnterTempSession:
+		return &UserEnterTempSessionEvent{}
+	case EventSubscribeMsgPopup:
+		return &SubscribeMsgPopupEvent{}
+	case EventSubscribeMsgChange:
+		return &SubscribeMsgChangeEvent{}
+	case EventSubscribeMsgSent:
+		return &SubscribeMsgSentEvent{}
+	}
+	return nil
+}
+
+// ParseMessage 解析消息明文，返回MsgType和Event对应的具体类型，
+// 不支持的消息返回*UnknownMessage
+func ParseMessage(b []byte) (InboundMessage, error) {
+	var h EventHeader
+	if err := xml.Unmarshal(b, &h); err != nil {
+		return nil, fmt.Errorf("parse message %w", err)
+	}
+	m := newInbound(h.MsgType, h.Event)
+	if m == nil {
+		return &UnknownMessage{EventHeader: h, Raw: b}, nil
+	}
+	if err := xml.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("parse %s message %w", h.MsgType, err)
+	}
+	return m, nil
+}
+
+// Parse 把msg解析为具体的消息类型，Server接收的消息使用msg.Raw，
+// 否则使用msg编码后的XML
+func (msg *Message) Parse() (InboundMessage, error) {
+	b := msg.Raw
+	if len(b) == 0 {
+		var err error
+		if b, err = xml.Marshal(msg); err != nil {
+			return nil, fmt.Errorf("marshal message %w", err)
+		}
+	}
+	return ParseMessage(b)
+}
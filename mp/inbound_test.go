@@ -0,0 +1,144 @@
+package mp
+
+import (
+	"context"
+	"testing"
+
+	"qingtao/weixin/mp/qrcode"
+)
+
+func TestParseMessage(t *testing.T) {
+	m, err := ParseMessage([]byte(`<xml><ToUserName><![CDATA[gh_test]]></ToUserName><FromUserName><![CDATA[user]]></FromUserName><CreateTime>1351776360</CreateTime><MsgType><![CDATA[location]]></MsgType><Location_X>23.134521</Location_X><Location_Y>113.358803</Location_Y><Scale>20</Scale><Label><![CDATA[位置信息]]></Label><MsgId>1234567890123456</MsgId></xml>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, ok := m.(*LocationMessage)
+	if !ok || loc.Latitude != 23.134521 || loc.Longitude != 113.358803 || loc.MsgID != 1234567890123456 || loc.Header().FromUserName != "user" {
+		t.Fatalf("location %#v", m)
+	}
+
+	m, err = ParseMessage([]byte(`<xml><ToUserName><![CDATA[gh_test]]></ToUserName><FromUserName><![CDATA[user]]></FromUserName><CreateTime>1395658920</CreateTime><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[TEMPLATESENDJOBFINISH]]></Event><MsgID>200163836</MsgID><Status><![CDATA[success]]></Status></xml>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := m.(*TemplateSendJobFinishEvent); !ok || e.MsgID != 200163836 || e.Status != "success" {
+		t.Fatalf("template event %#v", m)
+	}
+
+	m, err = ParseMessage([]byte(`<xml><ToUserName>gh_test</ToUserName><FromUserName>user</FromUserName><CreateTime>1</CreateTime><MsgType>event</MsgType><Event>subscribe</Event><EventKey>qrscene_123</EventKey><Ticket>t</Ticket></xml>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := m.(*SubscribeEvent); !ok || e.SceneKey() != "123" || e.Event != EventSubscribe {
+		t.Fatalf("subscribe event %#v", m)
+	}
+
+	raw := []byte(`<xml><ToUserName>gh_test</ToUserName><FromUserName>user</FromUserName><CreateTime>1</CreateTime><MsgType>event</MsgType><Event>unknown_event</Event></xml>`)
+	m, err = ParseMessage(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, ok := m.(*UnknownMessage); !ok || string(u.Raw) != string(raw) || u.Event != "unknown_event" {
+		t.Fatalf("unknown %#v", m)
+	}
+
+	if _, err := ParseMessage([]byte("<xml>")); err == nil {
+		t.Fatal("expect error")
+	}
+}
+
+func TestMessageParse(t *testing.T) {
+	msg := &Message{ToUserName: "gh_test", FromUserName: "user", MsgType: MsgTypeVoice, MediaID: "id", MediaID16K: "id16k", Format: "amr"}
+	m, err := msg.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := m.(*VoiceMessage); !ok || v.MediaID16K != "id16k" || v.Format != "amr" {
+		t.Fatalf("voice %#v", m)
+	}
+}
+
+func TestHandleTemplateSendJobFinish(t *testing.T) {
+	r := NewRouter()
+	var got *TemplateSendJobFinishEvent
+	r.HandleTemplateSendJobFinish(func(ctx context.Context, e *TemplateSendJobFinishEvent) {
+		got = e
+	})
+	msg := &Message{ToUserName: "gh_test", FromUserName: "user", MsgType: MsgTypeEvent,
+		Event: "TEMPLATESENDJOBFINISH", EventMsgID: 200163836, Status: "failed:user block"}
+	if rmsg := r.ServeMessage(context.Background(), msg); rmsg != nil {
+		t.Fatalf("reply %+v", rmsg)
+	}
+	if got == nil || got.MsgID != 200163836 || got.Status != "failed:user block" {
+		t.Fatalf("event %+v", got)
+	}
+}
+
+func TestHandleSubscribeMsgStatus(t *testing.T) {
+	r := NewRouter()
+	var openid string
+	var got []*SubscribeMsgStatus
+	r.HandleSubscribeMsgStatus(func(ctx context.Context, user string, statuses []*SubscribeMsgStatus) {
+		openid, got = user, statuses
+	})
+	raw := `<xml><ToUserName><![CDATA[gh_123456789abc]]></ToUserName><FromUserName><![CDATA[otFpruAK8D-E6EfStSYonYSBZ8_4]]></FromUserName><CreateTime>1610969440</CreateTime><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[subscribe_msg_popup_event]]></Event><SubscribeMsgPopupEvent><List><TemplateId><![CDATA[VRR0UEO9VJOLs0MHlU0OilqX6MVFDwH3_3gz3Oc0NIc]]></TemplateId><SubscribeStatusString><![CDATA[accept]]></SubscribeStatusString><PopupScene>2</PopupScene></List><List><TemplateId><![CDATA[9nLIlbOQZC5Y89AZteFEux3WCXRRRG5Wfzkpssu4bLI]]></TemplateId><SubscribeStatusString><![CDATA[reject]]></SubscribeStatusString><PopupScene>2</PopupScene></List></SubscribeMsgPopupEvent></xml>`
+	msg := &Message{MsgType: MsgTypeEvent, Event: EventSubscribeMsgPopup, Raw: []byte(raw)}
+	r.ServeMessage(context.Background(), msg)
+	if openid != "otFpruAK8D-E6EfStSYonYSBZ8_4" || len(got) != 2 || !got[0].Accepted() || got[1].Accepted() || got[0].PopupScene != 2 {
+		t.Fatalf("%s %+v", openid, got)
+	}
+
+	m, err := ParseMessage([]byte(`<xml><ToUserName>gh_123456789abc</ToUserName><FromUserName>user</FromUserName><CreateTime>1620963428</CreateTime><MsgType>event</MsgType><Event>subscribe_msg_sent_event</Event><SubscribeMsgSentEvent><List><TemplateId>VRR0UEO9VJOLs0MHlU0OilqX6MVFDwH3_3gz3Oc0NIc</TemplateId><MsgID>1700827132819554304</MsgID><ErrorCode>0</ErrorCode><ErrorStatus>success</ErrorStatus></List></SubscribeMsgSentEvent></xml>`))
+	if e, ok := m.(*SubscribeMsgSentEvent); err != nil || !ok || len(e.List) != 1 || e.List[0].MsgID != 1700827132819554304 {
+		t.Fatalf("sent event %#v %v", m, err)
+	}
+}
+
+func TestHandleMassSendJobFinish(t *testing.T) {
+	r := NewRouter()
+	var got *MassSendJobFinishEvent
+	r.HandleMassSendJobFinish(func(ctx context.Context, e *MassSendJobFinishEvent) {
+		got = e
+	})
+	raw := `<xml><ToUserName><![CDATA[gh_4d00ed8d6399]]></ToUserName><FromUserName><![CDATA[oV5CrjpxgaGXNHIQigzNlgLTnwic]]></FromUserName><CreateTime>1481013459</CreateTime><MsgType><![CDATA[event]]></MsgType><Event><![CDATA[MASSSENDJOBFINISH]]></Event><MsgID>1000001625</MsgID><Status><![CDATA[err(30003)]]></Status><TotalCount>0</TotalCount><FilterCount>0</FilterCount><SentCount>0</SentCount><ErrorCount>0</ErrorCount><CopyrightCheckResult><Count>2</Count><ResultList><item><ArticleIdx>1</ArticleIdx><UserDeclareState>0</UserDeclareState><AuditState>2</AuditState><OriginalArticleUrl><![CDATA[Url_1]]></OriginalArticleUrl><OriginalArticleType>1</OriginalArticleType><CanReprint>1</CanReprint><NeedReplaceContent>1</NeedReplaceContent><NeedShowReprintSource>1</NeedShowReprintSource></item><item><ArticleIdx>2</ArticleIdx><UserDeclareState>0</UserDeclareState><AuditState>2</AuditState><OriginalArticleUrl><![CDATA[Url_2]]></OriginalArticleUrl><OriginalArticleType>1</OriginalArticleType><CanReprint>1</CanReprint><NeedReplaceContent>1</NeedReplaceContent><NeedShowReprintSource>1</NeedShowReprintSource></item></ResultList><CheckState>2</CheckState></CopyrightCheckResult></xml>`
+	msg := &Message{MsgType: MsgTypeEvent, Event: EventMassSendJobFinish, Raw: []byte(raw)}
+	if rmsg := r.ServeMessage(context.Background(), msg); rmsg != nil {
+		t.Fatalf("reply %+v", rmsg)
+	}
+	if got == nil || got.MsgID != 1000001625 || got.Status != "err(30003)" || got.CopyrightCheckResult == nil {
+		t.Fatalf("event %+v", got)
+	}
+	cr := got.CopyrightCheckResult
+	if cr.Count != 2 || cr.CheckState != CopyrightCheckReprint || len(cr.ResultList) != 2 || cr.ResultList[1].OriginalArticleURL != "Url_2" || cr.ResultList[1].ArticleIdx != 2 {
+		t.Fatalf("copyright %+v", cr)
+	}
+}
+
+func TestQRCodeScan(t *testing.T) {
+	r := NewRouter()
+	var scenes []qrcode.Scene
+	var subscribed []bool
+	r.Use(QRCodeScan(func(ctx context.Context, openid string, scene qrcode.Scene, sub bool) {
+		scenes = append(scenes, scene)
+		subscribed = append(subscribed, sub)
+	}))
+	r.HandleEventFunc(EventSubscribe, func(ctx context.Context, msg *Message) *ResponseMessage {
+		return NewTextMessage(msg.FromUserName, msg.ToUserName, "welcome")
+	})
+	msgs := []*Message{
+		{MsgType: MsgTypeEvent, Event: EventSubscribe, EventKey: "qrscene_poster_a", Ticket: "ticket"},
+		{MsgType: MsgTypeEvent, Event: EventScan, EventKey: "123", Ticket: "ticket"},
+		{MsgType: MsgTypeEvent, Event: EventSubscribe},
+	}
+	for _, msg := range msgs {
+		r.ServeMessage(context.Background(), msg)
+	}
+	if len(scenes) != 2 || scenes[0].Str != "poster_a" || !subscribed[0] || scenes[1].ID != 123 || subscribed[1] {
+		t.Fatalf("%+v %v", scenes, subscribed)
+	}
+
+	m, _ := ParseMessage([]byte(`<xml><ToUserName>gh</ToUserName><FromUserName>user</FromUserName><CreateTime>1</CreateTime><MsgType>event</MsgType><Event>subscribe</Event><EventKey>qrscene_123</EventKey><Ticket>ticket</Ticket></xml>`))
+	if scene, ok := m.(*SubscribeEvent).Scene(); !ok || scene.ID != 123 {
+		t.Fatalf("scene %+v %v", scene, ok)
+	}
+}
@@ -0,0 +1,187 @@
+package mp
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"qingtao/weixin/mp/core"
+)
+
+const (
+	// WxTicketPath 获取JS-SDK和卡券使用的ticket
+	WxTicketPath = "cgi-bin/ticket/getticket"
+	// TicketTypeJSAPI wx.config使用的jsapi_ticket
+	TicketTypeJSAPI = "jsapi"
+	// TicketTypeWxCard 卡券使用的api_ticket
+	TicketTypeWxCard = "wx_card"
+)
+
+// fetchTicket 返回获取typ类型ticket的TokenFetcher
+func (wx *WeiXin) fetchTicket(typ string) TokenFetcher {
+	return func(ctx context.Context) (string, int, error) {
+		var t struct {
+			Ticket    string `json:"ticket"`
+			ExpiresIn int    `json:"expires_in"`
+		}
+		if err := wx.Client().Get(ctx, WxTicketPath, url.Values{"type": {typ}}, &t); err != nil {
+			return "", 0, fmt.Errorf("appid %s get %s ticket %w", wx.AppID, typ, err)
+		}
+		if t.Ticket == "" {
+			return "", 0, fmt.Errorf("appid %s get %s ticket: ticket is empty", wx.AppID, typ)
+		}
+		wx.log().Info("weixin ticket fetched", "type", typ, "expires_in", t.ExpiresIn)
+		return t.Ticket, t.ExpiresIn, nil
+	}
+}
+
+// ticketKey typ类型的ticket在TokenStore中使用的key
+func (wx *WeiXin) ticketKey(typ string) string {
+	return typ + "_ticket_" + wx.AppID
+}
+
+// tickets 返回typ类型ticket的*TokenManager，第一次调用时创建
+func (wx *WeiXin) tickets(typ string) *TokenManager {
+	wx.mu.Lock()
+	defer wx.mu.Unlock()
+	if wx.ticketManagers == nil {
+		wx.ticketManagers = make(map[string]*TokenManager)
+	}
+	m, ok := wx.ticketManagers[typ]
+	if !ok {
+		m = NewTokenManager(wx.fetchTicket(typ))
+		if wx.store != nil {
+			m.SetStore(wx.store, wx.ticketKey(typ))
+		}
+		wx.ticketManagers[typ] = m
+	}
+	return m
+}
+
+// JSAPITickets 返回管理jsapi_ticket的*TokenManager，与access_token一样缓存并在过期前刷新，
+// 设置SetTokenStore后在多个进程间共享
+func (wx *WeiXin) JSAPITickets() *TokenManager {
+	return wx.tickets(TicketTypeJSAPI)
+}
+
+// CardTickets 返回管理卡券api_ticket的*TokenManager
+func (wx *WeiXin) CardTickets() *TokenManager {
+	return wx.tickets(TicketTypeWxCard)
+}
+
+// JSConfig wx.config的参数，可以直接序列化为json传给网页
+type JSConfig struct {
+	AppID     string `json:"appId"`
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	Signature string `json:"signature"`
+	// JSAPIList 需要使用的JS接口列表
+	JSAPIList []string `json:"jsApiList,omitempty"`
+}
+
+// JSSign 使用jsapi_ticket生成wx.config的签名，rawurl是调用JS接口的页面地址，#及其后面的部分不参与签名
+func JSSign(ticket, nonceStr string, timestamp int64, rawurl string) string {
+	if i := strings.IndexByte(rawurl, '#'); i >= 0 {
+		rawurl = rawurl[:i]
+	}
+	s := "jsapi_ticket=" + ticket + "&noncestr=" + nonceStr +
+		"&timestamp=" + strconv.FormatInt(timestamp, 10) + "&url=" + rawurl
+	return fmt.Sprintf("%x", sha1.Sum([]byte(s)))
+}
+
+// JSConfig 返回页面rawurl使用的wx.config参数，apis是需要使用的JS接口列表
+func (wx *WeiXin) JSConfig(ctx context.Context, rawurl string, apis ...string) (*JSConfig, error) {
+	ticket, err := wx.JSAPITickets().Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &JSConfig{
+		AppID:     wx.AppID,
+		Timestamp: time.Now().Unix(),
+		NonceStr:  core.RandomString(16),
+		JSAPIList: apis,
+	}
+	cfg.Signature = JSSign(ticket, cfg.NonceStr, cfg.Timestamp, rawurl)
+	return cfg, nil
+}
+
+// CardSign 卡券签名，将values按照字典序排序后连接，计算sha1
+func CardSign(values ...string) string {
+	list := append([]string(nil), values...)
+	sort.Strings(list)
+	h := sha1.New()
+	for _, v := range list {
+		h.Write([]byte(v))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// CardExt wx.addCard中cardList的cardExt参数，使用String()得到cardExt的json字符串
+type CardExt struct {
+	// Code 指定的卡券code，自定义code的卡券必须填写
+	Code string `json:"code,omitempty"`
+	// OpenID 指定领取者的openid
+	OpenID    string `json:"openid,omitempty"`
+	Timestamp string `json:"timestamp"`
+	NonceStr  string `json:"nonce_str"`
+	Signature string `json:"signature"`
+	// OuterStr 领取渠道参数，用于统计
+	OuterStr string `json:"outer_str,omitempty"`
+}
+
+// String 返回cardExt的json字符串
+func (e *CardExt) String() string {
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+// AddCardExt 生成添加卡券cardID使用的cardExt，code和openid可以为空
+func (wx *WeiXin) AddCardExt(ctx context.Context, cardID, code, openid string) (*CardExt, error) {
+	ticket, err := wx.CardTickets().Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ext := &CardExt{
+		Code:      code,
+		OpenID:    openid,
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		NonceStr:  core.RandomString(16),
+	}
+	ext.Signature = CardSign(ticket, ext.Timestamp, cardID, code, openid, ext.NonceStr)
+	return ext, nil
+}
+
+// ChooseCard wx.chooseCard的参数
+type ChooseCard struct {
+	ShopID    string `json:"shopId,omitempty"`
+	CardType  string `json:"cardType,omitempty"`
+	CardID    string `json:"cardId,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	SignType  string `json:"signType"`
+	CardSign  string `json:"cardSign"`
+}
+
+// ChooseCard 生成拉取卡券列表使用的参数，shopID、cardType和cardID都可以为空
+func (wx *WeiXin) ChooseCard(ctx context.Context, shopID, cardType, cardID string) (*ChooseCard, error) {
+	ticket, err := wx.CardTickets().Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &ChooseCard{
+		ShopID:    shopID,
+		CardType:  cardType,
+		CardID:    cardID,
+		Timestamp: time.Now().Unix(),
+		NonceStr:  core.RandomString(16),
+		SignType:  "SHA1",
+	}
+	c.CardSign = CardSign(ticket, wx.AppID, shopID, strconv.FormatInt(c.Timestamp, 10), c.NonceStr, cardID, cardType)
+	return c, nil
+}
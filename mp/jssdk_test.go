@@ -0,0 +1,73 @@
+package mp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestJSSign(t *testing.T) {
+	// 微信JS-SDK说明文档附录1中的示例
+	ticket := "sM4AOVdWfPE4DxkXGEs8VMCPGGVi4C3VM0P37wVUCFvkVAy_90u5h9nbSlYy3-Sl-HhTdfl2fzFy1AOcHKP7qg"
+	sig := JSSign(ticket, "Wm3WZYTPz0wzccnW", 1414587457, "http://mp.weixin.qq.com?params=value#section")
+	if sig != "0f9de62fce790f9a083d5c99e95740ceb90c27ed" {
+		t.Fatalf("signature %s", sig)
+	}
+	if CardSign("b", "a", "c") != CardSign("c", "b", "a") {
+		t.Fatal("card sign should not depend on order")
+	}
+}
+
+func TestJSConfig(t *testing.T) {
+	var fetched int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + WxTokenPath:
+			fmt.Fprint(w, `{"access_token":"token","expires_in":7200}`)
+		case "/" + WxTicketPath:
+			atomic.AddInt32(&fetched, 1)
+			fmt.Fprintf(w, `{"errcode":0,"errmsg":"ok","ticket":"%s_ticket","expires_in":7200}`, r.URL.Query().Get("type"))
+		}
+	}))
+	defer srv.Close()
+	wx := &WeiXin{AppID: "wxappid", AppSecret: "secret"}
+	wx.Client().BaseURL = srv.URL
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		cfg, err := wx.JSConfig(ctx, "https://example.com/page?a=1#top", "chooseImage")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.AppID != "wxappid" || cfg.Signature != JSSign("jsapi_ticket", cfg.NonceStr, cfg.Timestamp, "https://example.com/page?a=1") {
+			t.Fatalf("config %+v", cfg)
+		}
+		b, _ := json.Marshal(cfg)
+		var m map[string]interface{}
+		json.Unmarshal(b, &m)
+		if m["appId"] != "wxappid" || m["nonceStr"] == "" || len(m["jsApiList"].([]interface{})) != 1 {
+			t.Fatalf("json %s", b)
+		}
+	}
+	if n := atomic.LoadInt32(&fetched); n != 1 {
+		t.Fatalf("jsapi_ticket fetched %d times", n)
+	}
+
+	ext, err := wx.AddCardExt(ctx, "card_id", "", "openid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext.Signature != CardSign("wx_card_ticket", ext.Timestamp, "card_id", "", "openid", ext.NonceStr) {
+		t.Fatalf("card ext %s", ext)
+	}
+	if _, err := wx.ChooseCard(ctx, "", "GROUPON", ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetched); n != 2 {
+		t.Fatalf("tickets fetched %d times", n)
+	}
+}
@@ -0,0 +1,195 @@
+package mp
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeyUsage 一个EncodingAESKey的使用情况，用于判断旧的密钥是否可以删除
+type KeyUsage struct {
+	// Index 密钥在KeyRing中的顺序，0是当前的密钥
+	Index int `json:"index"`
+	// ID 密钥的sha256摘要的前8个字符，不包含密钥本身
+	ID string `json:"id"`
+	// Decrypted 使用这个密钥解密的消息数量
+	Decrypted int64 `json:"decrypted"`
+	// LastUsed 最后一次使用这个密钥解密的时间，没有使用过时为零值
+	LastUsed time.Time `json:"last_used"`
+}
+
+// ringKey KeyRing中的一个密钥
+type ringKey struct {
+	key   string
+	block cipher.Block
+	iv    []byte
+	usage KeyUsage
+}
+
+// KeyRing 按顺序保存多个EncodingAESKey，用于修改EncodingAESKey期间接收新旧密钥加密的消息：
+// 解密时按顺序尝试每个密钥，回复使用消息解密时的密钥加密。
+// 可以被多个goroutine同时使用
+type KeyRing struct {
+	mu   sync.Mutex
+	now  func() time.Time
+	keys []*ringKey
+}
+
+// NewKeyRing 创建*KeyRing，keys的第一个是当前的密钥，之后是旧的密钥，空字符串被忽略
+func NewKeyRing(keys ...string) (*KeyRing, error) {
+	r := &KeyRing{now: time.Now}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := r.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// keyID 返回key的sha256摘要的前8个字符
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// keyIDOf 返回日志中记录的密钥ID，明文消息没有密钥时返回空字符串
+func keyIDOf(key string) string {
+	if key == "" {
+		return ""
+	}
+	return keyID(key)
+}
+
+// Add 在最后添加一个密钥，密钥已经存在时不做修改
+func (r *KeyRing) Add(key string) error {
+	block, iv, err := newCipher(key)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range r.keys {
+		if k.key == key {
+			return nil
+		}
+	}
+	r.keys = append(r.keys, &ringKey{key: key, block: block, iv: iv, usage: KeyUsage{ID: keyID(key)}})
+	r.reindex()
+	return nil
+}
+
+// Promote 把key设置为当前的密钥，放在第一个，不存在时添加
+func (r *KeyRing) Promote(key string) error {
+	if err := r.Add(key); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, k := range r.keys {
+		if k.key == key {
+			copy(r.keys[1:i+1], r.keys[:i])
+			r.keys[0] = k
+			break
+		}
+	}
+	r.reindex()
+	return nil
+}
+
+// Remove 删除不再使用的旧密钥
+func (r *KeyRing) Remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, k := range r.keys {
+		if k.key == key {
+			r.keys = append(r.keys[:i], r.keys[i+1:]...)
+			break
+		}
+	}
+	r.reindex()
+}
+
+// reindex 调用时必须持有r.mu
+func (r *KeyRing) reindex() {
+	for i, k := range r.keys {
+		k.usage.Index = i
+	}
+}
+
+// Len 返回密钥的数量
+func (r *KeyRing) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.keys)
+}
+
+// Decrypt 按顺序使用每个密钥解密ciphertext，appid不为空时校验解密后的appid，
+// 返回消息明文、解密使用的密钥和消息中的appid，并记录密钥的使用情况
+func (r *KeyRing) Decrypt(appid, ciphertext string) ([]byte, string, string, error) {
+	r.mu.Lock()
+	keys := append([]*ringKey(nil), r.keys...)
+	r.mu.Unlock()
+	if len(keys) == 0 {
+		return nil, "", "", errors.New("EncodingAESKey is empty")
+	}
+	var err error
+	for _, k := range keys {
+		var b []byte
+		if b, err = decrypt(k.block, k.iv, ciphertext); err != nil {
+			continue
+		}
+		var plaintext []byte
+		var id string
+		if plaintext, id, err = ParseDecryptMessage(b); err != nil {
+			continue
+		}
+		if appid != "" && id != appid {
+			// 密钥错误时appid也不一致，继续尝试下一个密钥
+			err = fmt.Errorf("%w: %q", ErrValidateAppID, id)
+			continue
+		}
+		r.mu.Lock()
+		k.usage.Decrypted++
+		k.usage.LastUsed = time.Now()
+		if r.now != nil {
+			k.usage.LastUsed = r.now()
+		}
+		r.mu.Unlock()
+		return plaintext, k.key, id, nil
+	}
+	return nil, "", "", err
+}
+
+// Usages 返回每个密钥的使用情况，按照密钥的顺序，可用于监控
+func (r *KeyRing) Usages() []KeyUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usages := make([]KeyUsage, len(r.keys))
+	for i, k := range r.keys {
+		usages[i] = k.usage
+	}
+	return usages
+}
+
+// KeyRing 返回wx.EncodingAESKey、wx.OldEncodingAESKey和wx.OldEncodingAESKeys组成的*KeyRing，
+// 第一次调用时创建，密钥非法时返回错误
+func (wx *WeiXin) KeyRing() (*KeyRing, error) {
+	wx.mu.Lock()
+	defer wx.mu.Unlock()
+	if wx.keys == nil {
+		keys := append([]string{wx.EncodingAESKey, wx.OldEncodingAESKey}, wx.OldEncodingAESKeys...)
+		ring, err := NewKeyRing(keys...)
+		if err != nil {
+			return nil, err
+		}
+		wx.keys = ring
+	}
+	return wx.keys, nil
+}
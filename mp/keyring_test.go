@@ -0,0 +1,88 @@
+package mp
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const newEncodingAESKey = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopq"
+
+func TestKeyRing(t *testing.T) {
+	ring, err := NewKeyRing(newEncodingAESKey, "", encodingAESKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ring.Len() != 2 {
+		t.Fatalf("len %d", ring.Len())
+	}
+	ciphertext, err := Encrypt(encodingAESKey, appid, []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, key, id, err := ring.Decrypt(appid, ciphertext)
+	if err != nil || string(plaintext) != "old" || key != encodingAESKey || id != appid {
+		t.Fatalf("decrypt %q %q %q %v", plaintext, key, id, err)
+	}
+	if _, _, _, err := ring.Decrypt("wx0000000000000000", ciphertext); !errors.Is(err, ErrValidateAppID) {
+		t.Fatalf("appid error %v", err)
+	}
+	usages := ring.Usages()
+	if usages[0].Decrypted != 0 || usages[1].Decrypted != 1 || usages[1].Index != 1 || usages[1].LastUsed.IsZero() {
+		t.Fatalf("usages %+v", usages)
+	}
+	if usages[1].ID == "" || usages[1].ID == encodingAESKey[:8] {
+		t.Fatalf("key id %q", usages[1].ID)
+	}
+
+	ring.Promote(encodingAESKey)
+	if usages := ring.Usages(); usages[0].Decrypted != 1 || usages[0].Index != 0 {
+		t.Fatalf("promote %+v", usages)
+	}
+	ring.Remove(newEncodingAESKey)
+	if ring.Len() != 1 {
+		t.Fatalf("remove len %d", ring.Len())
+	}
+	if err := ring.Add("short"); !errors.Is(err, ErrIllegalAESKey) {
+		t.Fatalf("add %v", err)
+	}
+}
+
+// TestServerKeyRing 使用旧密钥加密的消息，回复也使用旧密钥加密
+func TestServerKeyRing(t *testing.T) {
+	ring, err := NewKeyRing(newEncodingAESKey, encodingAESKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{AppID: appid, Token: token, Keys: ring,
+		Handler: HandlerFunc(func(ctx context.Context, msg *Message) *ResponseMessage {
+			return NewTextMessage(msg.FromUserName, msg.ToUserName, "ok")
+		})}
+	text := `<xml><ToUserName>gh_test</ToUserName><FromUserName>user</FromUserName><CreateTime>1</CreateTime><MsgType>text</MsgType><Content>hi</Content><MsgId>1</MsgId></xml>`
+	ciphertext, err := Encrypt(encodingAESKey, appid, []byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := url.Values{
+		"timestamp":     {timestamp},
+		"nonce":         {nonce},
+		"encrypt_type":  {"aes"},
+		"msg_signature": {Sign(token, timestamp, nonce, ciphertext)},
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, serverRequest("POST", q, `<xml><Encrypt><![CDATA[`+ciphertext+`]]></Encrypt></xml>`))
+	var eres EncryptResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &eres); err != nil {
+		t.Fatalf("%d %s %v", w.Code, w.Body.String(), err)
+	}
+	old, _ := NewMsgCrypt(token, encodingAESKey, appid)
+	if _, err := old.Decrypt(string(eres.Encrypt)); err != nil {
+		t.Fatalf("reply not encrypted with the old key: %v", err)
+	}
+	if usages := ring.Usages(); usages[0].Decrypted != 0 || usages[1].Decrypted != 1 {
+		t.Fatalf("usages %+v", usages)
+	}
+}
@@ -0,0 +1,44 @@
+package mp
+
+import (
+	"qingtao/weixin/mp/core"
+	"qingtao/weixin/mp/cs"
+	"qingtao/weixin/mp/media"
+	"qingtao/weixin/mp/users"
+)
+
+// Limit 一个接口的调用限制
+type Limit = core.Limit
+
+// Limiter 按接口路径限制调用频率，并统计每日调用次数
+type Limiter = core.Limiter
+
+// Usage 一个接口当天的调用情况
+type Usage = core.Usage
+
+// NewLimiter 创建*Limiter，limits的key是接口的路径或者路径的前缀
+func NewLimiter(limits map[string]Limit) *Limiter {
+	return core.NewLimiter(limits)
+}
+
+// DefaultLimits 常用接口的每日调用次数，是未认证订阅号的默认配额，
+// 实际配额以公众平台“接口权限”中显示的为准，可以修改返回值后再使用
+func DefaultLimits() map[string]Limit {
+	return map[string]Limit{
+		WxTokenPath:                             {Daily: 2000},
+		WxGetCallBackIPPath:                     {Daily: 10000},
+		WxMenuPath + "/" + WxMenuCreate:         {Daily: 1000},
+		WxMenuPath + "/" + WxMenuGet:            {Daily: 10000},
+		WxMenuPath + "/" + WxMenuDelete:         {Daily: 1000},
+		WxMenuPath + "/" + WxMenuTryMatch:       {Daily: 20000},
+		WxMenuPath + "/" + WxMenuAddConditional: {Daily: 2000},
+		WxGetCurrentSelfMenu:                    {Daily: 10000},
+		cs.WxKfSend:                             {Daily: 500000},
+		users.WxUserInfoPath:                    {Daily: 5000000},
+		users.WxUsersInfoPath:                   {Daily: 5000000},
+		media.WxMediaUpload:                     {Daily: 100000},
+		media.WxMediaGet:                        {Daily: 200000},
+		media.WxMaterailAddOther:                {Daily: 5000},
+		media.WxMaterailAdd:                     {Daily: 5000},
+	}
+}
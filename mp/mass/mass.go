@@ -0,0 +1,388 @@
+package mass
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// WxMassSendAll 按照标签群发
+	WxMassSendAll = "cgi-bin/message/mass/sendall"
+	// WxMassSend 按照openid列表群发
+	WxMassSend = "cgi-bin/message/mass/send"
+	// WxMassPreview 预览
+	WxMassPreview = "cgi-bin/message/mass/preview"
+	// WxMassGet 查询群发消息发送状态
+	WxMassGet = "cgi-bin/message/mass/get"
+	// WxMassDelete 删除群发
+	WxMassDelete = "cgi-bin/message/mass/delete"
+	// WxMassSpeedGet 获取群发速度
+	WxMassSpeedGet = "cgi-bin/message/mass/speed/get"
+	// WxMassSpeedSet 设置群发速度
+	WxMassSpeedSet = "cgi-bin/message/mass/speed/set"
+	// WxMassUploadVideo 将视频素材转换为群发视频
+	WxMassUploadVideo = "cgi-bin/media/uploadvideo"
+)
+
+// 群发消息的类型
+const (
+	// MsgTypeMPNews 图文消息
+	MsgTypeMPNews = "mpnews"
+	// MsgTypeText 文本消息
+	MsgTypeText = "text"
+	// MsgTypeVoice 语音消息
+	MsgTypeVoice = "voice"
+	// MsgTypeImage 图片消息，群发时可以包含多张图片
+	MsgTypeImage = "image"
+	// MsgTypeMPVideo 视频消息
+	MsgTypeMPVideo = "mpvideo"
+	// MsgTypeWxCard 卡券消息
+	MsgTypeWxCard = "wxcard"
+)
+
+// 群发消息的发送状态
+const (
+	// StatusSending 发送中
+	StatusSending = "SENDING"
+	// StatusSuccess 发送成功
+	StatusSuccess = "SEND_SUCCESS"
+	// StatusFail 发送失败
+	StatusFail = "SEND_FAIL"
+	// StatusDelete 已删除
+	StatusDelete = "DELETE"
+)
+
+// MaxOpenIDs 按照openid列表群发时每次最多10000个用户，最少2个
+const MaxOpenIDs = 10000
+
+// Media 使用素材的消息内容
+type Media struct {
+	// MediaID 永久素材的media_id，例如media.Service.AddMaterial返回的MediaID
+	MediaID string `json:"media_id"`
+}
+
+// Text 文本消息的内容
+type Text struct {
+	Content string `json:"content"`
+}
+
+// Images 图片消息的内容
+type Images struct {
+	// MediaIDs 图片永久素材的media_id，最多8张
+	MediaIDs []string `json:"media_ids"`
+	// Recommend 推荐语，不填时默认为"分享图片"
+	Recommend string `json:"recommend,omitempty"`
+	// NeedOpenComment 1表示打开留言
+	NeedOpenComment int `json:"need_open_comment"`
+	// OnlyFansCanComment 1表示只有粉丝可以留言
+	OnlyFansCanComment int `json:"only_fans_can_comment"`
+}
+
+// MPVideo 视频消息的内容，MediaID是UploadVideo返回的media_id
+type MPVideo struct {
+	MediaID string `json:"media_id"`
+	// Title 按照openid列表群发时使用的标题
+	Title string `json:"title,omitempty"`
+	// Description 按照openid列表群发时使用的描述
+	Description string `json:"description,omitempty"`
+}
+
+// WxCard 卡券消息的内容
+type WxCard struct {
+	CardID string `json:"card_id"`
+}
+
+// Message 群发消息，MsgType对应的内容不能为空，使用NewMPNews等函数创建
+type Message struct {
+	MsgType string   `json:"msgtype"`
+	MPNews  *Media   `json:"mpnews,omitempty"`
+	Text    *Text    `json:"text,omitempty"`
+	Voice   *Media   `json:"voice,omitempty"`
+	Images  *Images  `json:"images,omitempty"`
+	MPVideo *MPVideo `json:"mpvideo,omitempty"`
+	WxCard  *WxCard  `json:"wxcard,omitempty"`
+	// SendIgnoreReprint 图文消息被判定为转载时，1表示继续群发，0表示停止群发，只用于图文消息
+	SendIgnoreReprint int `json:"send_ignore_reprint,omitempty"`
+	// ClientMsgID 防重入id，24小时内同一个id的群发只发送一次
+	ClientMsgID string `json:"clientmsgid,omitempty"`
+}
+
+// NewMPNews 使用图文永久素材的mediaID创建图文消息，
+// ignoreReprint为true时被判定为转载的图文也继续群发
+func NewMPNews(mediaID string, ignoreReprint bool) *Message {
+	msg := &Message{MsgType: MsgTypeMPNews, MPNews: &Media{MediaID: mediaID}}
+	if ignoreReprint {
+		msg.SendIgnoreReprint = 1
+	}
+	return msg
+}
+
+// NewText 创建文本消息
+func NewText(content string) *Message {
+	return &Message{MsgType: MsgTypeText, Text: &Text{Content: content}}
+}
+
+// NewVoice 使用语音永久素材的mediaID创建语音消息
+func NewVoice(mediaID string) *Message {
+	return &Message{MsgType: MsgTypeVoice, Voice: &Media{MediaID: mediaID}}
+}
+
+// NewImages 使用图片永久素材的mediaIDs创建图片消息，需要推荐语和留言时修改msg.Images
+func NewImages(mediaIDs ...string) *Message {
+	return &Message{MsgType: MsgTypeImage, Images: &Images{MediaIDs: mediaIDs}}
+}
+
+// NewMPVideo 使用UploadVideo返回的mediaID创建视频消息
+func NewMPVideo(mediaID string) *Message {
+	return &Message{MsgType: MsgTypeMPVideo, MPVideo: &MPVideo{MediaID: mediaID}}
+}
+
+// NewWxCard 创建卡券消息
+func NewWxCard(cardID string) *Message {
+	return &Message{MsgType: MsgTypeWxCard, WxCard: &WxCard{CardID: cardID}}
+}
+
+// validate 检查MsgType对应的内容
+func (msg *Message) validate() error {
+	if msg == nil {
+		return errors.New("message is nil")
+	}
+	var ok bool
+	switch msg.MsgType {
+	case MsgTypeMPNews:
+		ok = msg.MPNews != nil && msg.MPNews.MediaID != ""
+	case MsgTypeText:
+		ok = msg.Text != nil && msg.Text.Content != ""
+	case MsgTypeVoice:
+		ok = msg.Voice != nil && msg.Voice.MediaID != ""
+	case MsgTypeImage:
+		ok = msg.Images != nil && len(msg.Images.MediaIDs) > 0
+	case MsgTypeMPVideo:
+		ok = msg.MPVideo != nil && msg.MPVideo.MediaID != ""
+	case MsgTypeWxCard:
+		ok = msg.WxCard != nil && msg.WxCard.CardID != ""
+	default:
+		return fmt.Errorf("unsupported msgtype %q", msg.MsgType)
+	}
+	if !ok {
+		return fmt.Errorf("content of %s is empty", msg.MsgType)
+	}
+	return nil
+}
+
+// Filter 按照标签群发的接收者，IsToAll为true时发送给全部用户，否则发送给TagID标签下的用户
+type Filter struct {
+	IsToAll bool `json:"is_to_all"`
+	TagID   int  `json:"tag_id"`
+}
+
+// MarshalJSON 发送给全部用户时省略tag_id，否则总是包含tag_id，因为标签id可以为0
+func (f Filter) MarshalJSON() ([]byte, error) {
+	if f.IsToAll {
+		return []byte(`{"is_to_all":true}`), nil
+	}
+	type filter Filter
+	return json.Marshal(filter(f))
+}
+
+// ToAll 发送给全部用户
+func ToAll() *Filter {
+	return &Filter{IsToAll: true}
+}
+
+// ToTag 发送给标签tagID下的用户
+func ToTag(tagID int) *Filter {
+	return &Filter{TagID: tagID}
+}
+
+// Result 群发的结果，发送结果通过MASSSENDJOBFINISH事件推送，事件的MsgID与Result.MsgID相同
+type Result struct {
+	// MsgID 群发任务的id
+	MsgID int64 `json:"msg_id"`
+	// MsgDataID 图文消息的数据id，用于图文分析和留言管理，只有图文消息返回
+	MsgDataID int64 `json:"msg_data_id,omitempty"`
+}
+
+// SendAll 按照filter群发消息
+func (s *Service) SendAll(filter *Filter, msg *Message) (*Result, error) {
+	return s.SendAllContext(context.Background(), filter, msg)
+}
+
+// SendAllContext 按照filter群发消息，ctx取消时中止请求
+func (s *Service) SendAllContext(ctx context.Context, filter *Filter, msg *Message) (*Result, error) {
+	if filter == nil {
+		return nil, errors.New("send mass message: filter is nil")
+	}
+	if err := msg.validate(); err != nil {
+		return nil, fmt.Errorf("send mass message %s", err)
+	}
+	req := struct {
+		Filter *Filter `json:"filter"`
+		*Message
+	}{filter, msg}
+	var result Result
+	if err := s.c.PostJSON(ctx, WxMassSendAll, nil, req, &result); err != nil {
+		return nil, fmt.Errorf("send mass message %w", err)
+	}
+	return &result, nil
+}
+
+// Send 按照openid列表群发消息，openids至少2个，最多MaxOpenIDs个
+func (s *Service) Send(openids []string, msg *Message) (*Result, error) {
+	return s.SendContext(context.Background(), openids, msg)
+}
+
+// SendContext 按照openid列表群发消息，openids至少2个，最多MaxOpenIDs个，ctx取消时中止请求
+func (s *Service) SendContext(ctx context.Context, openids []string, msg *Message) (*Result, error) {
+	if len(openids) < 2 || len(openids) > MaxOpenIDs {
+		return nil, fmt.Errorf("send mass message: need 2 to %d openids, got %d", MaxOpenIDs, len(openids))
+	}
+	if err := msg.validate(); err != nil {
+		return nil, fmt.Errorf("send mass message %s", err)
+	}
+	req := struct {
+		ToUser []string `json:"touser"`
+		*Message
+	}{openids, msg}
+	var result Result
+	if err := s.c.PostJSON(ctx, WxMassSend, nil, req, &result); err != nil {
+		return nil, fmt.Errorf("send mass message %w", err)
+	}
+	return &result, nil
+}
+
+// Preview 发送预览消息给openid或者微信号wxname，wxname不为空时优先使用，
+// 预览图片消息时只发送第一张图片
+func (s *Service) Preview(openid, wxname string, msg *Message) error {
+	return s.PreviewContext(context.Background(), openid, wxname, msg)
+}
+
+// PreviewContext 发送预览消息给openid或者微信号wxname，wxname不为空时优先使用，
+// 预览图片消息时只发送第一张图片，ctx取消时中止请求
+func (s *Service) PreviewContext(ctx context.Context, openid, wxname string, msg *Message) error {
+	if openid == "" && wxname == "" {
+		return errors.New("preview mass message: touser and towxname are empty")
+	}
+	if err := msg.validate(); err != nil {
+		return fmt.Errorf("preview mass message %s", err)
+	}
+	req := struct {
+		ToUser   string `json:"touser,omitempty"`
+		ToWxName string `json:"towxname,omitempty"`
+		// Image 预览接口的图片消息只支持一张图片
+		Image *Media `json:"image,omitempty"`
+		*Message
+	}{ToUser: openid, ToWxName: wxname, Message: msg}
+	if msg.MsgType == MsgTypeImage {
+		m := *msg
+		m.Images = nil
+		req.Message = &m
+		req.Image = &Media{MediaID: msg.Images.MediaIDs[0]}
+	}
+	if err := s.c.PostJSON(ctx, WxMassPreview, nil, req, nil); err != nil {
+		return fmt.Errorf("preview mass message %w", err)
+	}
+	return nil
+}
+
+// Get 查询群发消息msgID的发送状态，返回StatusSending、StatusSuccess、StatusFail或者StatusDelete
+func (s *Service) Get(msgID int64) (string, error) {
+	return s.GetContext(context.Background(), msgID)
+}
+
+// GetContext 查询群发消息msgID的发送状态，返回StatusSending、StatusSuccess、StatusFail或者StatusDelete，
+// ctx取消时中止请求
+func (s *Service) GetContext(ctx context.Context, msgID int64) (string, error) {
+	req := struct {
+		MsgID int64 `json:"msg_id"`
+	}{msgID}
+	var resp struct {
+		MsgStatus string `json:"msg_status"`
+	}
+	if err := s.c.QueryJSON(ctx, WxMassGet, nil, req, &resp); err != nil {
+		return "", fmt.Errorf("get mass message status %w", err)
+	}
+	return resp.MsgStatus, nil
+}
+
+// Delete 删除群发消息msgID，只能删除图文和视频消息，
+// articleIdx是要删除的文章在图文中的位置，从1开始，0表示删除全部文章
+func (s *Service) Delete(msgID int64, articleIdx int) error {
+	return s.DeleteContext(context.Background(), msgID, articleIdx)
+}
+
+// DeleteContext 删除群发消息msgID，只能删除图文和视频消息，
+// articleIdx是要删除的文章在图文中的位置，从1开始，0表示删除全部文章，ctx取消时中止请求
+func (s *Service) DeleteContext(ctx context.Context, msgID int64, articleIdx int) error {
+	req := struct {
+		MsgID      int64 `json:"msg_id"`
+		ArticleIdx int   `json:"article_idx"`
+	}{msgID, articleIdx}
+	if err := s.c.PostJSON(ctx, WxMassDelete, nil, req, nil); err != nil {
+		return fmt.Errorf("delete mass message %w", err)
+	}
+	return nil
+}
+
+// Speed 群发速度，Speed为0到4的等级，0最快，RealSpeed为每分钟发送的万条数
+type Speed struct {
+	Speed     int `json:"speed"`
+	RealSpeed int `json:"realspeed"`
+}
+
+// GetSpeed 获取群发速度
+func (s *Service) GetSpeed() (*Speed, error) {
+	return s.GetSpeedContext(context.Background())
+}
+
+// GetSpeedContext 获取群发速度，ctx取消时中止请求
+func (s *Service) GetSpeedContext(ctx context.Context) (*Speed, error) {
+	var speed Speed
+	if err := s.c.QueryJSON(ctx, WxMassSpeedGet, nil, struct{}{}, &speed); err != nil {
+		return nil, fmt.Errorf("get mass speed %w", err)
+	}
+	return &speed, nil
+}
+
+// SetSpeed 设置群发速度，speed为0到4，0表示80万/分钟，4表示10万/分钟
+func (s *Service) SetSpeed(speed int) error {
+	return s.SetSpeedContext(context.Background(), speed)
+}
+
+// SetSpeedContext 设置群发速度，speed为0到4，0表示80万/分钟，4表示10万/分钟，ctx取消时中止请求
+func (s *Service) SetSpeedContext(ctx context.Context, speed int) error {
+	if speed < 0 || speed > 4 {
+		return fmt.Errorf("set mass speed: speed %d out of range [0, 4]", speed)
+	}
+	req := struct {
+		Speed int `json:"speed"`
+	}{speed}
+	if err := s.c.PostJSON(ctx, WxMassSpeedSet, nil, req, nil); err != nil {
+		return fmt.Errorf("set mass speed %w", err)
+	}
+	return nil
+}
+
+// UploadVideo 将视频永久素材mediaID转换为群发视频，返回NewMPVideo使用的media_id
+func (s *Service) UploadVideo(mediaID, title, description string) (string, error) {
+	return s.UploadVideoContext(context.Background(), mediaID, title, description)
+}
+
+// UploadVideoContext 将视频永久素材mediaID转换为群发视频，返回NewMPVideo使用的media_id，
+// ctx取消时中止请求
+func (s *Service) UploadVideoContext(ctx context.Context, mediaID, title, description string) (string, error) {
+	req := struct {
+		MediaID     string `json:"media_id"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}{mediaID, title, description}
+	var resp struct {
+		MediaID string `json:"media_id"`
+	}
+	if err := s.c.PostJSON(ctx, WxMassUploadVideo, nil, req, &resp); err != nil {
+		return "", fmt.Errorf("upload mass video %w", err)
+	}
+	return resp.MediaID, nil
+}
@@ -0,0 +1,122 @@
+package mass
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"qingtao/weixin/mp/core"
+)
+
+func TestSend(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got = nil
+		json.Unmarshal(b, &got)
+		switch r.URL.Path {
+		case "/" + WxMassSendAll, "/" + WxMassSend:
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"send job submission success","msg_id":34182,"msg_data_id":206227730}`)
+		case "/" + WxMassPreview:
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"preview success","msg_id":34182}`)
+		default:
+			t.Errorf("path %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	result, err := s.SendAll(ToTag(2), NewMPNews("mpnews_media_id", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MsgID != 34182 || result.MsgDataID != 206227730 {
+		t.Fatalf("result %+v", result)
+	}
+	filter := got["filter"].(map[string]interface{})
+	if filter["is_to_all"] != false || filter["tag_id"] != 2.0 || got["msgtype"] != "mpnews" || got["send_ignore_reprint"] != 1.0 {
+		t.Fatalf("sendall %v", got)
+	}
+
+	// 标签id可以为0，非图文消息不发送send_ignore_reprint
+	if _, err := s.SendAll(ToTag(0), NewText("hello")); err != nil {
+		t.Fatal(err)
+	}
+	filter = got["filter"].(map[string]interface{})
+	if tagID, ok := filter["tag_id"]; !ok || tagID != 0.0 {
+		t.Fatalf("sendall to tag 0 %v", got)
+	}
+	if _, ok := got["send_ignore_reprint"]; ok {
+		t.Fatalf("send_ignore_reprint in text message %v", got)
+	}
+	if _, err := s.SendAll(ToAll(), NewText("hello")); err != nil {
+		t.Fatal(err)
+	}
+	filter = got["filter"].(map[string]interface{})
+	if _, ok := filter["tag_id"]; ok || filter["is_to_all"] != true {
+		t.Fatalf("sendall to all %v", got)
+	}
+
+	msg := NewImages("img1", "img2")
+	msg.Images.NeedOpenComment = 1
+	if _, err := s.Send([]string{"openid1", "openid2"}, msg); err != nil {
+		t.Fatal(err)
+	}
+	images := got["images"].(map[string]interface{})
+	if len(got["touser"].([]interface{})) != 2 || len(images["media_ids"].([]interface{})) != 2 || images["need_open_comment"] != 1.0 {
+		t.Fatalf("send %v", got)
+	}
+
+	if err := s.Preview("", "wxname", msg); err != nil {
+		t.Fatal(err)
+	}
+	if got["towxname"] != "wxname" || got["images"] != nil || got["image"].(map[string]interface{})["media_id"] != "img1" {
+		t.Fatalf("preview %v", got)
+	}
+	if msg.Images == nil {
+		t.Fatal("preview modified msg")
+	}
+
+	if _, err := s.Send([]string{"openid1"}, NewText("hello")); err == nil {
+		t.Fatal("send to one openid")
+	}
+	if _, err := s.SendAll(ToAll(), &Message{MsgType: MsgTypeVoice}); err == nil {
+		t.Fatal("empty voice")
+	}
+}
+
+func TestService(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + WxMassGet:
+			fmt.Fprint(w, `{"msg_id":201053012,"msg_status":"SEND_SUCCESS"}`)
+		case "/" + WxMassSpeedGet:
+			fmt.Fprint(w, `{"speed":3,"realspeed":15}`)
+		case "/" + WxMassUploadVideo:
+			fmt.Fprint(w, `{"type":"video","media_id":"mass_video","created_at":1398848981}`)
+		default:
+			fmt.Fprint(w, `{"errcode":45009,"errmsg":"reach max api daily quota limit"}`)
+		}
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	if status, err := s.Get(201053012); err != nil || status != StatusSuccess {
+		t.Fatalf("get %s %v", status, err)
+	}
+	if speed, err := s.GetSpeed(); err != nil || speed.Speed != 3 || speed.RealSpeed != 15 {
+		t.Fatalf("speed %+v %v", speed, err)
+	}
+	if id, err := s.UploadVideo("video", "title", "desc"); err != nil || id != "mass_video" {
+		t.Fatalf("upload video %s %v", id, err)
+	}
+	if err := s.Delete(201053012, 0); core.ErrCode(err) != 45009 {
+		t.Fatalf("delete %v", err)
+	}
+	if err := s.SetSpeed(5); err == nil {
+		t.Fatal("speed out of range")
+	}
+}
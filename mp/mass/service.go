@@ -0,0 +1,14 @@
+// Package mass 公众号群发消息接口
+package mass
+
+import "qingtao/weixin/mp/core"
+
+// Service 群发消息接口，通过*core.Client取得access_token和发送请求
+type Service struct {
+	c *core.Client
+}
+
+// NewService 使用c创建群发消息接口
+func NewService(c *core.Client) *Service {
+	return &Service{c}
+}
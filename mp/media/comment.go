@@ -1,10 +1,7 @@
 package media
 
 import (
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"strings"
+	"context"
 )
 
 const (
@@ -17,38 +14,51 @@ const (
 // JSONContentType http method=POST, Content-Type
 const JSONContentType = "application/json; charset=utf-8"
 
-// changeComment 修改评论的操作
-func changeComment(host, accessToken, action string, msgdataid, index uint32) (*Response, error) {
-	JSON := fmt.Sprintf(`{"msg_data_id":"%d", "index":"%d"}`, msgdataid, index)
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, action, accessToken)
-	res, err := http.Post(URL, JSONContentType, strings.NewReader(JSON))
-	if err != nil {
-		return nil, err
-	}
-	b, err := readResponse(res)
-	if err != nil {
-		return nil, err
-	}
+// commentRequest 评论接口提交的数据
+type commentRequest struct {
+	MsgDataID     uint32 `json:"msg_data_id"`
+	Index         uint32 `json:"index"`
+	UserCommentID uint32 `json:"user_comment_id,omitempty"`
+	Content       string `json:"content,omitempty"`
+}
+
+// postComment 提交评论接口请求
+func (s *Service) postComment(ctx context.Context, path string, req *commentRequest) (*Response, error) {
 	var resp Response
-	if err = json.Unmarshal(b, &resp); err != nil {
+	if err := s.c.PostJSON(ctx, path, nil, req, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
 
+// changeComment 修改评论的操作
+func (s *Service) changeComment(ctx context.Context, action string, msgdataid, index uint32) (*Response, error) {
+	return s.postComment(ctx, action, &commentRequest{MsgDataID: msgdataid, Index: index})
+}
+
 // OpenComment 打开评论功能
-func OpenComment(host, accessToken string, msgdataid, index uint32) (*Response, error) {
-	return changeComment(host, accessToken, WxOpenComment, msgdataid, index)
+func (s *Service) OpenComment(msgdataid, index uint32) (*Response, error) {
+	return s.OpenCommentContext(context.Background(), msgdataid, index)
+}
+
+// OpenCommentContext 打开评论功能，ctx取消时中止请求
+func (s *Service) OpenCommentContext(ctx context.Context, msgdataid, index uint32) (*Response, error) {
+	return s.changeComment(ctx, WxOpenComment, msgdataid, index)
 }
 
 // CloseComment 关闭评论功能
-func CloseComment(host, accessToken string, msgdataid, index uint32) (*Response, error) {
-	return changeComment(host, accessToken, wxCloseComment, msgdataid, index)
+func (s *Service) CloseComment(msgdataid, index uint32) (*Response, error) {
+	return s.CloseCommentContext(context.Background(), msgdataid, index)
+}
+
+// CloseCommentContext 关闭评论功能，ctx取消时中止请求
+func (s *Service) CloseCommentContext(ctx context.Context, msgdataid, index uint32) (*Response, error) {
+	return s.changeComment(ctx, wxCloseComment, msgdataid, index)
 }
 
 // CommentResponse 请求回复数据时，服务器的响应结构
 type CommentResponse struct {
-	ErrCode int        `json:",errcode,omitempty"`
+	ErrCode int        `json:"errcode,omitempty"`
 	ErrMsg  string     `json:"errmsg,omitempty"`
 	Total   int        `json:"total,omitempty"`
 	Comment []*Comment `json:"comment,omitempty"`
@@ -57,7 +67,7 @@ type CommentResponse struct {
 // Comment 回应中评论
 type Comment struct {
 	UserCommentID int           `json:"user_comment_id,omitempty"`
-	OpenID        int           `json:"openid,omitempty"`
+	OpenID        string        `json:"openid,omitempty"`
 	CreateTime    int           `json:"create_time,omitempty"`
 	Content       string        `json:"content,omitempty"`
 	CommentType   int           `json:"comment_type,omitempty"`
@@ -74,19 +84,21 @@ type CommentReply struct {
 const WxCommentList = `cgi-bin/comment/list`
 
 // GetCommentList 获取评论列表
-func GetCommentList(host, accessToken string, msgdataid, index, begin, count, typ uint32) (*CommentResponse, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, WxCommentList, accessToken)
-	JSON := fmt.Sprintf(`{"msg_data_id":%d,"index":%d,"begin":%d,"count":%d,"type":%d}`, msgdataid, index, begin, count, typ)
-	res, err := http.Post(URL, JSONContentType, strings.NewReader(JSON))
-	if err != nil {
-		return nil, err
-	}
-	b, err := readResponse(res)
-	if err != nil {
-		return nil, err
-	}
+func (s *Service) GetCommentList(msgdataid, index, begin, count, typ uint32) (*CommentResponse, error) {
+	return s.GetCommentListContext(context.Background(), msgdataid, index, begin, count, typ)
+}
+
+// GetCommentListContext 获取评论列表，ctx取消时中止请求
+func (s *Service) GetCommentListContext(ctx context.Context, msgdataid, index, begin, count, typ uint32) (*CommentResponse, error) {
+	req := struct {
+		MsgDataID uint32 `json:"msg_data_id"`
+		Index     uint32 `json:"index"`
+		Begin     uint32 `json:"begin"`
+		Count     uint32 `json:"count"`
+		Type      uint32 `json:"type"`
+	}{msgdataid, index, begin, count, typ}
 	var cres CommentResponse
-	if err = json.Unmarshal(b, &cres); err != nil {
+	if err := s.c.QueryJSON(ctx, WxCommentList, nil, req, &cres); err != nil {
 		return nil, err
 	}
 	return &cres, nil
@@ -100,68 +112,169 @@ const (
 )
 
 // ChangeElect 修改评论
-func ChangeElect(host, accessToken, path string, msgdataid, index, usercommentid uint32) (*Response, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, path, accessToken)
-	JSON := fmt.Sprintf(`{"msg_data_id":%d, "index":%d, "user_comment_id":%d}`, msgdataid, index, usercommentid)
-	res, err := http.Post(URL, JSONContentType, strings.NewReader(JSON))
-	if err != nil {
-		return nil, err
-	}
-	b, err := readResponse(res)
-	if err != nil {
-		return nil, err
-	}
-	var resp Response
-	if err = json.Unmarshal(b, &resp); err != nil {
-		return nil, err
-	}
-	return &resp, nil
+func (s *Service) ChangeElect(path string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.ChangeElectContext(context.Background(), path, msgdataid, index, usercommentid)
+}
+
+// ChangeElectContext 修改评论，ctx取消时中止请求
+func (s *Service) ChangeElectContext(ctx context.Context, path string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.postComment(ctx, path, &commentRequest{
+		MsgDataID:     msgdataid,
+		Index:         index,
+		UserCommentID: usercommentid,
+	})
 }
 
 // MarkElect 标记评论为精选
-func MarkElect(host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
-	return ChangeElect(host, accessToken, WxMarkElect, msgdataid, index, usercommentid)
+func (s *Service) MarkElect(msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.MarkElectContext(context.Background(), msgdataid, index, usercommentid)
+}
+
+// MarkElectContext 标记评论为精选，ctx取消时中止请求
+func (s *Service) MarkElectContext(ctx context.Context, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.ChangeElectContext(ctx, WxMarkElect, msgdataid, index, usercommentid)
 }
 
 // UnMarkElect 撤销评论精选
-func UnMarkElect(host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
-	return ChangeElect(host, accessToken, WxUnMarkElect, msgdataid, index, usercommentid)
+func (s *Service) UnMarkElect(msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.UnMarkElectContext(context.Background(), msgdataid, index, usercommentid)
+}
+
+// UnMarkElectContext 撤销评论精选，ctx取消时中止请求
+func (s *Service) UnMarkElectContext(ctx context.Context, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.ChangeElectContext(ctx, WxUnMarkElect, msgdataid, index, usercommentid)
 }
 
 // WxDelComment 删除评论
 const WxDelComment = `cgi-bin/comment/delete`
 
 // DeleteComment 删除评论
-func DeleteComment(host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
-	return ChangeElect(host, accessToken, WxDelComment, msgdataid, index, usercommentid)
+func (s *Service) DeleteComment(msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.DeleteCommentContext(context.Background(), msgdataid, index, usercommentid)
+}
+
+// DeleteCommentContext 删除评论，ctx取消时中止请求
+func (s *Service) DeleteCommentContext(ctx context.Context, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.ChangeElectContext(ctx, WxDelComment, msgdataid, index, usercommentid)
 }
 
 // WxReplyComment 回复评论的路径
 const WxReplyComment = `cgi-bin/comment/reply/add`
 
 // ReplyComment 回复评论
-func ReplyComment(host, accessToken string, msgdataid, index, usercommentid uint32, content string) (*Response, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, WxReplyComment, accessToken)
-	JSON := fmt.Sprintf(`{"msg_data_id":%d, "index":%d, "user_comment_id":%d, "content":%s}`, msgdataid, index, usercommentid, content)
-	res, err := http.Post(URL, JSONContentType, strings.NewReader(JSON))
-	if err != nil {
-		return nil, err
-	}
-	b, err := readResponse(res)
-	if err != nil {
-		return nil, err
-	}
-	var resp Response
-	if err = json.Unmarshal(b, &resp); err != nil {
-		return nil, err
-	}
-	return &resp, nil
+func (s *Service) ReplyComment(msgdataid, index, usercommentid uint32, content string) (*Response, error) {
+	return s.ReplyCommentContext(context.Background(), msgdataid, index, usercommentid, content)
+}
+
+// ReplyCommentContext 回复评论，ctx取消时中止请求
+func (s *Service) ReplyCommentContext(ctx context.Context, msgdataid, index, usercommentid uint32, content string) (*Response, error) {
+	return s.postComment(ctx, WxReplyComment, &commentRequest{
+		MsgDataID:     msgdataid,
+		Index:         index,
+		UserCommentID: usercommentid,
+		Content:       content,
+	})
 }
 
 // WxDelCommentReply 删除评论回复的api
 const WxDelCommentReply = `cgi-bin/comment/reply/delete`
 
+// DeleteCommentReply 删除回复评论的内容
+func (s *Service) DeleteCommentReply(msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.DeleteCommentReplyContext(context.Background(), msgdataid, index, usercommentid)
+}
+
+// DeleteCommentReplyContext 删除回复评论的内容，ctx取消时中止请求
+func (s *Service) DeleteCommentReplyContext(ctx context.Context, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return s.ChangeElectContext(ctx, WxDelCommentReply, msgdataid, index, usercommentid)
+}
+
+// OpenComment 打开评论功能
+func OpenComment(host, accessToken string, msgdataid, index uint32) (*Response, error) {
+	return newService(host, accessToken).OpenComment(msgdataid, index)
+}
+
+// OpenCommentContext 打开评论功能，ctx取消时中止请求
+func OpenCommentContext(ctx context.Context, host, accessToken string, msgdataid, index uint32) (*Response, error) {
+	return newService(host, accessToken).OpenCommentContext(ctx, msgdataid, index)
+}
+
+// CloseComment 关闭评论功能
+func CloseComment(host, accessToken string, msgdataid, index uint32) (*Response, error) {
+	return newService(host, accessToken).CloseComment(msgdataid, index)
+}
+
+// CloseCommentContext 关闭评论功能，ctx取消时中止请求
+func CloseCommentContext(ctx context.Context, host, accessToken string, msgdataid, index uint32) (*Response, error) {
+	return newService(host, accessToken).CloseCommentContext(ctx, msgdataid, index)
+}
+
+// GetCommentList 获取评论列表
+func GetCommentList(host, accessToken string, msgdataid, index, begin, count, typ uint32) (*CommentResponse, error) {
+	return newService(host, accessToken).GetCommentList(msgdataid, index, begin, count, typ)
+}
+
+// GetCommentListContext 获取评论列表，ctx取消时中止请求
+func GetCommentListContext(ctx context.Context, host, accessToken string, msgdataid, index, begin, count, typ uint32) (*CommentResponse, error) {
+	return newService(host, accessToken).GetCommentListContext(ctx, msgdataid, index, begin, count, typ)
+}
+
+// ChangeElect 修改评论
+func ChangeElect(host, accessToken, path string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return newService(host, accessToken).ChangeElect(path, msgdataid, index, usercommentid)
+}
+
+// ChangeElectContext 修改评论，ctx取消时中止请求
+func ChangeElectContext(ctx context.Context, host, accessToken, path string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return newService(host, accessToken).ChangeElectContext(ctx, path, msgdataid, index, usercommentid)
+}
+
+// MarkElect 标记评论为精选
+func MarkElect(host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return newService(host, accessToken).MarkElect(msgdataid, index, usercommentid)
+}
+
+// MarkElectContext 标记评论为精选，ctx取消时中止请求
+func MarkElectContext(ctx context.Context, host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return newService(host, accessToken).MarkElectContext(ctx, msgdataid, index, usercommentid)
+}
+
+// UnMarkElect 撤销评论精选
+func UnMarkElect(host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return newService(host, accessToken).UnMarkElect(msgdataid, index, usercommentid)
+}
+
+// UnMarkElectContext 撤销评论精选，ctx取消时中止请求
+func UnMarkElectContext(ctx context.Context, host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return newService(host, accessToken).UnMarkElectContext(ctx, msgdataid, index, usercommentid)
+}
+
+// DeleteComment 删除评论
+func DeleteComment(host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return newService(host, accessToken).DeleteComment(msgdataid, index, usercommentid)
+}
+
+// DeleteCommentContext 删除评论，ctx取消时中止请求
+func DeleteCommentContext(ctx context.Context, host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return newService(host, accessToken).DeleteCommentContext(ctx, msgdataid, index, usercommentid)
+}
+
+// ReplyComment 回复评论
+func ReplyComment(host, accessToken string, msgdataid, index, usercommentid uint32, content string) (*Response, error) {
+	return newService(host, accessToken).ReplyComment(msgdataid, index, usercommentid, content)
+}
+
+// ReplyCommentContext 回复评论，ctx取消时中止请求
+func ReplyCommentContext(ctx context.Context, host, accessToken string, msgdataid, index, usercommentid uint32, content string) (*Response, error) {
+	return newService(host, accessToken).ReplyCommentContext(ctx, msgdataid, index, usercommentid, content)
+}
+
 // DeleteCommentReply 删除回复评论的内容
 func DeleteCommentReply(host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
-	return ChangeElect(host, accessToken, WxDelCommentReply, msgdataid, index, usercommentid)
+	return newService(host, accessToken).DeleteCommentReply(msgdataid, index, usercommentid)
+}
+
+// DeleteCommentReplyContext 删除回复评论的内容，ctx取消时中止请求
+func DeleteCommentReplyContext(ctx context.Context, host, accessToken string, msgdataid, index, usercommentid uint32) (*Response, error) {
+	return newService(host, accessToken).DeleteCommentReplyContext(ctx, msgdataid, index, usercommentid)
 }
@@ -2,17 +2,19 @@ package media
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"qingtao/weixin/mp/core"
 )
 
 const (
@@ -58,6 +60,24 @@ type UploadResponse struct {
 
 // ParseFile 读取文件并检查文件的大小、扩展名，返回mutltipart的Content-Type，io.Reader, 如果任何错误，则err非空
 func ParseFile(typ, filename string, maxsize int, desc []byte) (contentType string, r io.Reader, err error) {
+	return ParseFileContext(context.Background(), typ, filename, maxsize, desc)
+}
+
+// ctxReader 读取时检查ctx，ctx取消后返回ctx.Err()
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ParseFileContext 同ParseFile，ctx取消时停止读取文件
+func ParseFileContext(ctx context.Context, typ, filename string, maxsize int, desc []byte) (contentType string, r io.Reader, err error) {
 	ext := strings.ToLower(filepath.Ext(filename))
 	switch typ {
 	case "image":
@@ -116,7 +136,7 @@ func ParseFile(typ, filename string, maxsize int, desc []byte) (contentType stri
 	}
 	defer fr.Close()
 	// 文件内容写入到w->buf
-	if _, err = io.Copy(w, fr); err != nil {
+	if _, err = io.Copy(w, &ctxReader{ctx, fr}); err != nil {
 		return "", nil, err
 	}
 	//写入 MaterialVideo 的 description
@@ -137,52 +157,102 @@ func ParseFile(typ, filename string, maxsize int, desc []byte) (contentType stri
 
 }
 
-// uploadMedia 上传素材到公众平台，host 正常是通过微信公众平台的域名，accessToken 是调用接口凭证
-func uploadMedia(host, accessToken, typ, filename string) (*UploadResponse, error) {
-	contentType, r, err := ParseFile(typ, filename, 0, nil)
+// uploadMedia 上传临时素材到公众平台
+func (s *Service) uploadMedia(ctx context.Context, typ, filename string) (*UploadResponse, error) {
+	contentType, r, err := ParseFileContext(ctx, typ, filename, 0, nil)
 	if err != nil {
 		return nil, err
 	}
-	// 使用host,WxMediaUpload,accessToken和typ连接成url
-	uri := fmt.Sprintf("https://%s/%s?access_token=%s&type=%s",
-		host, WxMediaUpload, accessToken, typ)
-	res, err := http.Post(uri, contentType, r)
+	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("when post %s %s: %s", typ, filename, err)
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("when post file %s, message received: %s", filename, err)
-	}
-	defer res.Body.Close()
 	var resp UploadResponse
-	if err := json.Unmarshal(b, &resp); err != nil {
-		return nil, err
+	query := url.Values{"type": {typ}}
+	if err = s.c.Post(ctx, WxMediaUpload, query, contentType, b, &resp); err != nil {
+		return nil, fmt.Errorf("when post %s %s: %w", typ, filename, err)
 	}
 	return &resp, nil
 }
 
+// UploadImage 上传图片
+func (s *Service) UploadImage(filename string) (*UploadResponse, error) {
+	return s.UploadImageContext(context.Background(), filename)
+}
+
+// UploadImageContext 上传图片，ctx取消时中止请求
+func (s *Service) UploadImageContext(ctx context.Context, filename string) (*UploadResponse, error) {
+	return s.uploadMedia(ctx, "image", filename)
+}
+
+// UploadVoice 上传音频
+func (s *Service) UploadVoice(filename string) (*UploadResponse, error) {
+	return s.UploadVoiceContext(context.Background(), filename)
+}
+
+// UploadVoiceContext 上传音频，ctx取消时中止请求
+func (s *Service) UploadVoiceContext(ctx context.Context, filename string) (*UploadResponse, error) {
+	return s.uploadMedia(ctx, "voice", filename)
+}
+
+// UploadVideo 上传视频
+func (s *Service) UploadVideo(filename string) (*UploadResponse, error) {
+	return s.UploadVideoContext(context.Background(), filename)
+}
+
+// UploadVideoContext 上传视频，ctx取消时中止请求
+func (s *Service) UploadVideoContext(ctx context.Context, filename string) (*UploadResponse, error) {
+	return s.uploadMedia(ctx, "video", filename)
+}
+
+// UploadThumb 上传缩略图
+func (s *Service) UploadThumb(filename string) (*UploadResponse, error) {
+	return s.UploadThumbContext(context.Background(), filename)
+}
+
+// UploadThumbContext 上传缩略图，ctx取消时中止请求
+func (s *Service) UploadThumbContext(ctx context.Context, filename string) (*UploadResponse, error) {
+	return s.uploadMedia(ctx, "thumb", filename)
+}
+
 // UploadImage 上传图片
 func UploadImage(host, accessToken, filename string) (*UploadResponse, error) {
-	return uploadMedia(host, "image", filename, accessToken)
+	return newService(host, accessToken).UploadImage(filename)
+}
+
+// UploadImageContext 上传图片，ctx取消时中止请求
+func UploadImageContext(ctx context.Context, host, accessToken, filename string) (*UploadResponse, error) {
+	return newService(host, accessToken).UploadImageContext(ctx, filename)
 }
 
 // UploadVoice 上传音频
 func UploadVoice(host, accessToken, filename string) (*UploadResponse, error) {
-	return uploadMedia(host, "voice", filename, accessToken)
+	return newService(host, accessToken).UploadVoice(filename)
+}
+
+// UploadVoiceContext 上传音频，ctx取消时中止请求
+func UploadVoiceContext(ctx context.Context, host, accessToken, filename string) (*UploadResponse, error) {
+	return newService(host, accessToken).UploadVoiceContext(ctx, filename)
 }
 
 // UploadVideo 上传视频
 func UploadVideo(host, accessToken, filename string) (*UploadResponse, error) {
-	return uploadMedia(host, "video", filename, accessToken)
+	return newService(host, accessToken).UploadVideo(filename)
+}
+
+// UploadVideoContext 上传视频，ctx取消时中止请求
+func UploadVideoContext(ctx context.Context, host, accessToken, filename string) (*UploadResponse, error) {
+	return newService(host, accessToken).UploadVideoContext(ctx, filename)
 }
 
 // UploadThumb 上传缩略图
 func UploadThumb(host, accessToken, filename string) (*UploadResponse, error) {
-	return uploadMedia(host, "thumb", filename, accessToken)
+	return newService(host, accessToken).UploadThumb(filename)
+}
+
+// UploadThumbContext 上传缩略图，ctx取消时中止请求
+func UploadThumbContext(ctx context.Context, host, accessToken, filename string) (*UploadResponse, error) {
+	return newService(host, accessToken).UploadThumbContext(ctx, filename)
 }
 
 // DownloadResponse 下载素材时返回错误信息用
@@ -201,44 +271,39 @@ func (dr DownloadResponse) String() string {
 }
 
 // GetMedia 下载素材, 如果error为nil，返回的字符串是文件保存的绝对路径
-func GetMedia(host, accessToken, mediaID, dir string) (string, error) {
-	uri := fmt.Sprintf("http://%s/%s?access_token=%smedia_id=%s",
-		host, WxMediaUpload, accessToken, mediaID)
-	res, err := http.Get(uri)
+func (s *Service) GetMedia(mediaID, dir string) (string, error) {
+	return s.GetMediaContext(context.Background(), mediaID, dir)
+}
+
+// GetMediaContext 下载素材, 如果error为nil，返回的字符串是文件保存的绝对路径，ctx取消时中止请求
+func (s *Service) GetMediaContext(ctx context.Context, mediaID, dir string) (string, error) {
+	res, err := s.c.Do(ctx, &core.Request{
+		Path:  WxMediaGet,
+		Query: url.Values{"media_id": {mediaID}},
+	})
 	if err != nil {
 		return "", err
 	}
+	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		return "", fmt.Errorf("%s", res.Status)
 	}
-	mediaType := res.Header.Get("Content-Type")
 	filename := ""
 	switch {
-	// 如果响应中的Content-Type 是jpg|png|gif， 提取文件名
-	case strings.Contains(mediaType, WxImageGIF) ||
-		strings.Contains(mediaType, WxImageJPEG) ||
-		strings.Contains(mediaType, WxImagePNG):
-		_, params, err := mime.ParseMediaType(
-			res.Header.Get("Content-disposition"))
-		if err != nil {
-			return "", fmt.Errorf("get filename %s", err)
-		}
-		// 赋值filename
-		filename = params["filename"]
-		// 如果响应的Content-Type是json或者text，尝试读取body并解析json
-	case strings.Contains(mediaType, "json") ||
-		strings.Contains(mediaType, "text"):
+	// 如果响应的Content-Type是json或者text，尝试读取body并解析json
+	case core.IsJSON(res):
 		b, err := ioutil.ReadAll(res.Body)
 		if err != nil {
 			return "", fmt.Errorf("read body %s", err)
 		}
-		//fmt.Printf("%s\n", b)
-		defer res.Body.Close()
 		var downResponse DownloadResponse
 		if err = json.Unmarshal(b, &downResponse); err != nil {
 			return "", fmt.Errorf("read body ok, %s", err)
 		}
 		if downResponse.VideoURL == "" {
+			if err = core.CheckResponse(WxMediaGet, b); err != nil {
+				return "", err
+			}
 			return "", fmt.Errorf("%s", downResponse)
 		}
 		uri, err := url.Parse(downResponse.VideoURL)
@@ -246,14 +311,24 @@ func GetMedia(host, accessToken, mediaID, dir string) (string, error) {
 			return "", fmt.Errorf(`video's url is invalid %s`, err)
 		}
 		//赋值filename
-		filename = uri.Path
+		filename = filepath.Base(uri.Path)
 
-		res, err = http.Get(downResponse.VideoURL)
+		res, err = s.c.GetURL(ctx, downResponse.VideoURL)
+		if err != nil {
+			return "", fmt.Errorf("get %s %w", downResponse.VideoURL, err)
+		}
+		defer res.Body.Close()
+	// 其他的Content-Type，从Content-disposition中提取文件名
+	default:
+		_, params, err := mime.ParseMediaType(
+			res.Header.Get("Content-disposition"))
 		if err != nil {
-			return "", fmt.Errorf("get %s %s", downResponse.VideoURL, err)
+			return "", fmt.Errorf("get filename %s", err)
 		}
+		// 赋值filename
+		filename = filepath.Base(params["filename"])
 	}
-	if filename == "" {
+	if filename == "" || filename == "." || filename == "/" {
 		return "", fmt.Errorf("get media: find filename error")
 	}
 	file := filepath.Join(dir, filename)
@@ -261,7 +336,6 @@ func GetMedia(host, accessToken, mediaID, dir string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("read response body %s", err)
 	}
-	defer res.Body.Close()
 	// 写入文件file
 	if err = ioutil.WriteFile(file, b, 0640); err != nil {
 		return "", fmt.Errorf("write file %s", err)
@@ -269,6 +343,16 @@ func GetMedia(host, accessToken, mediaID, dir string) (string, error) {
 	return file, nil
 }
 
+// GetMedia 下载素材, 如果error为nil，返回的字符串是文件保存的绝对路径
+func GetMedia(host, accessToken, mediaID, dir string) (string, error) {
+	return newService(host, accessToken).GetMedia(mediaID, dir)
+}
+
+// GetMediaContext 下载素材, 如果error为nil，返回的字符串是文件保存的绝对路径，ctx取消时中止请求
+func GetMediaContext(ctx context.Context, host, accessToken, mediaID, dir string) (string, error) {
+	return newService(host, accessToken).GetMediaContext(ctx, mediaID, dir)
+}
+
 // MaterialArticle 媒体永久图文素材
 type MaterialArticle struct {
 	Articles []*Article `json:"articles"`
@@ -283,9 +367,19 @@ func (m *MaterialArticle) Parse() (string, io.Reader, error) {
 	return "application/json; charset=utf-8", bytes.NewReader(b), nil
 }
 
+// target 实现materialTarget接口
+func (m *MaterialArticle) target() (string, string) {
+	return WxMaterailAdd, ""
+}
+
 // Upload 使用参数host, access_token，上传图文素材*MaterialArticle到公众平台永久素材库
 func (m *MaterialArticle) Upload(host, accessToken string) (*MaterialResponse, error) {
-	return UploadMaterial(host, accessToken, WxMaterailAdd, "", m)
+	return newService(host, accessToken).AddMaterial(m)
+}
+
+// UploadContext 使用参数host, access_token，上传图文素材*MaterialArticle到公众平台永久素材库，ctx取消时中止请求
+func (m *MaterialArticle) UploadContext(ctx context.Context, host, accessToken string) (*MaterialResponse, error) {
+	return newService(host, accessToken).AddMaterialContext(ctx, m)
 }
 
 // Article 图文，永久的
@@ -342,41 +436,79 @@ type MaterialMedia interface {
 	Parse() (string, io.Reader, error)
 }
 
+// contextParser 读取文件的素材实现此接口，ctx取消时停止读取
+type contextParser interface {
+	ParseContext(ctx context.Context) (string, io.Reader, error)
+}
+
+// materialTarget 永久素材上传的路径和type查询参数
+type materialTarget interface {
+	target() (path, typ string)
+}
+
 // UploadMaterial 上传图文素材到公众平台
-func UploadMaterial(host, accessToken, path, typ string, m MaterialMedia) (*MaterialResponse, error) {
+func (s *Service) UploadMaterial(path, typ string, m MaterialMedia) (*MaterialResponse, error) {
+	return s.UploadMaterialContext(context.Background(), path, typ, m)
+}
+
+// UploadMaterialContext 上传图文素材到公众平台，ctx取消时中止请求
+func (s *Service) UploadMaterialContext(ctx context.Context, path, typ string, m MaterialMedia) (*MaterialResponse, error) {
 	//使用接口MaterialMedia可以简化视频、图片和音频等的操作
-	contentType, r, err := m.Parse()
+	var (
+		contentType string
+		r           io.Reader
+		err         error
+	)
+	if p, ok := m.(contextParser); ok {
+		contentType, r, err = p.ParseContext(ctx)
+	} else {
+		contentType, r, err = m.Parse()
+	}
 	if err != nil {
 		return nil, err
 	}
-	//uri是图文素材上传的URL地址
-	uri := fmt.Sprintf("https://%s/%s?access_token=%s", host, path, accessToken)
-	// video等类型的文件需要type查询参数
-	if typ != "" {
-		uri = uri + "&type=" + typ
-	}
-
-	res, err := http.Post(uri, contentType, r)
+	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	// TODO: 可能需要检查其他前期的操作判断http相应码
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("when post material, response status is %d", res.StatusCode)
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("when post material, read response failed: %s", err)
+	// video等类型的文件需要type查询参数
+	var query url.Values
+	if typ != "" {
+		query = url.Values{"type": {typ}}
 	}
-	defer res.Body.Close()
 
 	var materialResponse MaterialResponse
-	if err = json.Unmarshal(b, &materialResponse); err != nil {
-		return nil, fmt.Errorf("when post material, read json message %s", err)
+	if err = s.c.Post(ctx, path, query, contentType, b, &materialResponse); err != nil {
+		return nil, fmt.Errorf("when post material, %w", err)
 	}
 	return &materialResponse, nil
 }
 
+// AddMaterial 上传永久素材m，m是*MaterialArticle、*MaterialImage、*MaterialVideo、*MaterialVoice或者*Materialthumb
+func (s *Service) AddMaterial(m MaterialMedia) (*MaterialResponse, error) {
+	return s.AddMaterialContext(context.Background(), m)
+}
+
+// AddMaterialContext 上传永久素材m，m是*MaterialArticle、*MaterialImage、*MaterialVideo、*MaterialVoice或者*Materialthumb，ctx取消时中止请求
+func (s *Service) AddMaterialContext(ctx context.Context, m MaterialMedia) (*MaterialResponse, error) {
+	t, ok := m.(materialTarget)
+	if !ok {
+		return nil, fmt.Errorf("unknown material %T", m)
+	}
+	path, typ := t.target()
+	return s.UploadMaterialContext(ctx, path, typ, m)
+}
+
+// UploadMaterial 上传图文素材到公众平台
+func UploadMaterial(host, accessToken, path, typ string, m MaterialMedia) (*MaterialResponse, error) {
+	return newService(host, accessToken).UploadMaterial(path, typ, m)
+}
+
+// UploadMaterialContext 上传图文素材到公众平台，ctx取消时中止请求
+func UploadMaterialContext(ctx context.Context, host, accessToken, path, typ string, m MaterialMedia) (*MaterialResponse, error) {
+	return newService(host, accessToken).UploadMaterialContext(ctx, path, typ, m)
+}
+
 // MaterialImage 图片素材，永久的,如果 InMaterial为true，不添加type查询字符串
 type MaterialImage struct {
 	InMaterial bool
@@ -385,22 +517,35 @@ type MaterialImage struct {
 
 // Parse 实现 MaterialMedia接口
 func (m *MaterialImage) Parse() (string, io.Reader, error) {
+	return m.ParseContext(context.Background())
+}
+
+// ParseContext 同Parse，ctx取消时停止读取文件
+func (m *MaterialImage) ParseContext(ctx context.Context) (string, io.Reader, error) {
 	size := WxImageMaxSize
 	// 判断图片大小限制
 	if m.InMaterial {
 		size = WxMaterialImageMaxSize
 	}
-	return ParseFile("image", m.FileName, size, nil)
+	return ParseFileContext(ctx, "image", m.FileName, size, nil)
 }
 
-// Upload 上传图片素材
-func (m *MaterialImage) Upload(host, accessToken string) (*MaterialResponse, error) {
-	typ, path := "image", WxMaterailAddOther
+// target 实现materialTarget接口
+func (m *MaterialImage) target() (string, string) {
 	if m.InMaterial {
-		typ = ""
-		path = WxMediaUploadImg
+		return WxMediaUploadImg, ""
 	}
-	return UploadMaterial(host, accessToken, path, typ, m)
+	return WxMaterailAddOther, "image"
+}
+
+// Upload 上传图片素材
+func (m *MaterialImage) Upload(host, accessToken string) (*MaterialResponse, error) {
+	return newService(host, accessToken).AddMaterial(m)
+}
+
+// UploadContext 上传图片素材，ctx取消时中止请求
+func (m *MaterialImage) UploadContext(ctx context.Context, host, accessToken string) (*MaterialResponse, error) {
+	return newService(host, accessToken).AddMaterialContext(ctx, m)
 }
 
 // MaterialVideo 视频素材，永久的
@@ -417,16 +562,31 @@ func (m *MaterialVideo) Describe() ([]byte, error) {
 
 // Parse 实现 MaterialMedia 接口
 func (m *MaterialVideo) Parse() (string, io.Reader, error) {
+	return m.ParseContext(context.Background())
+}
+
+// ParseContext 同Parse，ctx取消时停止读取文件
+func (m *MaterialVideo) ParseContext(ctx context.Context) (string, io.Reader, error) {
 	desc, err := m.Describe()
 	if err != nil {
 		return "", nil, err
 	}
-	return ParseFile("video", m.FileName, WxVideoMaxSize, desc)
+	return ParseFileContext(ctx, "video", m.FileName, WxVideoMaxSize, desc)
+}
+
+// target 实现materialTarget接口
+func (m *MaterialVideo) target() (string, string) {
+	return WxMaterailAddOther, "video"
 }
 
 // Upload 上传图片文件到微信公共平台
 func (m *MaterialVideo) Upload(host, accessToken string) (*MaterialResponse, error) {
-	return UploadMaterial(host, accessToken, WxMaterailAddOther, "video", m)
+	return newService(host, accessToken).AddMaterial(m)
+}
+
+// UploadContext 上传图片文件到微信公共平台，ctx取消时中止请求
+func (m *MaterialVideo) UploadContext(ctx context.Context, host, accessToken string) (*MaterialResponse, error) {
+	return newService(host, accessToken).AddMaterialContext(ctx, m)
 }
 
 // MaterialVoice 音频素材，永久的
@@ -436,12 +596,27 @@ type MaterialVoice struct {
 
 // Parse 实现 MaterialMedia 接口
 func (m *MaterialVoice) Parse() (string, io.Reader, error) {
-	return ParseFile("voice", m.FileName, WxVoiceMaxSize, nil)
+	return m.ParseContext(context.Background())
+}
+
+// ParseContext 同Parse，ctx取消时停止读取文件
+func (m *MaterialVoice) ParseContext(ctx context.Context) (string, io.Reader, error) {
+	return ParseFileContext(ctx, "voice", m.FileName, WxVoiceMaxSize, nil)
+}
+
+// target 实现materialTarget接口
+func (m *MaterialVoice) target() (string, string) {
+	return WxMaterailAddOther, "voice"
 }
 
 // Upload 上传音频文件到微信公共平台
 func (m *MaterialVoice) Upload(host, accessToken string) (*MaterialResponse, error) {
-	return UploadMaterial(host, accessToken, WxMaterailAddOther, "voice", m)
+	return newService(host, accessToken).AddMaterial(m)
+}
+
+// UploadContext 上传音频文件到微信公共平台，ctx取消时中止请求
+func (m *MaterialVoice) UploadContext(ctx context.Context, host, accessToken string) (*MaterialResponse, error) {
+	return newService(host, accessToken).AddMaterialContext(ctx, m)
 }
 
 // Materialthumb 素材的缩略图
@@ -451,10 +626,25 @@ type Materialthumb struct {
 
 // Parse 实现 MaterialMedia 接口
 func (m *Materialthumb) Parse() (string, io.Reader, error) {
-	return ParseFile("thumb", m.FileName, WxThumbMaxSize, nil)
+	return m.ParseContext(context.Background())
+}
+
+// ParseContext 同Parse，ctx取消时停止读取文件
+func (m *Materialthumb) ParseContext(ctx context.Context) (string, io.Reader, error) {
+	return ParseFileContext(ctx, "thumb", m.FileName, WxThumbMaxSize, nil)
+}
+
+// target 实现materialTarget接口
+func (m *Materialthumb) target() (string, string) {
+	return WxMaterailAddOther, "thumb"
 }
 
 // Upload 上传缩略图文件到微信公共平台
 func (m *Materialthumb) Upload(host, accessToken string) (*MaterialResponse, error) {
-	return UploadMaterial(host, accessToken, WxMaterailAddOther, "thumb", m)
+	return newService(host, accessToken).AddMaterial(m)
+}
+
+// UploadContext 上传缩略图文件到微信公共平台，ctx取消时中止请求
+func (m *Materialthumb) UploadContext(ctx context.Context, host, accessToken string) (*MaterialResponse, error) {
+	return newService(host, accessToken).AddMaterialContext(ctx, m)
 }
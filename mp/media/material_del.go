@@ -1,11 +1,8 @@
 package media
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strings"
 )
 
 // Response 响应错误代码和消息
@@ -18,23 +15,28 @@ type Response struct {
 const WxMaterialDel = "cgi-bin/material/del_material"
 
 // DeleteMaterial 删除永久素材
-func DeleteMaterial(host, accessToken, mediaID string) (*Response, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, WxMaterialDel, accessToken)
-	JSON := `{"media_id":"` + mediaID + `"}`
-	res, err := http.Post(URL, "application/json; charset=utf-8", strings.NewReader(JSON))
-	if err != nil {
-		return nil, err
-	}
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("delete material failed %s", res.Status)
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
+func (s *Service) DeleteMaterial(mediaID string) (*Response, error) {
+	return s.DeleteMaterialContext(context.Background(), mediaID)
+}
+
+// DeleteMaterialContext 删除永久素材，ctx取消时中止请求
+func (s *Service) DeleteMaterialContext(ctx context.Context, mediaID string) (*Response, error) {
+	req := struct {
+		MediaID string `json:"media_id"`
+	}{mediaID}
 	var resp Response
-	if err := json.Unmarshal(b, &resp); err != nil {
-		return nil, err
+	if err := s.c.PostJSON(ctx, WxMaterialDel, nil, req, &resp); err != nil {
+		return nil, fmt.Errorf("delete material failed %w", err)
 	}
 	return &resp, nil
 }
+
+// DeleteMaterial 删除永久素材
+func DeleteMaterial(host, accessToken, mediaID string) (*Response, error) {
+	return newService(host, accessToken).DeleteMaterial(mediaID)
+}
+
+// DeleteMaterialContext 删除永久素材，ctx取消时中止请求
+func DeleteMaterialContext(ctx context.Context, host, accessToken, mediaID string) (*Response, error) {
+	return newService(host, accessToken).DeleteMaterialContext(ctx, mediaID)
+}
@@ -1,12 +1,13 @@
 package media
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"path/filepath"
-	"strings"
+
+	"qingtao/weixin/mp/core"
 )
 
 // WxMaterailGet 获取永久图文素材路径
@@ -27,13 +28,29 @@ type MaterialGetResponse struct {
 }
 
 // GetMaterial 获取永久图文素材，返回的*MaterialGetResponse需要注意请求的是视频还是图文
-func GetMaterial(host, accessToken, typ, mediaID, dir string) (filename string, resp *MaterialGetResponse, err error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, accessToken, WxMaterailGet)
-	JSON := `{"media_id":"` + mediaID + `"}`
-	res, err := http.Post(URL, "application/json; charset=utf-8", strings.NewReader(JSON))
+func (s *Service) GetMaterial(typ, mediaID, dir string) (filename string, resp *MaterialGetResponse, err error) {
+	return s.GetMaterialContext(context.Background(), typ, mediaID, dir)
+}
+
+// GetMaterialContext 获取永久图文素材，返回的*MaterialGetResponse需要注意请求的是视频还是图文，ctx取消时中止请求
+func (s *Service) GetMaterialContext(ctx context.Context, typ, mediaID, dir string) (filename string, resp *MaterialGetResponse, err error) {
+	body, err := json.Marshal(struct {
+		MediaID string `json:"media_id"`
+	}{mediaID})
 	if err != nil {
-		return "", nil, fmt.Errorf("when get material %s-%s", mediaID, err)
+		return "", nil, err
 	}
+	res, err := s.c.Do(ctx, &core.Request{
+		Method:      "POST",
+		Path:        WxMaterailGet,
+		ContentType: core.JSONContentType,
+		Body:        body,
+		Idempotent:  true,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("when get material %s-%w", mediaID, err)
+	}
+	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		return "", nil, fmt.Errorf("when get material %s+%s", mediaID, res.Status)
 	}
@@ -41,7 +58,11 @@ func GetMaterial(host, accessToken, typ, mediaID, dir string) (filename string,
 	if err != nil {
 		return "", nil, fmt.Errorf("when get material %s/%s", mediaID, err)
 	}
-	defer res.Body.Close()
+	if core.IsJSON(res) {
+		if err = core.CheckResponse(WxMaterailGet, b); err != nil {
+			return "", nil, err
+		}
+	}
 
 	if err = json.Unmarshal(b, &resp); err != nil {
 		if typ != "video" && typ != "news" {
@@ -51,6 +72,17 @@ func GetMaterial(host, accessToken, typ, mediaID, dir string) (filename string,
 			}
 			return filename, nil, nil
 		}
+		return "", nil, fmt.Errorf("when get material %s: %s", mediaID, err)
 	}
 	return
 }
+
+// GetMaterial 获取永久图文素材，返回的*MaterialGetResponse需要注意请求的是视频还是图文
+func GetMaterial(host, accessToken, typ, mediaID, dir string) (filename string, resp *MaterialGetResponse, err error) {
+	return newService(host, accessToken).GetMaterial(typ, mediaID, dir)
+}
+
+// GetMaterialContext 获取永久图文素材，返回的*MaterialGetResponse需要注意请求的是视频还是图文，ctx取消时中止请求
+func GetMaterialContext(ctx context.Context, host, accessToken, typ, mediaID, dir string) (filename string, resp *MaterialGetResponse, err error) {
+	return newService(host, accessToken).GetMaterialContext(ctx, typ, mediaID, dir)
+}
@@ -3,18 +3,14 @@ package media
 // 注释先记一下大概
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 )
 
 // MaterialUpdater 用来生成json更新永久图文素材
 type MaterialUpdater struct {
 	MediaID  string   `json:"media_id,omitempty"`
-	Index    int      `json:"indext,omitempty"`
+	Index    int      `json:"index"`
 	Articles *Article `json:"articles,omitempty"`
 }
 
@@ -22,39 +18,27 @@ type MaterialUpdater struct {
 const WxMaterailUpdateNews = "cgi-bin/material/update_news"
 
 // UpdateMaterial 更新永久图文素材
-func UpdateMaterial(host, accessToken, path string, materialUpdater *MaterialUpdater) (*Response, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, path, accessToken)
-	b, err := json.Marshal(materialUpdater)
-	if err != nil {
-		return nil, fmt.Errorf("update material news: %s: %s", materialUpdater.MediaID, err)
-	}
-	res, err := http.Post(URL, "application/json; charset=utf-8", bytes.NewReader(b))
-	if err != nil {
-		return nil, err
-	}
-	b, err = readResponse(res)
-	if err != nil {
-		return nil, err
-	}
+func (s *Service) UpdateMaterial(path string, materialUpdater *MaterialUpdater) (*Response, error) {
+	return s.UpdateMaterialContext(context.Background(), path, materialUpdater)
+}
 
+// UpdateMaterialContext 更新永久图文素材，ctx取消时中止请求
+func (s *Service) UpdateMaterialContext(ctx context.Context, path string, materialUpdater *MaterialUpdater) (*Response, error) {
 	var resp Response
-	if err = json.Unmarshal(b, &resp); err != nil {
-		return nil, fmt.Errorf("update material news: %s: %s", materialUpdater.MediaID, err)
-
+	if err := s.c.PostJSON(ctx, path, nil, materialUpdater, &resp); err != nil {
+		return nil, fmt.Errorf("update material news: %s: %w", materialUpdater.MediaID, err)
 	}
 	return &resp, nil
 }
 
-func readResponse(resp *http.Response) ([]byte, error) {
-	if resp.StatusCode != 200 {
-		return nil, errors.New(resp.Status)
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	resp.Body.Close()
-	return b, nil
+// UpdateMaterial 更新永久图文素材
+func UpdateMaterial(host, accessToken, path string, materialUpdater *MaterialUpdater) (*Response, error) {
+	return newService(host, accessToken).UpdateMaterial(path, materialUpdater)
+}
+
+// UpdateMaterialContext 更新永久图文素材，ctx取消时中止请求
+func UpdateMaterialContext(ctx context.Context, host, accessToken, path string, materialUpdater *MaterialUpdater) (*Response, error) {
+	return newService(host, accessToken).UpdateMaterialContext(ctx, path, materialUpdater)
 }
 
 // MaterialCounter 素材计数器
@@ -71,23 +55,29 @@ type MaterialCounter struct {
 const WxGetMaterialCount = "cgi-bin/material/get_materialcount"
 
 // GetMaterialCount 获取永久素材数量
-func GetMaterialCount(host, accessToken string) (*MaterialCounter, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, WxGetMaterialCount, accessToken)
-	res, err := http.Get(URL)
-	if err != nil {
-		return nil, err
-	}
-	b, err := readResponse(res)
-	if err != nil {
-		return nil, err
-	}
+func (s *Service) GetMaterialCount() (*MaterialCounter, error) {
+	return s.GetMaterialCountContext(context.Background())
+}
+
+// GetMaterialCountContext 获取永久素材数量，ctx取消时中止请求
+func (s *Service) GetMaterialCountContext(ctx context.Context) (*MaterialCounter, error) {
 	var resp MaterialCounter
-	if err = json.Unmarshal(b, &resp); err != nil {
-		return nil, errors.New("get material count failed")
+	if err := s.c.Get(ctx, WxGetMaterialCount, nil, &resp); err != nil {
+		return nil, fmt.Errorf("get material count failed: %w", err)
 	}
 	return &resp, nil
 }
 
+// GetMaterialCount 获取永久素材数量
+func GetMaterialCount(host, accessToken string) (*MaterialCounter, error) {
+	return newService(host, accessToken).GetMaterialCount()
+}
+
+// GetMaterialCountContext 获取永久素材数量，ctx取消时中止请求
+func GetMaterialCountContext(ctx context.Context, host, accessToken string) (*MaterialCounter, error) {
+	return newService(host, accessToken).GetMaterialCountContext(ctx)
+}
+
 // MaterialListRequest 获取永久素材的列表
 type MaterialListRequest struct {
 	// Type 素材的类型，图片（image）、视频（video）、语音 （voice）、图文（news)
@@ -149,21 +139,25 @@ type NewsItem struct {
 const WxMaterailGetList = "cgi-bin/material/batchget_material"
 
 // GetMaterialList 获取永久素材的列表
-func GetMaterialList(host, accessToken string, req *MaterialListRequest) (*MaterialList, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, WxMaterailGetList, accessToken)
-	b, err := json.Marshal(req)
-	if err != nil {
-		return nil, err
-	}
-	res, err := http.Post(URL, "application/json; charset=utf-8", bytes.NewReader(b))
-	b, err = readResponse(res)
-	if err != nil {
-		return nil, err
-	}
-	fmt.Printf("%s\n", b)
+func (s *Service) GetMaterialList(req *MaterialListRequest) (*MaterialList, error) {
+	return s.GetMaterialListContext(context.Background(), req)
+}
+
+// GetMaterialListContext 获取永久素材的列表，ctx取消时中止请求
+func (s *Service) GetMaterialListContext(ctx context.Context, req *MaterialListRequest) (*MaterialList, error) {
 	var resp MaterialList
-	if err = json.Unmarshal(b, &resp); err != nil {
+	if err := s.c.QueryJSON(ctx, WxMaterailGetList, nil, req, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
+
+// GetMaterialList 获取永久素材的列表
+func GetMaterialList(host, accessToken string, req *MaterialListRequest) (*MaterialList, error) {
+	return newService(host, accessToken).GetMaterialList(req)
+}
+
+// GetMaterialListContext 获取永久素材的列表，ctx取消时中止请求
+func GetMaterialListContext(ctx context.Context, host, accessToken string, req *MaterialListRequest) (*MaterialList, error) {
+	return newService(host, accessToken).GetMaterialListContext(ctx, req)
+}
@@ -0,0 +1,18 @@
+package media
+
+import "qingtao/weixin/mp/core"
+
+// Service 素材管理和评论接口，通过*core.Client取得access_token和发送请求
+type Service struct {
+	c *core.Client
+}
+
+// NewService 使用c创建素材管理接口
+func NewService(c *core.Client) *Service {
+	return &Service{c}
+}
+
+// newService 兼容直接传入host和accessToken的函数
+func newService(host, accessToken string) *Service {
+	return NewService(core.NewClient(host, core.StaticToken(accessToken)))
+}
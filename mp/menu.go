@@ -1,11 +1,10 @@
 package mp
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+
+	"qingtao/weixin/mp/core"
 )
 
 // menu paths
@@ -146,98 +145,147 @@ type MenuResponse struct {
 	MenuID string `json:"menuid,omitempty"`
 }
 
-// post 提交自定义菜单操作
-func (wx *WeiXin) post(action string, menu interface{}) (*MenuResponse, error) {
-	uri := fmt.Sprintf("https://%s/%s/%s?access_token=%s",
-		wx.Host, WxMenuPath, action, wx.accessToken)
-	b, err := json.Marshal(menu)
-	if err != nil {
-		return nil, fmt.Errorf("AppID %s json marshal: %s", wx.AppID, err)
-	}
-	res, err := http.Post(uri, "application/json; charset=utf-8",
-		bytes.NewReader(b))
-	if err != nil {
-		return nil, fmt.Errorf("AppID %s post create menu: %s",
-			wx.AppID, err)
-	}
+// MenuService 自定义菜单接口
+type MenuService struct {
+	c *core.Client
+}
 
-	b, err = ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("appid %s menu response read body: %s", wx.AppID, err)
-	}
-	defer res.Body.Close()
+// NewMenuService 使用c创建自定义菜单接口
+func NewMenuService(c *core.Client) *MenuService {
+	return &MenuService{c}
+}
+
+// post 提交自定义菜单操作
+func (s *MenuService) post(ctx context.Context, action string, menu interface{}) (*MenuResponse, error) {
 	var wxinfo MenuResponse
-	if err = json.Unmarshal(b, &wxinfo); err != nil {
-		return nil, fmt.Errorf("appid %s json unmarshal menu: %s", wx.AppID, err)
+	if err := s.c.PostJSON(ctx, WxMenuPath+"/"+action, nil, menu, &wxinfo); err != nil {
+		return nil, fmt.Errorf("menu %s: %w", action, err)
 	}
 	return &wxinfo, nil
 }
 
-// CreateMenu 创建自定义菜单
-func (wx *WeiXin) CreateMenu(menu *Menu) (*MenuResponse, error) {
-	return wx.post(WxMenuCreate, menu)
+// Create 创建自定义菜单
+func (s *MenuService) Create(menu *Menu) (*MenuResponse, error) {
+	return s.CreateContext(context.Background(), menu)
 }
 
-// GetMenu 查询自定义菜单
-func (wx *WeiXin) GetMenu(accessToken string) (*MenuOfConditional, error) {
-	uri := fmt.Sprintf("https://%s/%s/%s?access_token=%s",
-		wx.Host, WxMenuPath, WxMenuGet, wx.accessToken)
-	res, err := http.Get(uri)
-	if err != nil {
-		return nil, fmt.Errorf("appid %s get menu: %s", wx.AppID, err)
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("appid %s menu response read body: %s", wx.AppID, err)
-	}
-	defer res.Body.Close()
+// CreateContext 创建自定义菜单，ctx取消时中止请求
+func (s *MenuService) CreateContext(ctx context.Context, menu *Menu) (*MenuResponse, error) {
+	return s.post(ctx, WxMenuCreate, menu)
+}
 
+// Get 查询自定义菜单
+func (s *MenuService) Get() (*MenuOfConditional, error) {
+	return s.GetContext(context.Background())
+}
+
+// GetContext 查询自定义菜单，ctx取消时中止请求
+func (s *MenuService) GetContext(ctx context.Context) (*MenuOfConditional, error) {
 	var menu MenuOfConditional
-	if err = json.Unmarshal(b, &menu); err != nil {
-		return nil, fmt.Errorf("appid %s get menu unmarshal response: %s", wx.AppID, err)
+	if err := s.c.Get(ctx, WxMenuPath+"/"+WxMenuGet, nil, &menu); err != nil {
+		return nil, fmt.Errorf("get menu: %w", err)
 	}
-
 	return &menu, nil
 }
 
-// deleteMenu 提交删除自定义菜单请求
-func (wx *WeiXin) deleteMenu(menuid string) (*MenuResponse, error) {
-	action := WxMenuDelete
-	if menuid != "" {
-		action = WxMenuDelConditional
-	}
-	uri := fmt.Sprintf("https://%s/%s/%s?access_token=%s",
-		wx.Host, WxMenuPath, action, wx.accessToken)
-
-	var res = new(http.Response)
-	var err error
-	if menuid != "" {
-		s := `{"menuid":"` + menuid + `"}`
-		res, err = http.Post(uri, "application/json; charset=utf-8",
-			bytes.NewReader([]byte(s)))
-	} else {
-		res, err = http.Get(uri)
+// Delete 删除自定义菜单
+func (s *MenuService) Delete() (*MenuResponse, error) {
+	return s.DeleteContext(context.Background())
+}
+
+// DeleteContext 删除自定义菜单，ctx取消时中止请求
+func (s *MenuService) DeleteContext(ctx context.Context) (*MenuResponse, error) {
+	var wxinfo MenuResponse
+	if err := s.c.Get(ctx, WxMenuPath+"/"+WxMenuDelete, nil, &wxinfo); err != nil {
+		return nil, fmt.Errorf("delete menu: %w", err)
 	}
-	if err != nil {
-		return nil, fmt.Errorf("appid %s the request of delete menu %s",
-			wx.AppID, err)
+	return &wxinfo, nil
+}
+
+// CreateConditional 创建个性化菜单
+func (s *MenuService) CreateConditional(menu *ConditionalMenu) (*MenuResponse, error) {
+	return s.CreateConditionalContext(context.Background(), menu)
+}
+
+// CreateConditionalContext 创建个性化菜单，ctx取消时中止请求
+func (s *MenuService) CreateConditionalContext(ctx context.Context, menu *ConditionalMenu) (*MenuResponse, error) {
+	return s.post(ctx, WxMenuAddConditional, menu)
+}
+
+// DeleteConditional 删除个性化菜单
+func (s *MenuService) DeleteConditional(menuid string) (*MenuResponse, error) {
+	return s.DeleteConditionalContext(context.Background(), menuid)
+}
+
+// DeleteConditionalContext 删除个性化菜单，ctx取消时中止请求
+func (s *MenuService) DeleteConditionalContext(ctx context.Context, menuid string) (*MenuResponse, error) {
+	return s.post(ctx, WxMenuDelConditional, struct {
+		MenuID string `json:"menuid"`
+	}{menuid})
+}
+
+// TryMatch 测试个性化菜单匹配结果，userid可以是粉丝的OpenID，也可以是粉丝的微信号
+func (s *MenuService) TryMatch(userid string) (*Menu, error) {
+	return s.TryMatchContext(context.Background(), userid)
+}
+
+// TryMatchContext 测试个性化菜单匹配结果，userid可以是粉丝的OpenID，也可以是粉丝的微信号，ctx取消时中止请求
+func (s *MenuService) TryMatchContext(ctx context.Context, userid string) (*Menu, error) {
+	req := struct {
+		UserID string `json:"user_id"`
+	}{userid}
+	var wxinfo Menu
+	if err := s.c.QueryJSON(ctx, WxMenuPath+"/"+WxMenuTryMatch, nil, req, &wxinfo); err != nil {
+		return nil, fmt.Errorf("trymatch custom menu: %w", err)
 	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("appid %s del menu response read body: %s", wx.AppID, err)
+	return &wxinfo, nil
+}
+
+// GetCurrentSelfMenu 获取自定义菜单配置接口
+func (s *MenuService) GetCurrentSelfMenu() (*CurrentSelfMenu, error) {
+	return s.GetCurrentSelfMenuContext(context.Background())
+}
+
+// GetCurrentSelfMenuContext 获取自定义菜单配置接口，ctx取消时中止请求
+func (s *MenuService) GetCurrentSelfMenuContext(ctx context.Context) (*CurrentSelfMenu, error) {
+	var menu CurrentSelfMenu
+	if err := s.c.Get(ctx, WxGetCurrentSelfMenu, nil, &menu); err != nil {
+		return nil, fmt.Errorf("get_current_selfmenu_info: %w", err)
 	}
-	defer res.Body.Close()
+	return &menu, nil
+}
 
-	var wxinfo MenuResponse
-	if err := json.Unmarshal(b, &wxinfo); err != nil {
-		return nil, fmt.Errorf("appid %s unmarshal response when delete menu: %s", wx.AppID, err)
+// CreateMenu 创建自定义菜单
+func (wx *WeiXin) CreateMenu(menu *Menu) (*MenuResponse, error) {
+	return wx.Client().Menu.Create(menu)
+}
+
+// CreateMenuContext 创建自定义菜单，ctx取消时中止请求
+func (wx *WeiXin) CreateMenuContext(ctx context.Context, menu *Menu) (*MenuResponse, error) {
+	return wx.Client().Menu.CreateContext(ctx, menu)
+}
+
+// GetMenu 查询自定义菜单，accessToken为空时使用wx.Tokens()管理的access_token
+func (wx *WeiXin) GetMenu(accessToken string) (*MenuOfConditional, error) {
+	return wx.GetMenuContext(context.Background(), accessToken)
+}
+
+// GetMenuContext 查询自定义菜单，ctx取消时中止请求
+func (wx *WeiXin) GetMenuContext(ctx context.Context, accessToken string) (*MenuOfConditional, error) {
+	if accessToken != "" {
+		return NewClient(wx.Host, core.StaticToken(accessToken)).Menu.GetContext(ctx)
 	}
-	return &wxinfo, nil
+	return wx.Client().Menu.GetContext(ctx)
 }
 
 // DeleteMenu 删除自定义菜单
 func (wx *WeiXin) DeleteMenu() (*MenuResponse, error) {
-	return wx.deleteMenu("")
+	return wx.Client().Menu.Delete()
+}
+
+// DeleteMenuContext 删除自定义菜单，ctx取消时中止请求
+func (wx *WeiXin) DeleteMenuContext(ctx context.Context) (*MenuResponse, error) {
+	return wx.Client().Menu.DeleteContext(ctx)
 }
 
 // MatchRule 菜单匹配规则
@@ -267,36 +315,32 @@ type MatchRule struct {
 
 // CreateConditionalMenu 创建个性化菜单
 func (wx *WeiXin) CreateConditionalMenu(menu *ConditionalMenu) (*MenuResponse, error) {
-	return wx.post(WxMenuAddConditional, menu)
+	return wx.Client().Menu.CreateConditional(menu)
+}
+
+// CreateConditionalMenuContext 创建个性化菜单，ctx取消时中止请求
+func (wx *WeiXin) CreateConditionalMenuContext(ctx context.Context, menu *ConditionalMenu) (*MenuResponse, error) {
+	return wx.Client().Menu.CreateConditionalContext(ctx, menu)
 }
 
 // DeleteConditionalMenu 删除个性化菜单
 func (wx *WeiXin) DeleteConditionalMenu(menuid string) (*MenuResponse, error) {
-	return wx.deleteMenu(menuid)
+	return wx.Client().Menu.DeleteConditional(menuid)
+}
+
+// DeleteConditionalMenuContext 删除个性化菜单，ctx取消时中止请求
+func (wx *WeiXin) DeleteConditionalMenuContext(ctx context.Context, menuid string) (*MenuResponse, error) {
+	return wx.Client().Menu.DeleteConditionalContext(ctx, menuid)
 }
 
 // TryConditionalMenu 测试个性化菜单匹配结果
 func (wx *WeiXin) TryConditionalMenu(userid string) (*Menu, error) {
-	uri := fmt.Sprintf("https://%s/%s/%s?access_token=%s", wx.Host,
-		WxMenuPath, WxMenuTryMatch, wx.accessToken)
-	s := `{"user_id":"` + userid + `"}`
-	res, err := http.Post(uri, "application/json; charset=utf-8",
-		bytes.NewReader([]byte(s)))
-	if err != nil {
-		return nil, fmt.Errorf("appid %s trymatch custom menu: %s",
-			wx.AppID, err)
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("appid %s trymatch read body: %s", wx.AppID, err)
-	}
-	defer res.Body.Close()
+	return wx.Client().Menu.TryMatch(userid)
+}
 
-	var wxinfo Menu
-	if err = json.Unmarshal(b, &wxinfo); err != nil {
-		return nil, fmt.Errorf("appid %s parse content when trymatch custom menu: %s", wx.AppID, err)
-	}
-	return &wxinfo, nil
+// TryConditionalMenuContext 测试个性化菜单匹配结果，ctx取消时中止请求
+func (wx *WeiXin) TryConditionalMenuContext(ctx context.Context, userid string) (*Menu, error) {
+	return wx.Client().Menu.TryMatchContext(ctx, userid)
 }
 
 // CurrentSelfMenu 获取自定义菜单配置接口
@@ -313,21 +357,10 @@ type CurrentSelfMenu struct {
 
 // GetCurrentSelfMenu 获取自定义菜单配置接口
 func (wx *WeiXin) GetCurrentSelfMenu() (*CurrentSelfMenu, error) {
-	uri := fmt.Sprintf("https://%s/%s?access_token=%s", wx.Host,
-		WxGetCurrentSelfMenu, wx.accessToken)
-	res, err := http.Get(uri)
-	if err != nil {
-		return nil, fmt.Errorf("appid %s get_current_selfmenu_info: %s",
-			wx.AppID, err)
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("appid %s getcurrentmenu read body: %s", wx.AppID, err)
-	}
-	defer res.Body.Close()
-	var menu CurrentSelfMenu
-	if err = json.Unmarshal(b, &menu); err != nil {
-		return nil, fmt.Errorf("appid %s get_current_selfmenu_info unmarshal response: %s", wx.AppID, err)
-	}
-	return &menu, nil
+	return wx.Client().Menu.GetCurrentSelfMenu()
+}
+
+// GetCurrentSelfMenuContext 获取自定义菜单配置接口，ctx取消时中止请求
+func (wx *WeiXin) GetCurrentSelfMenuContext(ctx context.Context) (*CurrentSelfMenu, error) {
+	return wx.Client().Menu.GetCurrentSelfMenuContext(ctx)
 }
@@ -24,6 +24,8 @@ type Message struct {
 	PicURL CDATA `xml:"PicUrl,omitempty" json:"PicUrl,omitempty"`
 	// MediaId 图片消息媒体id，可以调用多媒体文件下载接口拉取数据
 	MediaID CDATA `xml:"MediaId,omitempty" json:"MediaId,omitempty"`
+	// MediaID16K 语音消息16K采样率的媒体id
+	MediaID16K CDATA `xml:"MediaId16K,omitempty" json:"MediaId16K,omitempty"`
 	// Foramt 语音格式，如amr，speex等
 	Format CDATA `xml:",omitempty"`
 	// Recognition 语音识别结果，UTF8编码
@@ -59,6 +61,20 @@ type Message struct {
 	Precision float64 `xml:",omitempty"`
 	// Scene 场景值，固定为1
 	Scene string `xml:",omitempty"`
+	// EventMsgID 模版消息和群发结果事件的消息id，注意与普通消息的MsgId大小写不同
+	EventMsgID int64 `xml:"MsgID,omitempty" json:"MsgID,omitempty"`
+	// Status 模版消息和群发结果事件的发送状态
+	Status CDATA `xml:",omitempty"`
+	// TotalCount 群发的粉丝数
+	TotalCount int `xml:",omitempty"`
+	// FilterCount 过滤后准备发送的粉丝数
+	FilterCount int `xml:",omitempty"`
+	// SentCount 发送成功的粉丝数
+	SentCount int `xml:",omitempty"`
+	// ErrorCount 发送失败的粉丝数
+	ErrorCount int `xml:",omitempty"`
+	// SessionFrom 进入客服会话的来源，Event为user_enter_tempsession
+	SessionFrom CDATA `xml:",omitempty"`
 
 	// 自定义菜单事件
 
@@ -80,6 +96,9 @@ type Message struct {
 	SendPicsInfo *SendPicsInfo `xml:",omitempty"`
 	// 	SendLocationInfo 发送的位置信息
 	SendLocationInfo *SendLocationInfo `xml:",omitempty"`
+
+	// Raw 接收到的消息明文，由Server设置，用于ParseMessage
+	Raw []byte `xml:"-" json:"-"`
 }
 
 // CDATA xml <![CDATA[...]]]格式
@@ -0,0 +1,268 @@
+package oauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"qingtao/weixin/mp/core"
+)
+
+const (
+	// WxAuthorize 用户同意授权的页面，获取code
+	WxAuthorize = "https://open.weixin.qq.com/connect/oauth2/authorize"
+	// WxAccessToken 使用code换取网页授权access_token
+	WxAccessToken = "sns/oauth2/access_token"
+	// WxRefreshToken 刷新网页授权access_token
+	WxRefreshToken = "sns/oauth2/refresh_token"
+	// WxUserInfo 拉取用户信息，需要scope为snsapi_userinfo
+	WxUserInfo = "sns/userinfo"
+	// WxAuth 检验网页授权access_token是否有效
+	WxAuth = "sns/auth"
+)
+
+// 授权作用域scope
+const (
+	// ScopeBase 静默授权，只能获取openid
+	ScopeBase = "snsapi_base"
+	// ScopeUserInfo 需要用户同意，可以获取昵称、头像等基本信息
+	ScopeUserInfo = "snsapi_userinfo"
+)
+
+// 用户信息的语言
+const (
+	LangZhCN = "zh_CN"
+	LangZhTW = "zh_TW"
+	LangEn   = "en"
+)
+
+const (
+	// DefaultStateTTL state的默认有效期
+	DefaultStateTTL = 10 * time.Minute
+	// stateTimeLen state中unix时间的长度
+	stateTimeLen = 10
+	// stateNonceLen state中随机字符串的长度
+	stateNonceLen = 16
+	// stateSigLen state中签名的长度
+	stateSigLen = 32
+	// StateLen NewState生成的state的长度，微信限制state最多128字节，只能包含字母和数字
+	StateLen = stateTimeLen + stateNonceLen + stateSigLen
+)
+
+// ErrInvalidState state格式错误、签名错误或者已经过期
+var ErrInvalidState = errors.New("oauth: invalid state")
+
+// stateKey 返回签名state的密钥
+func (s *Service) stateKey() []byte {
+	if len(s.StateKey) > 0 {
+		return s.StateKey
+	}
+	return []byte(s.secret)
+}
+
+// sign 返回state前缀的签名
+func (s *Service) sign(prefix string) string {
+	mac := hmac.New(sha256.New, s.stateKey())
+	mac.Write([]byte(s.appid))
+	mac.Write([]byte(prefix))
+	return hex.EncodeToString(mac.Sum(nil))[:stateSigLen]
+}
+
+// stateTTL 返回state的有效期
+func (s *Service) stateTTL() time.Duration {
+	if s.StateTTL > 0 {
+		return s.StateTTL
+	}
+	return DefaultStateTTL
+}
+
+// NewState 生成带有时间和签名的state，长度为StateLen，只包含字母和数字。
+// 签名只能证明state由这个服务生成，防止CSRF还要把state绑定到发起授权的浏览器，
+// 使用Redirect或者SetStateCookie保存到cookie，回调时由Handler检查
+func (s *Service) NewState() string {
+	prefix := strconv.FormatInt(time.Now().Unix(), 10) + core.RandomString(stateNonceLen)
+	return prefix + s.sign(prefix)
+}
+
+// VerifyState 检查NewState生成的state的签名和有效期，失败时返回ErrInvalidState，
+// 不检查state是否属于当前浏览器
+func (s *Service) VerifyState(state string) error {
+	if len(state) != StateLen {
+		return ErrInvalidState
+	}
+	prefix := state[:stateTimeLen+stateNonceLen]
+	if !hmac.Equal([]byte(s.sign(prefix)), []byte(state[len(prefix):])) {
+		return ErrInvalidState
+	}
+	unix, err := strconv.ParseInt(state[:stateTimeLen], 10, 64)
+	if err != nil {
+		return ErrInvalidState
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > s.stateTTL() || age < -time.Minute {
+		return ErrInvalidState
+	}
+	return nil
+}
+
+// AuthorizeURL 返回用户同意授权的页面地址，redirectURI是授权后跳转的地址，
+// 域名必须与公众号设置的网页授权域名相同，scope为ScopeBase或者ScopeUserInfo，
+// state为空时使用NewState生成；使用Handler处理回调时改用Redirect，或者用SetStateCookie保存state
+func (s *Service) AuthorizeURL(redirectURI, scope, state string) string {
+	if state == "" {
+		state = s.NewState()
+	}
+	// 微信要求参数按照appid、redirect_uri、response_type、scope、state的顺序，Encode按照key排序正好符合
+	query := url.Values{
+		"appid":         {s.appid},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {scope},
+		"state":         {state},
+	}
+	return WxAuthorize + "?" + query.Encode() + "#wechat_redirect"
+}
+
+// Token 网页授权access_token，与公众号的access_token不同
+type Token struct {
+	// AccessToken 网页授权access_token，有效期2小时
+	AccessToken string `json:"access_token"`
+	// ExpiresIn access_token的有效时间，单位秒
+	ExpiresIn int `json:"expires_in"`
+	// RefreshToken 用于刷新access_token，有效期30天
+	RefreshToken string `json:"refresh_token"`
+	// OpenID 用户的openid
+	OpenID string `json:"openid"`
+	// Scope 用户授权的作用域，多个使用逗号分隔
+	Scope string `json:"scope"`
+	// UnionID 公众号绑定到开放平台后才有
+	UnionID string `json:"unionid,omitempty"`
+	// IsSnapshotUser 为1时是快照页模式的虚拟账号，openid不是用户的真实openid
+	IsSnapshotUser int `json:"is_snapshotuser,omitempty"`
+	// Expires access_token的过期时间，根据ExpiresIn计算
+	Expires time.Time `json:"expires"`
+}
+
+// Expired 返回access_token是否已经过期，提前一分钟认为过期
+func (t *Token) Expired() bool {
+	return time.Now().Add(time.Minute).After(t.Expires)
+}
+
+// token 调用req获取网页授权access_token
+func (s *Service) token(ctx context.Context, req *core.Request) (*Token, error) {
+	var t Token
+	req.NoToken = true
+	if err := s.c.Call(ctx, req, &t); err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, fmt.Errorf("%s: access_token is empty", req.Path)
+	}
+	t.Expires = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	return &t, nil
+}
+
+// Exchange 使用回调地址中的code换取网页授权access_token，code只能使用一次，5分钟未使用自动过期，
+// 失败时不自动重试
+func (s *Service) Exchange(code string) (*Token, error) {
+	return s.ExchangeContext(context.Background(), code)
+}
+
+// ExchangeContext 使用回调地址中的code换取网页授权access_token，code只能使用一次，5分钟未使用自动过期，
+// 失败时不自动重试，ctx取消时中止请求
+func (s *Service) ExchangeContext(ctx context.Context, code string) (*Token, error) {
+	if code == "" {
+		return nil, errors.New("oauth exchange code: code is empty")
+	}
+	query := url.Values{
+		"appid":      {s.appid},
+		"secret":     {s.secret},
+		"code":       {code},
+		"grant_type": {"authorization_code"},
+	}
+	// code只能使用一次，第一次请求到达微信服务器后重试会失败，所以不自动重试
+	t, err := s.token(ctx, &core.Request{Path: WxAccessToken, Query: query, NoRetry: true})
+	if err != nil {
+		return nil, fmt.Errorf("oauth exchange code %w", err)
+	}
+	return t, nil
+}
+
+// Refresh 使用refreshToken刷新网页授权access_token
+func (s *Service) Refresh(refreshToken string) (*Token, error) {
+	return s.RefreshContext(context.Background(), refreshToken)
+}
+
+// RefreshContext 使用refreshToken刷新网页授权access_token，ctx取消时中止请求
+func (s *Service) RefreshContext(ctx context.Context, refreshToken string) (*Token, error) {
+	query := url.Values{
+		"appid":         {s.appid},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	t, err := s.token(ctx, &core.Request{Path: WxRefreshToken, Query: query})
+	if err != nil {
+		return nil, fmt.Errorf("oauth refresh token %w", err)
+	}
+	return t, nil
+}
+
+// UserInfo 网页授权获取的用户信息
+type UserInfo struct {
+	OpenID   string `json:"openid"`
+	Nickname string `json:"nickname"`
+	// Sex 1为男性，2为女性，0为未知
+	Sex      int    `json:"sex"`
+	Province string `json:"province"`
+	City     string `json:"city"`
+	Country  string `json:"country"`
+	// HeadImgURL 头像地址，用户更换头像后失效
+	HeadImgURL string `json:"headimgurl"`
+	// Privilege 用户特权信息
+	Privilege []string `json:"privilege"`
+	UnionID   string   `json:"unionid,omitempty"`
+}
+
+// GetUserInfo 使用网页授权access_token获取用户信息，lang为空时使用LangZhCN
+func (s *Service) GetUserInfo(accessToken, openid, lang string) (*UserInfo, error) {
+	return s.GetUserInfoContext(context.Background(), accessToken, openid, lang)
+}
+
+// GetUserInfoContext 使用网页授权access_token获取用户信息，lang为空时使用LangZhCN，ctx取消时中止请求
+func (s *Service) GetUserInfoContext(ctx context.Context, accessToken, openid, lang string) (*UserInfo, error) {
+	if lang == "" {
+		lang = LangZhCN
+	}
+	query := url.Values{
+		"access_token": {accessToken},
+		"openid":       {openid},
+		"lang":         {lang},
+	}
+	var info UserInfo
+	if err := s.c.Call(ctx, &core.Request{Path: WxUserInfo, Query: query, NoToken: true}, &info); err != nil {
+		return nil, fmt.Errorf("oauth get userinfo %w", err)
+	}
+	return &info, nil
+}
+
+// Validate 检验网页授权access_token是否有效，无效时返回*core.APIError
+func (s *Service) Validate(accessToken, openid string) error {
+	return s.ValidateContext(context.Background(), accessToken, openid)
+}
+
+// ValidateContext 检验网页授权access_token是否有效，无效时返回*core.APIError，ctx取消时中止请求
+func (s *Service) ValidateContext(ctx context.Context, accessToken, openid string) error {
+	query := url.Values{
+		"access_token": {accessToken},
+		"openid":       {openid},
+	}
+	if err := s.c.Call(ctx, &core.Request{Path: WxAuth, Query: query, NoToken: true}, nil); err != nil {
+		return fmt.Errorf("oauth validate access_token %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"qingtao/weixin/mp/core"
+)
+
+func TestState(t *testing.T) {
+	s := NewService(nil, "wxappid", "secret")
+	state := s.NewState()
+	if len(state) != StateLen || len(state) > 128 {
+		t.Fatalf("state length %d", len(state))
+	}
+	for _, c := range state {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			t.Fatalf("state %q contains %q", state, c)
+		}
+	}
+	if err := s.VerifyState(state); err != nil {
+		t.Fatal(err)
+	}
+	tampered := state[:StateLen-1] + "x"
+	if tampered == state {
+		tampered = state[:StateLen-1] + "y"
+	}
+	if err := s.VerifyState(tampered); err != ErrInvalidState {
+		t.Fatalf("tampered state %v", err)
+	}
+	if err := NewService(nil, "wxappid", "other").VerifyState(state); err != ErrInvalidState {
+		t.Fatalf("state of other secret %v", err)
+	}
+	prefix := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10) + core.RandomString(stateNonceLen)
+	if err := s.VerifyState(prefix + s.sign(prefix)); err != ErrInvalidState {
+		t.Fatalf("expired state %v", err)
+	}
+
+	u, err := url.Parse(s.AuthorizeURL("https://example.com/cb?next=/a", ScopeUserInfo, "abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Fragment != "wechat_redirect" || q.Get("redirect_uri") != "https://example.com/cb?next=/a" || q.Get("scope") != ScopeUserInfo || q.Get("state") != "abc" || q.Get("appid") != "wxappid" {
+		t.Fatalf("authorize url %s", u)
+	}
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("access_token") == "token" {
+			t.Errorf("%s uses the access_token of mp", r.URL.Path)
+		}
+		switch r.URL.Path {
+		case "/" + WxAccessToken:
+			if q.Get("code") != "code" || q.Get("secret") != "secret" {
+				fmt.Fprint(w, `{"errcode":40029,"errmsg":"invalid code"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"oauth_token","expires_in":7200,"refresh_token":"refresh","openid":"openid","scope":"snsapi_userinfo","unionid":"unionid"}`)
+		case "/" + WxRefreshToken:
+			fmt.Fprint(w, `{"access_token":"oauth_token2","expires_in":7200,"refresh_token":"refresh","openid":"openid","scope":"snsapi_userinfo"}`)
+		case "/" + WxUserInfo:
+			if q.Get("access_token") != "oauth_token" && q.Get("access_token") != "oauth_token2" {
+				fmt.Fprint(w, `{"errcode":40001,"errmsg":"invalid credential"}`)
+				return
+			}
+			fmt.Fprint(w, `{"openid":"openid","nickname":"NICKNAME","sex":1,"headimgurl":"https://thirdwx.qlogo.cn/46","privilege":["PRIVILEGE1"]}`)
+		case "/" + WxAuth:
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+		}
+	}))
+}
+
+func TestHandler(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")}, "wxappid", "secret")
+
+	var got *Session
+	h := &Handler{
+		Service:       s,
+		FetchUserInfo: true,
+		OnSession: func(w http.ResponseWriter, r *http.Request, sess *Session) {
+			got = sess
+			http.Redirect(w, r, r.URL.Query().Get("next"), http.StatusFound)
+		},
+	}
+	// Redirect把state保存到cookie，回调时带上cookie
+	w := httptest.NewRecorder()
+	s.Redirect(w, httptest.NewRequest("GET", "/login", nil), "https://example.com/cb", ScopeUserInfo)
+	u, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := u.Query().Get("state")
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusFound || len(cookies) != 1 || cookies[0].Name != StateCookie || cookies[0].Value != state {
+		t.Fatalf("redirect status %d cookies %v", w.Code, cookies)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, callback("next=/a&code=code&state="+state, state))
+	if w.Code != http.StatusFound || got == nil || got.OpenID() != "openid" {
+		t.Fatalf("status %d session %v", w.Code, got)
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].Name != StateCookie || c[0].MaxAge >= 0 {
+		t.Fatalf("state cookie not deleted %v", c)
+	}
+	info, err := got.UserInfo(context.Background(), "")
+	if err != nil || info.Nickname != "NICKNAME" {
+		t.Fatalf("userinfo %+v %v", info, err)
+	}
+
+	// access_token过期时刷新，并保留unionid
+	tok := got.Token()
+	tok.Expires = time.Now().Add(-time.Second)
+	sess := s.Restore(&tok)
+	if at, err := sess.AccessToken(context.Background()); err != nil || at != "oauth_token2" || sess.Token().UnionID != "unionid" {
+		t.Fatalf("refresh %s %v %+v", at, err, sess.Token())
+	}
+	if err := s.Validate("oauth_token2", "openid"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := s.NewState()
+	tests := []struct {
+		query  string
+		cookie string
+		code   int
+	}{
+		{"state=" + state, state, http.StatusForbidden},
+		{"code=code&state=bad", "bad", http.StatusBadRequest},
+		{"code=used&state=" + state, state, http.StatusBadRequest},
+		// 有效的state不是这个浏览器发起的授权
+		{"code=code&state=" + state, "", http.StatusBadRequest},
+		{"code=code&state=" + state, other, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, callback(tt.query, tt.cookie))
+		if w.Code != tt.code {
+			t.Errorf("%s cookie %q: status %d, want %d", tt.query, tt.cookie, w.Code, tt.code)
+		}
+	}
+}
+
+// callback 返回带有query和state cookie的回调请求，state为空时没有cookie
+func callback(query, state string) *http.Request {
+	r := httptest.NewRequest("GET", "/cb?"+query, nil)
+	if state != "" {
+		r.AddCookie(&http.Cookie{Name: StateCookie, Value: state})
+	}
+	return r
+}
+
+func TestExchangeNoRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, `{"errcode":-1,"errmsg":"system error"}`)
+	}))
+	defer srv.Close()
+	c := core.NewClient("", core.StaticToken("token"))
+	c.BaseURL = srv.URL
+	c.Retry = &core.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond}
+	s := NewService(c, "wxappid", "secret")
+	if _, err := s.Exchange("code"); err == nil {
+		t.Fatal("exchange succeeded")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("code sent %d times", n)
+	}
+}
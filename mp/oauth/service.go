@@ -0,0 +1,25 @@
+// Package oauth 公众号网页授权接口，用于菜单和链接打开的网页获取用户的openid和基本信息
+package oauth
+
+import (
+	"time"
+
+	"qingtao/weixin/mp/core"
+)
+
+// Service 网页授权接口，使用公众号的AppID和AppSecret换取网页授权access_token，
+// 不使用公众号的access_token
+type Service struct {
+	c      *core.Client
+	appid  string
+	secret string
+	// StateKey 签名state使用的密钥，为空时使用AppSecret
+	StateKey []byte
+	// StateTTL state的有效期，为0时使用DefaultStateTTL
+	StateTTL time.Duration
+}
+
+// NewService 使用c和公众号的appid、secret创建网页授权接口
+func NewService(c *core.Client, appid, secret string) *Service {
+	return &Service{c: c, appid: appid, secret: secret}
+}
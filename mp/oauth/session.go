@@ -0,0 +1,209 @@
+package oauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateCookie 保存state的cookie，把state绑定到发起授权的浏览器
+const StateCookie = "wx_oauth_state"
+
+// ErrAccessDenied 用户拒绝授权，回调地址中没有code
+var ErrAccessDenied = errors.New("oauth: access denied")
+
+// Session 一个用户的网页授权，access_token过期时使用refresh_token自动刷新，
+// 可以被多个goroutine同时使用，需要保存时序列化Token()的返回值，使用Restore恢复
+type Session struct {
+	s    *Service
+	mu   sync.Mutex
+	t    Token
+	info *UserInfo
+}
+
+// Restore 使用保存的t创建*Session
+func (s *Service) Restore(t *Token) *Session {
+	return &Session{s: s, t: *t}
+}
+
+// OpenID 返回用户的openid
+func (sess *Session) OpenID() string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.t.OpenID
+}
+
+// Token 返回当前的网页授权access_token，用于保存会话
+func (sess *Session) Token() Token {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.t
+}
+
+// HasScope 用户授权的作用域中是否包含scope
+func (sess *Session) HasScope(scope string) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	for _, s := range strings.Split(sess.t.Scope, ",") {
+		if strings.TrimSpace(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessToken 返回有效的网页授权access_token，过期时先刷新
+func (sess *Session) AccessToken(ctx context.Context) (string, error) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.t.Expired() {
+		if err := sess.refreshLocked(ctx); err != nil {
+			return "", err
+		}
+	}
+	return sess.t.AccessToken, nil
+}
+
+// Refresh 立即使用refresh_token刷新access_token，refresh_token过期后需要用户重新授权
+func (sess *Session) Refresh(ctx context.Context) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.refreshLocked(ctx)
+}
+
+// refreshLocked 调用时必须持有sess.mu
+func (sess *Session) refreshLocked(ctx context.Context) error {
+	t, err := sess.s.RefreshContext(ctx, sess.t.RefreshToken)
+	if err != nil {
+		return err
+	}
+	// 刷新的响应不一定包含unionid
+	if t.UnionID == "" {
+		t.UnionID = sess.t.UnionID
+	}
+	sess.t = *t
+	return nil
+}
+
+// UserInfo 返回用户信息，第一次调用时获取，需要用户授权ScopeUserInfo
+func (sess *Session) UserInfo(ctx context.Context, lang string) (*UserInfo, error) {
+	sess.mu.Lock()
+	info := sess.info
+	sess.mu.Unlock()
+	if info != nil {
+		return info, nil
+	}
+	accessToken, err := sess.AccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info, err = sess.s.GetUserInfoContext(ctx, accessToken, sess.OpenID(), lang)
+	if err != nil {
+		return nil, err
+	}
+	sess.mu.Lock()
+	sess.info = info
+	sess.mu.Unlock()
+	return info, nil
+}
+
+// Redirect 使用NewState生成state并保存到cookie，然后跳转到用户同意授权的页面，
+// 回调时Handler检查回调地址中的state与cookie相同
+func (s *Service) Redirect(w http.ResponseWriter, r *http.Request, redirectURI, scope string) {
+	state := s.NewState()
+	s.SetStateCookie(w, r, state)
+	http.Redirect(w, r, s.AuthorizeURL(redirectURI, scope, state), http.StatusFound)
+}
+
+// SetStateCookie 把NewState生成的state保存到cookie，自行跳转到AuthorizeURL时在跳转前调用
+func (s *Service) SetStateCookie(w http.ResponseWriter, r *http.Request, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     StateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   int(s.stateTTL() / time.Second),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		// 从微信授权页面跳转回来是顶层的GET请求，Lax会带上cookie
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// checkState 检查state的签名和有效期，并且与发起授权的浏览器保存的cookie相同，失败时返回ErrInvalidState
+func (s *Service) checkState(r *http.Request, state string) error {
+	if err := s.VerifyState(state); err != nil {
+		return err
+	}
+	c, err := r.Cookie(StateCookie)
+	if err != nil || !hmac.Equal([]byte(c.Value), []byte(state)) {
+		return ErrInvalidState
+	}
+	return nil
+}
+
+// Handler 处理网页授权回调地址的http.Handler，检查state与Redirect保存的cookie相同，
+// 使用code换取access_token，然后调用OnSession，由OnSession保存会话并跳转到业务页面
+type Handler struct {
+	// Service 网页授权接口
+	Service *Service
+	// FetchUserInfo 为true并且用户授权了ScopeUserInfo时，调用OnSession前获取用户信息
+	FetchUserInfo bool
+	// Lang 获取用户信息使用的语言
+	Lang string
+	// SkipStateCheck 为true时不检查state和cookie，不使用Redirect或者SetStateCookie时设置，需要自行防止CSRF
+	SkipStateCheck bool
+	// OnSession 授权成功后调用，不能为nil
+	OnSession func(w http.ResponseWriter, r *http.Request, sess *Session)
+	// OnError 授权失败时调用，err为ErrAccessDenied、ErrInvalidState或者接口的错误，
+	// 为nil时用户拒绝返回403，其他错误返回400
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// ServeHTTP 实现http.Handler接口
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	code := query.Get("code")
+	if code == "" {
+		h.fail(w, r, ErrAccessDenied)
+		return
+	}
+	if !h.SkipStateCheck {
+		if err := h.Service.checkState(r, query.Get("state")); err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		// state只能使用一次
+		http.SetCookie(w, &http.Cookie{Name: StateCookie, Path: "/", MaxAge: -1})
+	}
+	ctx := r.Context()
+	t, err := h.Service.ExchangeContext(ctx, code)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	sess := h.Service.Restore(t)
+	if h.FetchUserInfo && sess.HasScope(ScopeUserInfo) {
+		if _, err := sess.UserInfo(ctx, h.Lang); err != nil {
+			h.fail(w, r, err)
+			return
+		}
+	}
+	h.OnSession(w, r, sess)
+}
+
+// fail 调用OnError或者返回错误状态
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if h.OnError != nil {
+		h.OnError(w, r, err)
+		return
+	}
+	if err == ErrAccessDenied {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, "oauth failed", http.StatusBadRequest)
+}
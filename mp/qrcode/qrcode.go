@@ -0,0 +1,214 @@
+package qrcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"qingtao/weixin/mp/core"
+)
+
+// WxQRCodeCreate 创建二维码ticket
+const WxQRCodeCreate = "cgi-bin/qrcode/create"
+
+// WxQRCodeShow 使用ticket换取二维码图片的地址，不需要access_token
+const WxQRCodeShow = "https://mp.weixin.qq.com/cgi-bin/showqrcode"
+
+// 二维码类型action_name
+const (
+	// ActionScene 临时的整型参数
+	ActionScene = "QR_SCENE"
+	// ActionStrScene 临时的字符串参数
+	ActionStrScene = "QR_STR_SCENE"
+	// ActionLimitScene 永久的整型参数
+	ActionLimitScene = "QR_LIMIT_SCENE"
+	// ActionLimitStrScene 永久的字符串参数
+	ActionLimitStrScene = "QR_LIMIT_STR_SCENE"
+)
+
+const (
+	// ScenePrefix 扫描带参数二维码关注时EventKey的前缀
+	ScenePrefix = "qrscene_"
+	// MaxExpire 临时二维码的最长有效期，30天
+	MaxExpire = 30 * 24 * time.Hour
+	// MaxLimitSceneID 永久二维码整型参数的最大值
+	MaxLimitSceneID = 100000
+	// MaxSceneStrLen 字符串参数的最大长度
+	MaxSceneStrLen = 64
+)
+
+// Scene 二维码的参数，ID不为0时是整型参数，否则是字符串参数Str
+type Scene struct {
+	ID  int64
+	Str string
+}
+
+// IntScene 创建整型参数
+func IntScene(id int64) Scene {
+	return Scene{ID: id}
+}
+
+// StrScene 创建字符串参数
+func StrScene(s string) Scene {
+	return Scene{Str: s}
+}
+
+// IsZero 参数为空时返回true
+func (s Scene) IsZero() bool {
+	return s.ID == 0 && s.Str == ""
+}
+
+// String 返回参数在EventKey中的形式
+func (s Scene) String() string {
+	if s.ID != 0 {
+		return strconv.FormatInt(s.ID, 10)
+	}
+	return s.Str
+}
+
+// ParseScene 解析关注事件或者SCAN事件的EventKey，去掉qrscene_前缀，
+// 参数是正整数时返回整型参数，否则返回字符串参数。
+// 因为EventKey中不区分参数类型，字符串参数不要使用纯数字
+func ParseScene(eventKey string) Scene {
+	key := strings.TrimPrefix(eventKey, ScenePrefix)
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil && id > 0 && strconv.FormatInt(id, 10) == key {
+		return Scene{ID: id}
+	}
+	return Scene{Str: key}
+}
+
+// validate 检查参数的范围，permanent为true时检查永久二维码的范围
+func (s Scene) validate(permanent bool) error {
+	switch {
+	case s.ID != 0 && s.Str != "":
+		return errors.New("scene id and scene str are both set")
+	case s.ID < 0 || s.ID > math.MaxUint32:
+		return fmt.Errorf("scene id %d out of range", s.ID)
+	case permanent && s.ID > MaxLimitSceneID:
+		return fmt.Errorf("scene id %d of permanent qrcode out of range [1, %d]", s.ID, MaxLimitSceneID)
+	case s.ID == 0 && (s.Str == "" || len(s.Str) > MaxSceneStrLen):
+		return fmt.Errorf("length of scene str must be 1 to %d, got %d", MaxSceneStrLen, len(s.Str))
+	}
+	return nil
+}
+
+// QRCode 创建的二维码
+type QRCode struct {
+	// Ticket 用于换取二维码图片
+	Ticket string `json:"ticket"`
+	// ExpireSeconds 临时二维码的有效时间，永久二维码为0
+	ExpireSeconds int `json:"expire_seconds,omitempty"`
+	// URL 二维码图片解析后的地址，可以自行生成二维码图片
+	URL string `json:"url"`
+}
+
+// ImageURL 返回使用Ticket换取二维码图片的地址
+func (q *QRCode) ImageURL() string {
+	return ShowURL(q.Ticket)
+}
+
+// ShowURL 返回使用ticket换取二维码图片的地址
+func ShowURL(ticket string) string {
+	return showURL(WxQRCodeShow, ticket)
+}
+
+// showURL 返回使用ticket从show换取二维码图片的地址
+func showURL(show, ticket string) string {
+	return show + "?ticket=" + url.QueryEscape(ticket)
+}
+
+// CreateTemporary 创建临时二维码，expire为有效期，最长MaxExpire，为0时微信使用30秒
+func (s *Service) CreateTemporary(scene Scene, expire time.Duration) (*QRCode, error) {
+	return s.CreateTemporaryContext(context.Background(), scene, expire)
+}
+
+// CreateTemporaryContext 创建临时二维码，expire为有效期，最长MaxExpire，为0时微信使用30秒，
+// ctx取消时中止请求
+func (s *Service) CreateTemporaryContext(ctx context.Context, scene Scene, expire time.Duration) (*QRCode, error) {
+	if expire < 0 || expire > MaxExpire {
+		return nil, fmt.Errorf("create qrcode: expire %s out of range", expire)
+	}
+	action := ActionScene
+	if scene.ID == 0 {
+		action = ActionStrScene
+	}
+	return s.create(ctx, action, scene, int(expire/time.Second))
+}
+
+// CreatePermanent 创建永久二维码，整型参数为1到MaxLimitSceneID
+func (s *Service) CreatePermanent(scene Scene) (*QRCode, error) {
+	return s.CreatePermanentContext(context.Background(), scene)
+}
+
+// CreatePermanentContext 创建永久二维码，整型参数为1到MaxLimitSceneID，ctx取消时中止请求
+func (s *Service) CreatePermanentContext(ctx context.Context, scene Scene) (*QRCode, error) {
+	action := ActionLimitScene
+	if scene.ID == 0 {
+		action = ActionLimitStrScene
+	}
+	return s.create(ctx, action, scene, 0)
+}
+
+// create 调用创建二维码接口
+func (s *Service) create(ctx context.Context, action string, scene Scene, expire int) (*QRCode, error) {
+	permanent := action == ActionLimitScene || action == ActionLimitStrScene
+	if err := scene.validate(permanent); err != nil {
+		return nil, fmt.Errorf("create qrcode %s", err)
+	}
+	type sceneJSON struct {
+		SceneID  int64  `json:"scene_id,omitempty"`
+		SceneStr string `json:"scene_str,omitempty"`
+	}
+	req := struct {
+		ExpireSeconds int    `json:"expire_seconds,omitempty"`
+		ActionName    string `json:"action_name"`
+		ActionInfo    struct {
+			Scene sceneJSON `json:"scene"`
+		} `json:"action_info"`
+	}{ExpireSeconds: expire, ActionName: action}
+	req.ActionInfo.Scene = sceneJSON{scene.ID, scene.Str}
+	var q QRCode
+	if err := s.c.PostJSON(ctx, WxQRCodeCreate, nil, req, &q); err != nil {
+		return nil, fmt.Errorf("create qrcode %w", err)
+	}
+	return &q, nil
+}
+
+// Download 使用ticket换取二维码图片并写入w，返回写入的字节数
+func (s *Service) Download(ticket string, w io.Writer) (int64, error) {
+	return s.DownloadContext(context.Background(), ticket, w)
+}
+
+// DownloadContext 使用ticket换取二维码图片并写入w，返回写入的字节数，ctx取消时中止请求
+func (s *Service) DownloadContext(ctx context.Context, ticket string, w io.Writer) (int64, error) {
+	if ticket == "" {
+		return 0, errors.New("download qrcode: ticket is empty")
+	}
+	show := s.ShowQRCodeURL
+	if show == "" {
+		show = WxQRCodeShow
+	}
+	res, err := s.c.GetURL(ctx, showURL(show, ticket))
+	if err != nil {
+		return 0, fmt.Errorf("download qrcode %w", err)
+	}
+	defer res.Body.Close()
+	// ticket错误时返回404
+	if res.StatusCode != 200 {
+		return 0, fmt.Errorf("download qrcode: %s", res.Status)
+	}
+	if core.IsJSON(res) {
+		return 0, fmt.Errorf("download qrcode: unexpected Content-Type %q", res.Header.Get("Content-Type"))
+	}
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
+		return n, fmt.Errorf("download qrcode %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,103 @@
+package qrcode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"qingtao/weixin/mp/core"
+)
+
+func TestParseScene(t *testing.T) {
+	tests := []struct {
+		key  string
+		want Scene
+	}{
+		{"qrscene_123", Scene{ID: 123}},
+		{"123", Scene{ID: 123}},
+		{"qrscene_poster_a", Scene{Str: "poster_a"}},
+		{"0123", Scene{Str: "0123"}},
+		{"", Scene{}},
+	}
+	for _, tt := range tests {
+		if got := ParseScene(tt.key); got != tt.want {
+			t.Errorf("ParseScene(%q) = %+v, want %+v", tt.key, got, tt.want)
+		}
+	}
+	if s := IntScene(42).String(); s != "42" {
+		t.Fatal(s)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+WxQRCodeCreate {
+			t.Errorf("path %s", r.URL.Path)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		got = nil
+		json.Unmarshal(b, &got)
+		fmt.Fprint(w, `{"ticket":"gQH47joAAAAAAAAAASxodHRwOi8vd2VpeGluLnFxLmNvbS9xL2taZ2Z3TVRtNzJXV1Brb3ZhYmJJAAIEZ23sUwMEmm3sUw==","expire_seconds":60,"url":"http://weixin.qq.com/q/kZgfwMTm72WWPkovabbI"}`)
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	q, err := s.CreateTemporary(IntScene(123), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.ExpireSeconds != 60 || q.URL == "" {
+		t.Fatalf("qrcode %+v", q)
+	}
+	scene := got["action_info"].(map[string]interface{})["scene"].(map[string]interface{})
+	if got["action_name"] != ActionScene || got["expire_seconds"] != 60.0 || scene["scene_id"] != 123.0 {
+		t.Fatalf("request %v", got)
+	}
+
+	if _, err := s.CreatePermanent(StrScene("poster_a")); err != nil {
+		t.Fatal(err)
+	}
+	scene = got["action_info"].(map[string]interface{})["scene"].(map[string]interface{})
+	if got["action_name"] != ActionLimitStrScene || got["expire_seconds"] != nil || scene["scene_str"] != "poster_a" {
+		t.Fatalf("request %v", got)
+	}
+
+	if _, err := s.CreatePermanent(IntScene(MaxLimitSceneID + 1)); err == nil {
+		t.Fatal("permanent scene id out of range")
+	}
+	if _, err := s.CreateTemporary(StrScene(""), time.Minute); err == nil {
+		t.Fatal("empty scene")
+	}
+	if _, err := s.CreateTemporary(IntScene(1), MaxExpire+time.Second); err == nil {
+		t.Fatal("expire out of range")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ticket") != "ticket+1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpg")
+		w.Write([]byte("jpeg"))
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+	s.ShowQRCodeURL = srv.URL + "/cgi-bin/showqrcode"
+
+	var buf bytes.Buffer
+	n, err := s.Download("ticket+1", &buf)
+	if err != nil || n != 4 || buf.String() != "jpeg" {
+		t.Fatalf("download %d %q %v", n, buf.String(), err)
+	}
+	if _, err := s.Download("bad", &buf); err == nil {
+		t.Fatal("bad ticket")
+	}
+}
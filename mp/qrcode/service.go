@@ -0,0 +1,16 @@
+// Package qrcode 公众号带参数二维码接口
+package qrcode
+
+import "qingtao/weixin/mp/core"
+
+// Service 带参数二维码接口，通过*core.Client取得access_token和发送请求
+type Service struct {
+	c *core.Client
+	// ShowQRCodeURL Download换取二维码图片的地址，为空时使用WxQRCodeShow，可用于代理或者测试
+	ShowQRCodeURL string
+}
+
+// NewService 使用c创建带参数二维码接口
+func NewService(c *core.Client) *Service {
+	return &Service{c: c}
+}
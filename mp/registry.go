@@ -0,0 +1,218 @@
+package mp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry 管理多个公众号，一个回调地址接收多个公众号的消息：
+// 先按照URL路径的最后一段查找名称或者AppID，例如/wx/news，
+// 找不到时按照消息的ToUserName查找公众号的OriginalID，
+// 然后使用公众号的Token、EncodingAESKey和Router处理消息。
+// 每个公众号使用自己的access_token和API客户端，可以在运行时添加和删除，可以被多个goroutine同时使用
+type Registry struct {
+	mu       sync.RWMutex
+	accounts map[string]*WeiXin
+	// originals OriginalID到名称
+	originals map[string]string
+}
+
+// NewRegistry 创建没有公众号的*Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		accounts:  make(map[string]*WeiXin),
+		originals: make(map[string]string),
+	}
+}
+
+// NewRegistryFromAccounts 使用LoadAccounts读取的配置创建*Registry
+func NewRegistryFromAccounts(accounts Accounts) (*Registry, error) {
+	r := NewRegistry()
+	for _, name := range accounts.Names() {
+		if err := r.Add(name, accounts[name]); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Add 添加名称为name的公众号，检查配置，名称、AppID或者OriginalID已经存在时返回错误
+func (r *Registry) Add(name string, wx *WeiXin) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("weixin account name %q is invalid", name)
+	}
+	if wx == nil {
+		return fmt.Errorf("weixin account %s: config is nil", name)
+	}
+	if err := wx.Validate(); err != nil {
+		return fmt.Errorf("weixin account %s: %w", name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.accounts[name]; ok {
+		return fmt.Errorf("weixin account %s already exists", name)
+	}
+	for other, a := range r.accounts {
+		if a.AppID == wx.AppID {
+			return fmt.Errorf("weixin account %s: AppID %s is also used by %s", name, wx.AppID, other)
+		}
+	}
+	if wx.OriginalID != "" {
+		if other, ok := r.originals[wx.OriginalID]; ok {
+			return fmt.Errorf("weixin account %s: OriginalID %s is also used by %s", name, wx.OriginalID, other)
+		}
+		r.originals[wx.OriginalID] = name
+	}
+	r.accounts[name] = wx
+	return nil
+}
+
+// Remove 删除名称为name的公众号，返回删除的*WeiXin，不存在时返回nil
+func (r *Registry) Remove(name string) *WeiXin {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wx, ok := r.accounts[name]
+	if !ok {
+		return nil
+	}
+	delete(r.accounts, name)
+	if wx.OriginalID != "" && r.originals[wx.OriginalID] == name {
+		delete(r.originals, wx.OriginalID)
+	}
+	return wx
+}
+
+// Get 按照名称或者AppID查找公众号
+func (r *Registry) Get(nameOrAppID string) (*WeiXin, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lookup(nameOrAppID)
+}
+
+// lookup 调用时必须持有r.mu
+func (r *Registry) lookup(nameOrAppID string) (*WeiXin, bool) {
+	if wx, ok := r.accounts[nameOrAppID]; ok {
+		return wx, true
+	}
+	for _, wx := range r.accounts {
+		if wx.AppID == nameOrAppID {
+			return wx, true
+		}
+	}
+	return nil, false
+}
+
+// GetByOriginalID 按照公众号的原始ID，即消息的ToUserName查找公众号
+func (r *Registry) GetByOriginalID(originalID string) (*WeiXin, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, ok := r.originals[originalID]
+	if !ok {
+		return nil, false
+	}
+	return r.accounts[name], true
+}
+
+// Names 返回排序后的公众号名称
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.accounts))
+	for name := range r.accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// maxBodySize 按照ToUserName查找公众号时最多读取的长度，
+// 是所有公众号的Server的MaxBodySize中最大的值，各公众号的Server再按照自己的MaxBodySize检查
+func (r *Registry) maxBodySize() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var max int64
+	for _, wx := range r.accounts {
+		n := wx.Server().MaxBodySize
+		if n <= 0 {
+			n = DefaultMaxBodySize
+		}
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+var (
+	// errNoAccount 没有找到处理请求的公众号
+	errNoAccount = errors.New("weixin account not found")
+	// errBodyTooLarge 请求内容超过了所有公众号的MaxBodySize
+	errBodyTooLarge = errors.New("weixin message body too large")
+)
+
+// Route 返回处理req的公众号，按照ToUserName查找时读取并恢复req.Body，
+// URL路径的最后一段和ToUserName都没有匹配时返回错误
+func (r *Registry) Route(req *http.Request) (*WeiXin, error) {
+	path := strings.TrimRight(req.URL.Path, "/")
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		path = path[i+1:]
+	}
+	if path != "" {
+		if wx, ok := r.Get(path); ok {
+			return wx, nil
+		}
+	}
+	if req.Method != "POST" || req.Body == nil {
+		return nil, errNoAccount
+	}
+	max := r.maxBodySize()
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+	// 已经读取的内容放回req.Body，由公众号的Server按照自己的MaxBodySize检查完整的请求内容
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		return nil, errBodyTooLarge
+	}
+	// 明文消息和加密消息都有ToUserName
+	var to struct {
+		ToUserName string
+	}
+	if err := xml.Unmarshal(body, &to); err != nil || to.ToUserName == "" {
+		return nil, errNoAccount
+	}
+	if wx, ok := r.GetByOriginalID(to.ToUserName); ok {
+		return wx, nil
+	}
+	return nil, errNoAccount
+}
+
+// ServeHTTP 实现http.Handler接口，使用匹配的公众号的wx.Server()处理请求，没有匹配的公众号时返回404
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	wx, err := r.Route(req)
+	if err == errNoAccount {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err == errBodyTooLarge {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "read body failed", http.StatusBadRequest)
+		return
+	}
+	wx.Server().ServeHTTP(w, req)
+}
@@ -0,0 +1,100 @@
+package mp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	news := &WeiXin{AppID: "wx1", AppSecret: "s1", Token: "t1", OriginalID: "gh_news"}
+	shop := &WeiXin{AppID: "wx2", AppSecret: "s2", Token: "t2", OriginalID: "gh_shop"}
+	for name, wx := range map[string]*WeiXin{"news": news, "shop": shop} {
+		if err := r.Add(name, wx); err != nil {
+			t.Fatal(err)
+		}
+		reply := name
+		wx.Router().HandleMsgFunc(MsgTypeText, func(ctx context.Context, msg *Message) *ResponseMessage {
+			return NewTextMessage(msg.FromUserName, msg.ToUserName, reply)
+		})
+	}
+	if err := r.Add("other", &WeiXin{AppID: "wx1", AppSecret: "s"}); err == nil {
+		t.Fatal("duplicate AppID")
+	}
+	if err := r.Add("other", &WeiXin{AppID: "wx3"}); err == nil {
+		t.Fatal("missing AppSecret")
+	}
+
+	post := func(path, tok, to string) *httptest.ResponseRecorder {
+		return postContent(r, path, tok, to, "hi")
+	}
+	// 按照路径分发
+	if w := post("/wx/shop", "t2", "gh_unknown"); !strings.Contains(w.Body.String(), "shop") {
+		t.Fatalf("by path: %d %s", w.Code, w.Body.String())
+	}
+	// 按照AppID分发
+	if w := post("/wx/wx1", "t1", "gh_unknown"); !strings.Contains(w.Body.String(), "news") {
+		t.Fatalf("by appid: %d %s", w.Code, w.Body.String())
+	}
+	// 按照ToUserName分发
+	if w := post("/wx", "t1", "gh_news"); !strings.Contains(w.Body.String(), "news") {
+		t.Fatalf("by ToUserName: %d %s", w.Code, w.Body.String())
+	}
+	// 使用其他公众号的Token签名
+	if w := post("/wx", "t1", "gh_shop"); w.Code != http.StatusForbidden {
+		t.Fatalf("wrong token: %d", w.Code)
+	}
+
+	if wx := r.Remove("news"); wx != news {
+		t.Fatal("remove")
+	}
+	if w := post("/wx", "t1", "gh_news"); w.Code != http.StatusNotFound {
+		t.Fatalf("removed: %d", w.Code)
+	}
+	if names := r.Names(); len(names) != 1 || names[0] != "shop" {
+		t.Fatalf("names %v", names)
+	}
+}
+
+// postContent 使用tok签名，向r发送ToUserName为to、内容为content的文本消息
+func postContent(r *Registry, path, tok, to, content string) *httptest.ResponseRecorder {
+	q := url.Values{"timestamp": {timestamp}, "nonce": {nonce}, "signature": {Sign(tok, timestamp, nonce, "")}}
+	body := `<xml><ToUserName>` + to + `</ToUserName><FromUserName>user</FromUserName><CreateTime>1</CreateTime><MsgType>text</MsgType><Content>` + content + `</Content><MsgId>1</MsgId></xml>`
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", path+"?"+q.Encode(), strings.NewReader(body)))
+	return w
+}
+
+func TestRegistryMaxBodySize(t *testing.T) {
+	r := NewRegistry()
+	large := &WeiXin{AppID: "wx1", AppSecret: "s1", Token: "t1", OriginalID: "gh_large"}
+	small := &WeiXin{AppID: "wx2", AppSecret: "s2", Token: "t2", OriginalID: "gh_small"}
+	for name, wx := range map[string]*WeiXin{"large": large, "small": small} {
+		if err := r.Add(name, wx); err != nil {
+			t.Fatal(err)
+		}
+		wx.Router().HandleMsgFunc(MsgTypeText, func(ctx context.Context, msg *Message) *ResponseMessage {
+			return NewTextMessage(msg.FromUserName, msg.ToUserName, strconv.Itoa(len(msg.Content)))
+		})
+	}
+	large.Server().MaxBodySize = 2 * DefaultMaxBodySize
+	small.Server().MaxBodySize = 1024
+
+	// 超过DefaultMaxBodySize的消息完整的交给允许的公众号
+	content := strings.Repeat("a", DefaultMaxBodySize+1)
+	if w := postContent(r, "/wx", "t1", "gh_large", content); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), strconv.Itoa(len(content))) {
+		t.Fatalf("large: %d %s", w.Code, w.Body.String())
+	}
+	// 公众号自己的MaxBodySize仍然有效
+	if w := postContent(r, "/wx", "t2", "gh_small", strings.Repeat("a", 2048)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("small: %d", w.Code)
+	}
+	if w := postContent(r, "/wx", "t1", "gh_large", strings.Repeat("a", 2*DefaultMaxBodySize)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("too large: %d", w.Code)
+	}
+}
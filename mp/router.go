@@ -0,0 +1,225 @@
+package mp
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// 消息类型MsgType
+const (
+	// MsgTypeText 文本消息
+	MsgTypeText = "text"
+	// MsgTypeImage 图片消息
+	MsgTypeImage = "image"
+	// MsgTypeVoice 语音消息
+	MsgTypeVoice = "voice"
+	// MsgTypeVideo 视频消息
+	MsgTypeVideo = "video"
+	// MsgTypeShortVideo 小视频消息
+	MsgTypeShortVideo = "shortvideo"
+	// MsgTypeLocation 地理位置消息
+	MsgTypeLocation = "location"
+	// MsgTypeLink 链接消息
+	MsgTypeLink = "link"
+	// MsgTypeEvent 事件推送
+	MsgTypeEvent = "event"
+)
+
+// 事件类型Event
+const (
+	// EventSubscribe 关注，扫描带参数二维码关注时EventKey为qrscene_加二维码的参数
+	EventSubscribe = "subscribe"
+	// EventUnsubscribe 取消关注
+	EventUnsubscribe = "unsubscribe"
+	// EventScan 已关注用户扫描带参数二维码
+	EventScan = "SCAN"
+	// EventLocation 上报地理位置
+	EventLocation = "LOCATION"
+	// EventClick 点击菜单拉取消息
+	EventClick = "CLICK"
+	// EventView 点击菜单跳转链接
+	EventView = "VIEW"
+	// EventViewMiniProgram 点击菜单跳转小程序
+	EventViewMiniProgram = "view_miniprogram"
+	// EventScanCodePush 扫码推事件
+	EventScanCodePush = "scancode_push"
+	// EventScanCodeWaitMsg 扫码推事件且弹出“消息接收中”提示框
+	EventScanCodeWaitMsg = "scancode_waitmsg"
+	// EventPicSysPhoto 弹出系统拍照发图
+	EventPicSysPhoto = "pic_sysphoto"
+	// EventPicPhotoOrAlbum 弹出拍照或者相册发图
+	EventPicPhotoOrAlbum = "pic_photo_or_album"
+	// EventPicWeixin 弹出微信相册发图器
+	EventPicWeixin = "pic_weixin"
+	// EventLocationSelect 弹出地理位置选择器
+	EventLocationSelect = "location_select"
+	// EventTemplateSendJobFinish 模版消息发送结果
+	EventTemplateSendJobFinish = "TEMPLATESENDJOBFINISH"
+	// EventMassSendJobFinish 群发结果
+	EventMassSendJobFinish = "MASSSENDJOBFINISH"
+	// EventUserEnterTempSession 用户进入客服会话
+	EventUserEnterTempSession = "user_enter_tempsession"
+	// EventSubscribeMsgPopup 用户在订阅通知弹窗中操作
+	EventSubscribeMsgPopup = "subscribe_msg_popup_event"
+	// EventSubscribeMsgChange 用户在服务通知管理页面修改订阅
+	EventSubscribeMsgChange = "subscribe_msg_change_event"
+	// EventSubscribeMsgSent 订阅通知发送结果
+	EventSubscribeMsgSent = "subscribe_msg_sent_event"
+)
+
+// SceneKeyPrefix 扫描带参数二维码关注时EventKey的前缀
+const SceneKeyPrefix = "qrscene_"
+
+// Handler 处理一条消息或者事件推送，返回被动回复的消息，返回nil时不回复
+type Handler interface {
+	ServeMessage(ctx context.Context, msg *Message) *ResponseMessage
+}
+
+// HandlerFunc 使用函数实现Handler接口
+type HandlerFunc func(ctx context.Context, msg *Message) *ResponseMessage
+
+// ServeMessage 实现Handler接口
+func (f HandlerFunc) ServeMessage(ctx context.Context, msg *Message) *ResponseMessage {
+	return f(ctx, msg)
+}
+
+// Middleware 包装Handler，可以在处理消息前后执行日志、统计等操作
+type Middleware func(Handler) Handler
+
+// Router 按照MsgType、Event和EventKey分发消息到不同的Handler，
+// 没有匹配的Handler时使用Fallback，可以被多个goroutine同时使用
+type Router struct {
+	mu         sync.RWMutex
+	msgs       map[string]Handler
+	events     map[string]Handler
+	keys       map[string]Handler
+	fallback   Handler
+	middleware []Middleware
+}
+
+// NewRouter 创建没有任何Handler的*Router
+func NewRouter() *Router {
+	return &Router{
+		msgs:   make(map[string]Handler),
+		events: make(map[string]Handler),
+		keys:   make(map[string]Handler),
+	}
+}
+
+// eventKey 事件和EventKey组成的key，事件类型不区分大小写
+func eventKey(event, key string) string {
+	return strings.ToLower(event) + "\x00" + key
+}
+
+// HandleMsg 注册处理msgType消息的Handler，例如MsgTypeText
+func (r *Router) HandleMsg(msgType string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs[msgType] = h
+}
+
+// HandleMsgFunc 注册处理msgType消息的函数
+func (r *Router) HandleMsgFunc(msgType string, f func(ctx context.Context, msg *Message) *ResponseMessage) {
+	r.HandleMsg(msgType, HandlerFunc(f))
+}
+
+// HandleEvent 注册处理event事件的Handler，例如EventSubscribe，event不区分大小写
+func (r *Router) HandleEvent(event string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[strings.ToLower(event)] = h
+}
+
+// HandleEventFunc 注册处理event事件的函数
+func (r *Router) HandleEventFunc(event string, f func(ctx context.Context, msg *Message) *ResponseMessage) {
+	r.HandleEvent(event, HandlerFunc(f))
+}
+
+// HandleEventKey 注册处理EventKey为key的event事件的Handler，例如菜单的CLICK事件，
+// 优先于HandleEvent注册的Handler。
+// 扫描带参数二维码关注时EventKey是qrscene_加参数，注册EventSubscribe时key可以不带前缀
+func (r *Router) HandleEventKey(event, key string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[eventKey(event, key)] = h
+}
+
+// HandleEventKeyFunc 注册处理EventKey为key的event事件的函数
+func (r *Router) HandleEventKeyFunc(event, key string, f func(ctx context.Context, msg *Message) *ResponseMessage) {
+	r.HandleEventKey(event, key, HandlerFunc(f))
+}
+
+// Fallback 设置没有匹配的Handler时使用的Handler
+func (r *Router) Fallback(h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = h
+}
+
+// FallbackFunc 设置没有匹配的Handler时使用的函数
+func (r *Router) FallbackFunc(f func(ctx context.Context, msg *Message) *ResponseMessage) {
+	r.Fallback(HandlerFunc(f))
+}
+
+// Use 添加中间件，先添加的中间件在外层，先于后添加的执行
+func (r *Router) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Match 返回处理msg的Handler，没有匹配的Handler并且没有设置Fallback时返回nil
+func (r *Router) Match(msg *Message) Handler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.match(msg)
+}
+
+// match 调用时必须持有r.mu
+func (r *Router) match(msg *Message) Handler {
+	if string(msg.MsgType) != MsgTypeEvent {
+		if h, ok := r.msgs[string(msg.MsgType)]; ok {
+			return h
+		}
+		return r.fallback
+	}
+	event, key := string(msg.Event), string(msg.EventKey)
+	if key != "" {
+		if h, ok := r.keys[eventKey(event, key)]; ok {
+			return h
+		}
+		if strings.EqualFold(event, EventSubscribe) && strings.HasPrefix(key, SceneKeyPrefix) {
+			if h, ok := r.keys[eventKey(event, strings.TrimPrefix(key, SceneKeyPrefix))]; ok {
+				return h
+			}
+		}
+	}
+	if h, ok := r.events[strings.ToLower(event)]; ok {
+		return h
+	}
+	if h, ok := r.msgs[MsgTypeEvent]; ok {
+		return h
+	}
+	return r.fallback
+}
+
+// ServeMessage 实现Handler接口，经过中间件后分发msg，没有匹配的Handler时返回nil
+func (r *Router) ServeMessage(ctx context.Context, msg *Message) *ResponseMessage {
+	r.mu.RLock()
+	var h Handler = HandlerFunc(r.dispatch)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	r.mu.RUnlock()
+	return h.ServeMessage(ctx, msg)
+}
+
+// dispatch 查找并调用msg的Handler
+func (r *Router) dispatch(ctx context.Context, msg *Message) *ResponseMessage {
+	h := r.Match(msg)
+	if h == nil {
+		return nil
+	}
+	return h.ServeMessage(ctx, msg)
+}
@@ -0,0 +1,73 @@
+package mp
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	reply := func(content string) HandlerFunc {
+		return func(ctx context.Context, msg *Message) *ResponseMessage {
+			return NewTextMessage(msg.FromUserName, msg.ToUserName, content)
+		}
+	}
+	r := NewRouter()
+	r.HandleMsg(MsgTypeText, reply("text"))
+	r.HandleEvent(EventSubscribe, reply("subscribe"))
+	r.HandleEvent(EventClick, reply("click"))
+	r.HandleEventKey(EventClick, "V1001_GOOD", reply("good"))
+	r.HandleEventKey(EventSubscribe, "123", reply("scene"))
+	r.HandleEventKey(EventScan, "123", reply("scan"))
+
+	var trace []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, msg *Message) *ResponseMessage {
+				trace = append(trace, name)
+				return next.ServeMessage(ctx, msg)
+			})
+		}
+	}
+	r.Use(mw("a"), mw("b"))
+
+	tests := []struct {
+		msg  Message
+		want string
+	}{
+		{Message{MsgType: "text", Content: "hi"}, "text"},
+		{Message{MsgType: "event", Event: "subscribe"}, "subscribe"},
+		{Message{MsgType: "event", Event: "subscribe", EventKey: "qrscene_123"}, "scene"},
+		{Message{MsgType: "event", Event: "subscribe", EventKey: "qrscene_456"}, "subscribe"},
+		{Message{MsgType: "event", Event: "SCAN", EventKey: "123"}, "scan"},
+		{Message{MsgType: "event", Event: "CLICK", EventKey: "V1001_GOOD"}, "good"},
+		{Message{MsgType: "event", Event: "CLICK", EventKey: "V1001_TODAY_MUSIC"}, "click"},
+		{Message{MsgType: "image"}, ""},
+		{Message{MsgType: "event", Event: "VIEW"}, ""},
+	}
+	for _, tt := range tests {
+		msg := tt.msg
+		msg.FromUserName, msg.ToUserName = "user", "gh_test"
+		rmsg := r.ServeMessage(context.Background(), &msg)
+		got := ""
+		if rmsg != nil {
+			got = string(rmsg.Content)
+			if rmsg.ToUserName != "user" || rmsg.FromUserName != "gh_test" {
+				t.Errorf("%+v: reply %+v", tt.msg, rmsg)
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s/%s/%s: got %q, want %q", tt.msg.MsgType, tt.msg.Event, tt.msg.EventKey, got, tt.want)
+		}
+	}
+	if s := strings.Join(trace[:2], ""); s != "ab" || len(trace) != 2*len(tests) {
+		t.Fatalf("middleware trace %v", trace)
+	}
+
+	r.FallbackFunc(func(ctx context.Context, msg *Message) *ResponseMessage {
+		return reply("fallback")(ctx, msg)
+	})
+	if rmsg := r.ServeMessage(context.Background(), &Message{MsgType: "image"}); rmsg == nil || rmsg.Content != "fallback" {
+		t.Fatalf("fallback reply %+v", rmsg)
+	}
+}
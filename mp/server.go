@@ -0,0 +1,241 @@
+package mp
+
+import (
+	"crypto/subtle"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"qingtao/weixin/mp/core"
+)
+
+// DefaultMaxBodySize 微信服务器推送消息的最大长度，超过时返回413
+const DefaultMaxBodySize = 1 << 20
+
+// Server 接收微信服务器推送消息和事件的http.Handler，
+// 使用同一个流程处理明文模式、兼容模式和安全模式：
+// 请求的encrypt_type为aes时校验msg_signature并解密，回复加密的消息；否则校验signature，回复明文消息
+type Server struct {
+	// AppID 公众号的AppID，用于校验解密后的消息和加密回复，为空时不校验
+	AppID string
+	// Token 服务器配置中的令牌
+	Token string
+	// EncodingAESKey 消息加解密密钥
+	EncodingAESKey string
+	// OldEncodingAESKey 修改EncodingAESKey之前的密钥，解密失败时使用
+	OldEncodingAESKey string
+	// Keys 接收的消息按顺序尝试的密钥，回复使用消息解密时的密钥加密，
+	// 为nil时使用EncodingAESKey和OldEncodingAESKey
+	Keys *KeyRing
+	// Handler 处理解析后的消息，为nil或者返回nil时回复success
+	Handler Handler
+	// MaxBodySize 请求内容的最大长度，为0时使用DefaultMaxBodySize
+	MaxBodySize int64
+	// Dedup 识别微信服务器重试推送的消息，重试的消息使用第一次处理的回复，为nil时不去重
+	Dedup DedupStore
+	// DedupTTL 消息处理记录的保存时间，为0时使用DefaultDedupTTL
+	DedupTTL time.Duration
+	// Logger 记录每条消息的类型、事件、openid的摘要和处理耗时，以及校验失败等错误，
+	// 不记录消息内容和密钥，为nil时不输出日志
+	Logger Logger
+
+	once    sync.Once
+	ring    *KeyRing
+	ringErr error
+}
+
+// NewServer 使用wx的配置和h创建*Server
+func NewServer(wx *WeiXin, h Handler) *Server {
+	// 密钥非法时Keys为nil，解密时返回同样的错误
+	keys, _ := wx.KeyRing()
+	return &Server{
+		AppID:             wx.AppID,
+		Token:             wx.Token,
+		EncodingAESKey:    wx.EncodingAESKey,
+		OldEncodingAESKey: wx.OldEncodingAESKey,
+		Keys:              keys,
+		Handler:           h,
+		Dedup:             NewMemoryDedupStore(0),
+		Logger:            wx.log(),
+	}
+}
+
+// Server 返回使用wx.Router()处理消息的*Server，第一次调用时创建，
+// 之后修改wx的配置不会影响已经创建的*Server，SetLogger除外
+func (wx *WeiXin) Server() *Server {
+	h := wx.Router()
+	wx.mu.Lock()
+	s := wx.server
+	wx.mu.Unlock()
+	if s != nil {
+		return s
+	}
+	s = NewServer(wx, h)
+	wx.mu.Lock()
+	defer wx.mu.Unlock()
+	if wx.server == nil {
+		wx.server = s
+	}
+	return wx.server
+}
+
+func (s *Server) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return core.NopLogger
+}
+
+// fail 记录错误并返回HTTP状态码
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, code int, msg string, err error) {
+	s.logger().Warn("weixin callback rejected", "status", code, "reason", msg, "error", err,
+		"remote", r.RemoteAddr)
+	http.Error(w, msg, code)
+}
+
+// verify 使用常量时间比较签名
+func (s *Server) verify(signature, timestamp, nonce, ciphertext string) bool {
+	sign := Sign(s.Token, timestamp, nonce, ciphertext)
+	return subtle.ConstantTimeCompare([]byte(sign), []byte(signature)) == 1
+}
+
+// ServeHTTP 实现http.Handler接口
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	query := r.URL.Query()
+	timestamp := query.Get("timestamp")
+	nonce := query.Get("nonce")
+	switch r.Method {
+	// GET方法用于微信服务器配置验证
+	case "GET":
+		if !s.verify(query.Get("signature"), timestamp, nonce, "") {
+			s.fail(w, r, http.StatusForbidden, "invalid signature", ErrValidateSignature)
+			return
+		}
+		s.logger().Info("weixin callback verified")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, query.Get("echostr"))
+		return
+	case "POST":
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		s.fail(w, r, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed), nil)
+		return
+	}
+
+	max := s.MaxBodySize
+	if max <= 0 {
+		max = DefaultMaxBodySize
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, max))
+	if err != nil {
+		var merr *http.MaxBytesError
+		if errors.As(err, &merr) {
+			s.fail(w, r, http.StatusRequestEntityTooLarge, http.StatusText(http.StatusRequestEntityTooLarge), err)
+			return
+		}
+		s.fail(w, r, http.StatusBadRequest, "read body failed", err)
+		return
+	}
+
+	encrypted := query.Get("encrypt_type") == wxEncryptType
+	plaintext := body
+	var key, appid string
+	if encrypted {
+		// 兼容模式和安全模式都使用msg_signature校验密文
+		var emsg EncryptMessage
+		if err := xml.Unmarshal(body, &emsg); err != nil || emsg.Encrypt == "" {
+			s.fail(w, r, http.StatusBadRequest, "invalid encrypted message", err)
+			return
+		}
+		if !s.verify(query.Get("msg_signature"), timestamp, nonce, string(emsg.Encrypt)) {
+			s.fail(w, r, http.StatusForbidden, "invalid msg_signature", ErrValidateSignature)
+			return
+		}
+		plaintext, key, appid, err = s.decrypt(string(emsg.Encrypt))
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrValidateAppID) {
+				status = http.StatusForbidden
+			}
+			s.fail(w, r, status, "decrypt message failed", err)
+			return
+		}
+	} else if !s.verify(query.Get("signature"), timestamp, nonce, "") {
+		s.fail(w, r, http.StatusForbidden, "invalid signature", ErrValidateSignature)
+		return
+	}
+
+	var msg Message
+	if err := xml.Unmarshal(plaintext, &msg); err != nil {
+		s.fail(w, r, http.StatusBadRequest, "invalid message", err)
+		return
+	}
+	msg.Raw = plaintext
+	var rmsg *ResponseMessage
+	if h := s.Handler; h != nil {
+		if s.Dedup != nil {
+			h = Dedup(s.Dedup, s.DedupTTL)(h)
+		}
+		rmsg = h.ServeMessage(r.Context(), &msg)
+	}
+	log := s.logger()
+	log.Info("weixin message", "msgtype", string(msg.MsgType), "event", string(msg.Event),
+		"openid", core.HashOpenID(string(msg.FromUserName)), "encrypted", encrypted,
+		"aeskey", keyIDOf(key), "reply", rmsg != nil, "latency", time.Since(start))
+	if rmsg == nil {
+		// 回复success，微信服务器不会重试，也不会提示用户“该公众号暂时无法提供服务”
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, "success")
+		return
+	}
+	resp, err := s.reply(rmsg, encrypted, key, appid, timestamp, nonce)
+	if err != nil {
+		log.Error("weixin reply failed", "msgtype", string(msg.MsgType), "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(resp)
+}
+
+// keyRing 返回s.Keys，为nil时使用EncodingAESKey和OldEncodingAESKey创建
+func (s *Server) keyRing() (*KeyRing, error) {
+	if s.Keys != nil {
+		return s.Keys, nil
+	}
+	s.once.Do(func() {
+		s.ring, s.ringErr = NewKeyRing(s.EncodingAESKey, s.OldEncodingAESKey)
+	})
+	return s.ring, s.ringErr
+}
+
+// decrypt 按顺序使用每个密钥解密，返回明文、解密使用的密钥和appid
+func (s *Server) decrypt(ciphertext string) ([]byte, string, string, error) {
+	ring, err := s.keyRing()
+	if err != nil {
+		return nil, "", "", err
+	}
+	return ring.Decrypt(s.AppID, ciphertext)
+}
+
+// reply 生成回复的内容，encrypted为true时使用key和appid加密
+func (s *Server) reply(rmsg *ResponseMessage, encrypted bool, key, appid, timestamp, nonce string) ([]byte, error) {
+	b, err := xml.Marshal(rmsg)
+	if err != nil {
+		return nil, fmt.Errorf("marshal reply %s", err)
+	}
+	if !encrypted {
+		return b, nil
+	}
+	ciphertext, err := Encrypt(key, appid, b)
+	if err != nil {
+		return nil, err
+	}
+	return xml.Marshal(NewEncryptResponse(appid, s.Token, timestamp, nonce, ciphertext))
+}
@@ -0,0 +1,125 @@
+package mp
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func serverRequest(method string, query url.Values, body string) *http.Request {
+	return httptest.NewRequest(method, "/wx?"+query.Encode(), strings.NewReader(body))
+}
+
+func TestServer(t *testing.T) {
+	r := NewRouter()
+	r.HandleMsgFunc(MsgTypeText, func(ctx context.Context, msg *Message) *ResponseMessage {
+		return NewTextMessage(msg.FromUserName, msg.ToUserName, "echo "+string(msg.Content))
+	})
+	s := &Server{AppID: appid, Token: token, EncodingAESKey: encodingAESKey, Handler: r}
+	signed := url.Values{
+		"timestamp": {timestamp},
+		"nonce":     {nonce},
+		"signature": {Sign(token, timestamp, nonce, "")},
+	}
+	text := `<xml><ToUserName><![CDATA[gh_test]]></ToUserName><FromUserName><![CDATA[user]]></FromUserName><CreateTime>1409735668</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[hi]]></Content><MsgId>1</MsgId></xml>`
+
+	t.Run("verify", func(t *testing.T) {
+		q := url.Values{"echostr": {"echo"}}
+		for k, v := range signed {
+			q[k] = v
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, serverRequest("GET", q, ""))
+		if w.Code != 200 || w.Body.String() != "echo" {
+			t.Fatalf("%d %q", w.Code, w.Body.String())
+		}
+		q.Set("signature", "bad")
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, serverRequest("GET", q, ""))
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("bad signature: %d", w.Code)
+		}
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, serverRequest("POST", signed, text))
+		var rmsg ResponseMessage
+		if err := xml.Unmarshal(w.Body.Bytes(), &rmsg); err != nil || rmsg.Content != "echo hi" || rmsg.ToUserName != "user" {
+			t.Fatalf("%d %s %v", w.Code, w.Body.String(), err)
+		}
+	})
+
+	t.Run("safe", func(t *testing.T) {
+		ciphertext, err := Encrypt(encodingAESKey, appid, []byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := url.Values{
+			"timestamp":     {timestamp},
+			"nonce":         {nonce},
+			"encrypt_type":  {"aes"},
+			"msg_signature": {Sign(token, timestamp, nonce, ciphertext)},
+		}
+		body := `<xml><ToUserName><![CDATA[gh_test]]></ToUserName><Encrypt><![CDATA[` + ciphertext + `]]></Encrypt></xml>`
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, serverRequest("POST", q, body))
+		if w.Code != 200 {
+			t.Fatalf("%d %s", w.Code, w.Body.String())
+		}
+		var eres EncryptResponse
+		if err := xml.Unmarshal(w.Body.Bytes(), &eres); err != nil {
+			t.Fatal(err)
+		}
+		if string(eres.MsgSignature) != Sign(token, eres.TimeStamp, string(eres.Nonce), string(eres.Encrypt)) {
+			t.Fatal("reply signature mismatch")
+		}
+		b, err := Decrypt(encodingAESKey, string(eres.Encrypt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, id, err := ParseDecryptMessage(b)
+		if err != nil || id != appid {
+			t.Fatalf("appid %q %v", id, err)
+		}
+		var rmsg ResponseMessage
+		if err := xml.Unmarshal(plaintext, &rmsg); err != nil || rmsg.Content != "echo hi" {
+			t.Fatalf("%s %v", plaintext, err)
+		}
+
+		// 密文被修改
+		q.Set("msg_signature", Sign(token, timestamp, nonce, "other"))
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, serverRequest("POST", q, body))
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("bad msg_signature: %d", w.Code)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			method string
+			query  url.Values
+			body   string
+			code   int
+			resp   string
+		}{
+			{"POST", signed, `<xml><MsgType>image</MsgType></xml>`, 200, "success"},
+			{"POST", signed, `not xml`, http.StatusBadRequest, ""},
+			{"POST", url.Values{}, text, http.StatusForbidden, ""},
+			{"PUT", signed, text, http.StatusMethodNotAllowed, ""},
+			{"POST", signed, "<xml>" + strings.Repeat("a", DefaultMaxBodySize) + "</xml>", http.StatusRequestEntityTooLarge, ""},
+		}
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, serverRequest(tt.method, tt.query, tt.body))
+			if w.Code != tt.code || tt.resp != "" && w.Body.String() != tt.resp {
+				t.Errorf("%s %s: %d %q", tt.method, tt.body[:10], w.Code, w.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,14 @@
+// Package subscribe 公众号订阅通知接口
+package subscribe
+
+import "qingtao/weixin/mp/core"
+
+// Service 订阅通知接口，通过*core.Client取得access_token和发送请求
+type Service struct {
+	c *core.Client
+}
+
+// NewService 使用c创建订阅通知接口
+func NewService(c *core.Client) *Service {
+	return &Service{c}
+}
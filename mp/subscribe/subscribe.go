@@ -0,0 +1,261 @@
+package subscribe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// WxSubscribeGetCategory 获取公众号所属类目
+	WxSubscribeGetCategory = "wxaapi/newtmpl/getcategory"
+	// WxSubscribeGetPubTitles 获取类目下的公共模板
+	WxSubscribeGetPubTitles = "wxaapi/newtmpl/getpubtemplatetitles"
+	// WxSubscribeGetPubKeywords 获取公共模板的关键词列表
+	WxSubscribeGetPubKeywords = "wxaapi/newtmpl/getpubtemplatekeywords"
+	// WxSubscribeAddTemplate 选用模板
+	WxSubscribeAddTemplate = "wxaapi/newtmpl/addtemplate"
+	// WxSubscribeDelTemplate 删除模板
+	WxSubscribeDelTemplate = "wxaapi/newtmpl/deltemplate"
+	// WxSubscribeGetTemplates 获取私有模板列表
+	WxSubscribeGetTemplates = "wxaapi/newtmpl/gettemplate"
+	// WxSubscribeSend 发送订阅通知
+	WxSubscribeSend = "cgi-bin/message/subscribe/bizsend"
+)
+
+// 模板类型
+const (
+	// TypeOnce 一次性订阅
+	TypeOnce = 2
+	// TypeLongTerm 长期订阅
+	TypeLongTerm = 3
+)
+
+// Category 公众号所属的类目
+type Category struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// GetCategory 获取公众号所属的类目
+func (s *Service) GetCategory() ([]*Category, error) {
+	return s.GetCategoryContext(context.Background())
+}
+
+// GetCategoryContext 获取公众号所属的类目，ctx取消时中止请求
+func (s *Service) GetCategoryContext(ctx context.Context) ([]*Category, error) {
+	var resp struct {
+		Data []*Category `json:"data"`
+	}
+	if err := s.c.Get(ctx, WxSubscribeGetCategory, nil, &resp); err != nil {
+		return nil, fmt.Errorf("get subscribe category %w", err)
+	}
+	return resp.Data, nil
+}
+
+// PubTemplate 类目下的公共模板
+type PubTemplate struct {
+	// TID 模板标题id
+	TID int `json:"tid"`
+	// Title 模板标题
+	Title string `json:"title"`
+	// Type 模板类型，TypeOnce或者TypeLongTerm
+	Type int `json:"type"`
+	// CategoryID 模板所属类目id
+	CategoryID string `json:"categoryId"`
+}
+
+// PubTemplates 公共模板列表和总数
+type PubTemplates struct {
+	Count int            `json:"count"`
+	Data  []*PubTemplate `json:"data"`
+}
+
+// GetPubTemplateTitles 获取类目ids下的公共模板，start从0开始，limit最大为30
+func (s *Service) GetPubTemplateTitles(ids []int, start, limit int) (*PubTemplates, error) {
+	return s.GetPubTemplateTitlesContext(context.Background(), ids, start, limit)
+}
+
+// GetPubTemplateTitlesContext 获取类目ids下的公共模板，start从0开始，limit最大为30，ctx取消时中止请求
+func (s *Service) GetPubTemplateTitlesContext(ctx context.Context, ids []int, start, limit int) (*PubTemplates, error) {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.Itoa(id)
+	}
+	query := url.Values{
+		"ids":   {strings.Join(strs, ",")},
+		"start": {strconv.Itoa(start)},
+		"limit": {strconv.Itoa(limit)},
+	}
+	var list PubTemplates
+	if err := s.c.Get(ctx, WxSubscribeGetPubTitles, query, &list); err != nil {
+		return nil, fmt.Errorf("get pub template titles %w", err)
+	}
+	return &list, nil
+}
+
+// Keyword 公共模板的关键词
+type Keyword struct {
+	// KID 关键词id，选用模板时使用
+	KID int `json:"kid"`
+	// Name 关键词内容
+	Name string `json:"name"`
+	// Example 关键词内容对应的示例
+	Example string `json:"example"`
+	// Rule 参数类型，例如thing、time、character_string
+	Rule string `json:"rule"`
+}
+
+// GetPubTemplateKeywords 获取公共模板tid的关键词列表
+func (s *Service) GetPubTemplateKeywords(tid int) ([]*Keyword, error) {
+	return s.GetPubTemplateKeywordsContext(context.Background(), tid)
+}
+
+// GetPubTemplateKeywordsContext 获取公共模板tid的关键词列表，ctx取消时中止请求
+func (s *Service) GetPubTemplateKeywordsContext(ctx context.Context, tid int) ([]*Keyword, error) {
+	var resp struct {
+		Data []*Keyword `json:"data"`
+	}
+	query := url.Values{"tid": {strconv.Itoa(tid)}}
+	if err := s.c.Get(ctx, WxSubscribeGetPubKeywords, query, &resp); err != nil {
+		return nil, fmt.Errorf("get pub template keywords %w", err)
+	}
+	return resp.Data, nil
+}
+
+// AddTemplate 选用公共模板tid的关键词kids作为私有模板，返回私有模板id，
+// kids最多5个，按照顺序排列，sceneDesc是服务场景描述
+func (s *Service) AddTemplate(tid int, kids []int, sceneDesc string) (string, error) {
+	return s.AddTemplateContext(context.Background(), tid, kids, sceneDesc)
+}
+
+// AddTemplateContext 选用公共模板tid的关键词kids作为私有模板，返回私有模板id，
+// kids最多5个，按照顺序排列，sceneDesc是服务场景描述，ctx取消时中止请求
+func (s *Service) AddTemplateContext(ctx context.Context, tid int, kids []int, sceneDesc string) (string, error) {
+	if len(kids) < 2 || len(kids) > 5 {
+		return "", fmt.Errorf("add subscribe template: need 2 to 5 keywords, got %d", len(kids))
+	}
+	req := struct {
+		TID       string `json:"tid"`
+		KidList   []int  `json:"kidList"`
+		SceneDesc string `json:"sceneDesc,omitempty"`
+	}{strconv.Itoa(tid), kids, sceneDesc}
+	var resp struct {
+		PriTmplID string `json:"priTmplId"`
+	}
+	if err := s.c.PostJSON(ctx, WxSubscribeAddTemplate, nil, req, &resp); err != nil {
+		return "", fmt.Errorf("add subscribe template %w", err)
+	}
+	return resp.PriTmplID, nil
+}
+
+// DeleteTemplate 删除私有模板
+func (s *Service) DeleteTemplate(priTmplID string) error {
+	return s.DeleteTemplateContext(context.Background(), priTmplID)
+}
+
+// DeleteTemplateContext 删除私有模板，ctx取消时中止请求
+func (s *Service) DeleteTemplateContext(ctx context.Context, priTmplID string) error {
+	req := struct {
+		PriTmplID string `json:"priTmplId"`
+	}{priTmplID}
+	if err := s.c.PostJSON(ctx, WxSubscribeDelTemplate, nil, req, nil); err != nil {
+		return fmt.Errorf("delete subscribe template %w", err)
+	}
+	return nil
+}
+
+// Template 私有模板
+type Template struct {
+	// PriTmplID 私有模板id，发送订阅通知时使用
+	PriTmplID string `json:"priTmplId"`
+	// Title 模板标题
+	Title string `json:"title"`
+	// Content 模板内容，例如{{thing1.DATA}}
+	Content string `json:"content"`
+	// Example 模板示例
+	Example string `json:"example"`
+	// Type 模板类型，TypeOnce或者TypeLongTerm
+	Type int `json:"type"`
+}
+
+// GetTemplates 获取私有模板列表
+func (s *Service) GetTemplates() ([]*Template, error) {
+	return s.GetTemplatesContext(context.Background())
+}
+
+// GetTemplatesContext 获取私有模板列表，ctx取消时中止请求
+func (s *Service) GetTemplatesContext(ctx context.Context) ([]*Template, error) {
+	var resp struct {
+		Data []*Template `json:"data"`
+	}
+	if err := s.c.Get(ctx, WxSubscribeGetTemplates, nil, &resp); err != nil {
+		return nil, fmt.Errorf("get subscribe templates %w", err)
+	}
+	return resp.Data, nil
+}
+
+// Data 订阅通知的数据，key是模板中的参数，例如thing1、time2
+type Data map[string]string
+
+// MarshalJSON 实现json.Marshaler接口，编码为{"thing1":{"value":"..."}}
+func (d Data) MarshalJSON() ([]byte, error) {
+	m := make(map[string]struct {
+		Value string `json:"value"`
+	}, len(d))
+	for k, v := range d {
+		m[k] = struct {
+			Value string `json:"value"`
+		}{v}
+	}
+	return json.Marshal(m)
+}
+
+// MiniProgram 点击订阅通知跳转的小程序
+type MiniProgram struct {
+	// AppID 小程序的appid，必须已经关联公众号
+	AppID string `json:"appid"`
+	// PagePath 小程序的页面路径
+	PagePath string `json:"pagepath,omitempty"`
+}
+
+// Message 订阅通知，Page和MiniProgram同时设置时优先跳转小程序
+type Message struct {
+	// ToUser 接收者的openid
+	ToUser string `json:"touser"`
+	// TemplateID 私有模板id
+	TemplateID string `json:"template_id"`
+	// Page 点击跳转的网页
+	Page string `json:"page,omitempty"`
+	// MiniProgram 点击跳转的小程序
+	MiniProgram *MiniProgram `json:"miniprogram,omitempty"`
+	// Data 模板数据
+	Data Data `json:"data"`
+}
+
+// NewMessage 创建发送给touser的订阅通知
+func NewMessage(touser, templateID string, data Data) *Message {
+	return &Message{ToUser: touser, TemplateID: templateID, Data: data}
+}
+
+// Send 发送订阅通知，用户需要已经同意订阅templateID，
+// 发送结果通过subscribe_msg_sent_event事件推送
+func (s *Service) Send(msg *Message) error {
+	return s.SendContext(context.Background(), msg)
+}
+
+// SendContext 发送订阅通知，用户需要已经同意订阅templateID，
+// 发送结果通过subscribe_msg_sent_event事件推送，ctx取消时中止请求
+func (s *Service) SendContext(ctx context.Context, msg *Message) error {
+	if msg.ToUser == "" || msg.TemplateID == "" {
+		return errors.New("send subscribe message: touser and template_id are required")
+	}
+	if err := s.c.PostJSON(ctx, WxSubscribeSend, nil, msg, nil); err != nil {
+		return fmt.Errorf("send subscribe message %w", err)
+	}
+	return nil
+}
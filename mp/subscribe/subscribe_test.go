@@ -0,0 +1,61 @@
+package subscribe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"qingtao/weixin/mp/core"
+)
+
+func TestService(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		switch r.URL.Path {
+		case "/" + WxSubscribeGetPubKeywords:
+			if r.URL.Query().Get("tid") != "99" {
+				t.Errorf("query %s", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `{"count":1,"data":[{"kid":1,"name":"物品名称","example":"名称","rule":"thing"}]}`)
+		case "/" + WxSubscribeAddTemplate:
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","priTmplId":"9Aw5ZV1j9xdWTFEkqCpZ7mIBbSC34khK55OtzUPl0rU"}`)
+		case "/" + WxSubscribeSend:
+			fmt.Fprint(w, `{"errcode":43101,"errmsg":"user refuse to accept the msg"}`)
+		default:
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+		}
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	kws, err := s.GetPubTemplateKeywords(99)
+	if err != nil || len(kws) != 1 || kws[0].Rule != "thing" {
+		t.Fatalf("keywords %v %v", kws, err)
+	}
+	id, err := s.AddTemplate(99, []int{3, 1}, "发货通知")
+	if err != nil || id == "" {
+		t.Fatalf("add %q %v", id, err)
+	}
+	var req map[string]interface{}
+	json.Unmarshal([]byte(body), &req)
+	if req["tid"] != "99" || len(req["kidList"].([]interface{})) != 2 {
+		t.Fatalf("add request %s", body)
+	}
+	if _, err := s.AddTemplate(99, []int{1}, ""); err == nil {
+		t.Fatal("one keyword")
+	}
+
+	err = s.Send(NewMessage("openid", id, Data{"thing1": "快递"}))
+	if core.ErrCode(err) != 43101 {
+		t.Fatalf("send %v", err)
+	}
+	if want := `"data":{"thing1":{"value":"快递"}}`; !strings.Contains(body, want) {
+		t.Fatalf("send request %s", body)
+	}
+}
@@ -0,0 +1,14 @@
+// Package template 模板消息接口
+package template
+
+import "qingtao/weixin/mp/core"
+
+// Service 模板消息接口，通过*core.Client取得access_token和发送请求
+type Service struct {
+	c *core.Client
+}
+
+// NewService 使用c创建模板消息接口
+func NewService(c *core.Client) *Service {
+	return &Service{c}
+}
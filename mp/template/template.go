@@ -0,0 +1,240 @@
+package template
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	// WxTemplateSend 发送模板消息
+	WxTemplateSend = "cgi-bin/message/template/send"
+	// WxTemplateSetIndustry 设置所属行业
+	WxTemplateSetIndustry = "cgi-bin/template/api_set_industry"
+	// WxTemplateGetIndustry 获取设置的行业信息
+	WxTemplateGetIndustry = "cgi-bin/template/get_industry"
+	// WxTemplateAdd 从模板库添加模板，获得模板ID
+	WxTemplateAdd = "cgi-bin/template/api_add_template"
+	// WxTemplateGetAll 获取模板列表
+	WxTemplateGetAll = "cgi-bin/template/get_all_private_template"
+	// WxTemplateDel 删除模板
+	WxTemplateDel = "cgi-bin/template/del_private_template"
+)
+
+// 模板消息发送结果事件TEMPLATESENDJOBFINISH的Status
+const (
+	// StatusSuccess 送达成功
+	StatusSuccess = "success"
+	// StatusUserBlock 用户拒收
+	StatusUserBlock = "failed:user block"
+	// StatusSystemFailed 其他原因发送失败
+	StatusSystemFailed = "failed: system failed"
+)
+
+// Value 模板中一个关键词的值
+type Value struct {
+	// Value 关键词的内容
+	Value string `json:"value"`
+	// Color 关键词的颜色，例如#173177，为空时使用默认颜色
+	Color string `json:"color,omitempty"`
+}
+
+// Data 模板数据，key是模板中的关键词，例如first、keyword1、remark
+type Data map[string]*Value
+
+// Set 设置关键词key的内容和颜色，返回d用于连续设置
+func (d Data) Set(key, value, color string) Data {
+	d[key] = &Value{Value: value, Color: color}
+	return d
+}
+
+// MiniProgram 点击模板消息跳转的小程序
+type MiniProgram struct {
+	// AppID 小程序的appid，必须已经关联公众号
+	AppID string `json:"appid"`
+	// PagePath 小程序的页面路径，可以带参数，为空时跳转首页
+	PagePath string `json:"pagepath,omitempty"`
+}
+
+// Message 模板消息，URL和MiniProgram都为空时不跳转，同时设置时优先跳转小程序
+type Message struct {
+	// ToUser 接收者的openid
+	ToUser string `json:"touser"`
+	// TemplateID 模板ID
+	TemplateID string `json:"template_id"`
+	// URL 点击模板消息跳转的链接
+	URL string `json:"url,omitempty"`
+	// MiniProgram 点击模板消息跳转的小程序
+	MiniProgram *MiniProgram `json:"miniprogram,omitempty"`
+	// ClientMsgID 防重入id，同一个id的消息只发送一次
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+	// Data 模板数据
+	Data Data `json:"data"`
+}
+
+// NewMessage 创建发送给touser的模板消息，使用msg.Data.Set设置模板数据
+func NewMessage(touser, templateID string) *Message {
+	return &Message{ToUser: touser, TemplateID: templateID, Data: make(Data)}
+}
+
+// SetURL 设置点击跳转的链接，返回msg
+func (msg *Message) SetURL(url string) *Message {
+	msg.URL = url
+	return msg
+}
+
+// SetMiniProgram 设置点击跳转的小程序页面，返回msg
+func (msg *Message) SetMiniProgram(appid, pagepath string) *Message {
+	msg.MiniProgram = &MiniProgram{AppID: appid, PagePath: pagepath}
+	return msg
+}
+
+// validate 检查必须的字段
+func (msg *Message) validate() error {
+	if msg.ToUser == "" {
+		return errors.New("touser is empty")
+	}
+	if msg.TemplateID == "" {
+		return errors.New("template_id is empty")
+	}
+	if msg.MiniProgram != nil && msg.MiniProgram.AppID == "" {
+		return errors.New("appid of miniprogram is empty")
+	}
+	return nil
+}
+
+// Send 发送模板消息，返回消息id，
+// 发送结果通过TEMPLATESENDJOBFINISH事件推送，事件的MsgID与返回值相同
+func (s *Service) Send(msg *Message) (int64, error) {
+	return s.SendContext(context.Background(), msg)
+}
+
+// SendContext 发送模板消息，返回消息id，
+// 发送结果通过TEMPLATESENDJOBFINISH事件推送，事件的MsgID与返回值相同，ctx取消时中止请求
+func (s *Service) SendContext(ctx context.Context, msg *Message) (int64, error) {
+	if err := msg.validate(); err != nil {
+		return 0, fmt.Errorf("send template message %s", err)
+	}
+	var resp struct {
+		MsgID int64 `json:"msgid"`
+	}
+	if err := s.c.PostJSON(ctx, WxTemplateSend, nil, msg, &resp); err != nil {
+		return 0, fmt.Errorf("send template message %w", err)
+	}
+	return resp.MsgID, nil
+}
+
+// IndustryClass 行业的主行业和副行业
+type IndustryClass struct {
+	FirstClass  string `json:"first_class"`
+	SecondClass string `json:"second_class"`
+}
+
+// Industry 公众号设置的行业信息
+type Industry struct {
+	// PrimaryIndustry 主营行业
+	PrimaryIndustry *IndustryClass `json:"primary_industry"`
+	// SecondaryIndustry 副营行业
+	SecondaryIndustry *IndustryClass `json:"secondary_industry"`
+}
+
+// SetIndustry 设置所属行业，id1和id2是行业代码，每月可修改一次
+func (s *Service) SetIndustry(id1, id2 string) error {
+	return s.SetIndustryContext(context.Background(), id1, id2)
+}
+
+// SetIndustryContext 设置所属行业，id1和id2是行业代码，每月可修改一次，ctx取消时中止请求
+func (s *Service) SetIndustryContext(ctx context.Context, id1, id2 string) error {
+	req := struct {
+		ID1 string `json:"industry_id1"`
+		ID2 string `json:"industry_id2"`
+	}{id1, id2}
+	if err := s.c.PostJSON(ctx, WxTemplateSetIndustry, nil, req, nil); err != nil {
+		return fmt.Errorf("set industry %w", err)
+	}
+	return nil
+}
+
+// GetIndustry 获取设置的行业信息
+func (s *Service) GetIndustry() (*Industry, error) {
+	return s.GetIndustryContext(context.Background())
+}
+
+// GetIndustryContext 获取设置的行业信息，ctx取消时中止请求
+func (s *Service) GetIndustryContext(ctx context.Context) (*Industry, error) {
+	var industry Industry
+	if err := s.c.Get(ctx, WxTemplateGetIndustry, nil, &industry); err != nil {
+		return nil, fmt.Errorf("get industry %w", err)
+	}
+	return &industry, nil
+}
+
+// AddTemplate 使用模板库中模板的编号shortID添加模板，返回模板ID，
+// keywords是选用的关键词，为空时使用模板的全部关键词
+func (s *Service) AddTemplate(shortID string, keywords ...string) (string, error) {
+	return s.AddTemplateContext(context.Background(), shortID, keywords...)
+}
+
+// AddTemplateContext 使用模板库中模板的编号shortID添加模板，返回模板ID，
+// keywords是选用的关键词，为空时使用模板的全部关键词，ctx取消时中止请求
+func (s *Service) AddTemplateContext(ctx context.Context, shortID string, keywords ...string) (string, error) {
+	req := struct {
+		ShortID  string   `json:"template_id_short"`
+		Keywords []string `json:"keyword_name_list,omitempty"`
+	}{shortID, keywords}
+	var resp struct {
+		TemplateID string `json:"template_id"`
+	}
+	if err := s.c.PostJSON(ctx, WxTemplateAdd, nil, req, &resp); err != nil {
+		return "", fmt.Errorf("add template %w", err)
+	}
+	return resp.TemplateID, nil
+}
+
+// Template 公众号已添加的模板
+type Template struct {
+	// TemplateID 模板ID
+	TemplateID string `json:"template_id"`
+	// Title 模板标题
+	Title string `json:"title"`
+	// PrimaryIndustry 模板所属行业的一级行业
+	PrimaryIndustry string `json:"primary_industry"`
+	// DeputyIndustry 模板所属行业的二级行业
+	DeputyIndustry string `json:"deputy_industry"`
+	// Content 模板内容，例如{{first.DATA}}
+	Content string `json:"content"`
+	// Example 模板示例
+	Example string `json:"example"`
+}
+
+// GetAllTemplates 获取已添加的模板列表
+func (s *Service) GetAllTemplates() ([]*Template, error) {
+	return s.GetAllTemplatesContext(context.Background())
+}
+
+// GetAllTemplatesContext 获取已添加的模板列表，ctx取消时中止请求
+func (s *Service) GetAllTemplatesContext(ctx context.Context) ([]*Template, error) {
+	var resp struct {
+		TemplateList []*Template `json:"template_list"`
+	}
+	if err := s.c.Get(ctx, WxTemplateGetAll, nil, &resp); err != nil {
+		return nil, fmt.Errorf("get all templates %w", err)
+	}
+	return resp.TemplateList, nil
+}
+
+// DeleteTemplate 删除模板
+func (s *Service) DeleteTemplate(templateID string) error {
+	return s.DeleteTemplateContext(context.Background(), templateID)
+}
+
+// DeleteTemplateContext 删除模板，ctx取消时中止请求
+func (s *Service) DeleteTemplateContext(ctx context.Context, templateID string) error {
+	req := struct {
+		TemplateID string `json:"template_id"`
+	}{templateID}
+	if err := s.c.PostJSON(ctx, WxTemplateDel, nil, req, nil); err != nil {
+		return fmt.Errorf("delete template %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"qingtao/weixin/mp/core"
+)
+
+func TestSend(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+WxTemplateSend {
+			t.Errorf("path %s", r.URL.Path)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &got)
+		fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","msgid":200228332}`)
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	msg := NewMessage("openid", "tid").SetMiniProgram("wxapp", "index?foo=bar")
+	msg.Data.Set("first", "订单已发货", "#173177").Set("remark", "谢谢", "")
+	msgid, err := s.Send(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgid != 200228332 {
+		t.Fatalf("msgid %d", msgid)
+	}
+	data := got["data"].(map[string]interface{})
+	first := data["first"].(map[string]interface{})
+	if got["touser"] != "openid" || first["color"] != "#173177" || got["miniprogram"].(map[string]interface{})["pagepath"] != "index?foo=bar" {
+		t.Fatalf("request %v", got)
+	}
+	if _, ok := data["remark"].(map[string]interface{})["color"]; ok {
+		t.Fatal("empty color should be omitted")
+	}
+
+	if _, err := s.Send(&Message{TemplateID: "tid"}); err == nil {
+		t.Fatal("missing touser")
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + WxTemplateGetAll:
+			fmt.Fprint(w, `{"template_list":[{"template_id":"tid","title":"领取奖金提醒","content":"{{result.DATA}}"}]}`)
+		case "/" + WxTemplateAdd:
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok","template_id":"new_tid"}`)
+		case "/" + WxTemplateGetIndustry:
+			fmt.Fprint(w, `{"primary_industry":{"first_class":"运输与仓储","second_class":"快递"}}`)
+		default:
+			fmt.Fprint(w, `{"errcode":40037,"errmsg":"invalid template_id"}`)
+		}
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	list, err := s.GetAllTemplates()
+	if err != nil || len(list) != 1 || list[0].TemplateID != "tid" {
+		t.Fatalf("templates %v %v", list, err)
+	}
+	if tid, err := s.AddTemplate("TM00015", "keyword1"); err != nil || tid != "new_tid" {
+		t.Fatalf("add %q %v", tid, err)
+	}
+	if industry, err := s.GetIndustry(); err != nil || industry.PrimaryIndustry.SecondClass != "快递" {
+		t.Fatalf("industry %v %v", industry, err)
+	}
+	if err := s.DeleteTemplate("bad"); core.ErrCode(err) != 40037 {
+		t.Fatalf("delete %v", err)
+	}
+}
@@ -0,0 +1,246 @@
+package mp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TokenFetcher 从微信服务器获取新的token，返回token和有效期(秒)
+type TokenFetcher func(ctx context.Context) (token string, expiresIn int, err error)
+
+const (
+	// DefaultTokenMargin 在access_token过期前提前刷新的时间
+	DefaultTokenMargin = 5 * time.Minute
+	// defaultFetchTimeout 单次获取token的超时时间
+	defaultFetchTimeout = 30 * time.Second
+	// defaultLockTTL 使用TokenStore时，刷新token持有锁的时间
+	defaultLockTTL = 2 * defaultFetchTimeout
+	// defaultPollInterval 其他进程正在刷新时，检查TokenStore的间隔
+	defaultPollInterval = 200 * time.Millisecond
+)
+
+// tokenCall 正在进行的一次刷新，同一时间只有一个刷新请求发往微信服务器
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// TokenManager 管理access_token，可以在多个goroutine中同时使用:
+//  1. 缓存token，在过期前Margin时间后台提前刷新;
+//  2. 同一时间的多个刷新请求合并为一次;
+//  3. 接口返回40001/42001/40014时，通过Refresh立即重新获取;
+//  4. 设置TokenStore后，多个进程中只有一个进程刷新token
+type TokenManager struct {
+	// Margin 提前刷新的时间，为0时使用DefaultTokenMargin，最多为token有效期的一半
+	Margin time.Duration
+
+	fetch TokenFetcher
+	// now 返回当前时间，测试时替换
+	now func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	call    *tokenCall
+	// invalid 最近一次被作废的token，不再从store中读取
+	invalid string
+	store   TokenStore
+	key     string
+	// lifetime 最近一次获取的token的有效期，用于限制Margin
+	lifetime time.Duration
+}
+
+// NewTokenManager 使用fetch创建*TokenManager
+func NewTokenManager(fetch TokenFetcher) *TokenManager {
+	return &TokenManager{fetch: fetch, now: time.Now}
+}
+
+// margin 返回提前刷新的时间，不超过token有效期的一半，避免有效期短于Margin时每次调用都刷新；
+// 调用时必须持有m.mu
+func (m *TokenManager) margin() time.Duration {
+	margin := m.Margin
+	if margin <= 0 {
+		margin = DefaultTokenMargin
+	}
+	if m.lifetime > 0 && margin > m.lifetime/2 {
+		margin = m.lifetime / 2
+	}
+	return margin
+}
+
+// Token 返回当前有效的token，实现core.TokenSource接口
+func (m *TokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	now := m.now()
+	if m.token != "" && now.Before(m.expires) {
+		token := m.token
+		// 进入提前刷新的时间段，后台刷新，仍然返回当前token
+		if !now.Before(m.expires.Add(-m.margin())) {
+			m.refreshLocked()
+		}
+		m.mu.Unlock()
+		return token, nil
+	}
+	c := m.refreshLocked()
+	m.mu.Unlock()
+	return m.wait(ctx, c)
+}
+
+// Refresh 作废old并重新获取token，实现core.TokenRefresher接口。
+// 如果old已经被其他goroutine替换为有效的token，直接返回新的token；
+// old为空时强制刷新
+func (m *TokenManager) Refresh(ctx context.Context, old string) (string, error) {
+	m.mu.Lock()
+	if old != "" && m.token != "" && m.token != old && m.now().Before(m.expires) {
+		token := m.token
+		m.mu.Unlock()
+		return token, nil
+	}
+	if old == "" {
+		old = m.token
+	}
+	if m.token == old {
+		m.token = ""
+	}
+	m.invalid = old
+	c := m.refreshLocked()
+	m.mu.Unlock()
+	return m.wait(ctx, c)
+}
+
+// SetStore 设置共享token的store和在store中使用的key，store为nil时直接向微信服务器获取
+func (m *TokenManager) SetStore(store TokenStore, key string) {
+	m.mu.Lock()
+	m.store, m.key = store, key
+	m.mu.Unlock()
+}
+
+// Expires 返回当前token的过期时间
+func (m *TokenManager) Expires() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.expires
+}
+
+// refreshLocked 开始一次刷新，如果已有刷新在进行中，返回进行中的刷新; 调用时必须持有m.mu
+func (m *TokenManager) refreshLocked() *tokenCall {
+	if m.call != nil {
+		return m.call
+	}
+	c := &tokenCall{done: make(chan struct{})}
+	m.call = c
+	go m.do(c)
+	return c
+}
+
+// do 执行刷新，不使用调用者的ctx，避免一个调用者取消导致其他等待者一起失败
+func (m *TokenManager) do(c *tokenCall) {
+	m.mu.Lock()
+	store, key, invalid := m.store, m.key, m.invalid
+	m.mu.Unlock()
+
+	var token string
+	var expires time.Time
+	var err error
+	if store == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultFetchTimeout)
+		token, expires, err = m.fetchToken(ctx)
+		cancel()
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultLockTTL)
+		token, expires, err = m.fetchShared(ctx, store, key, invalid)
+		cancel()
+	}
+
+	m.mu.Lock()
+	if err == nil {
+		m.token = token
+		m.expires = expires
+	}
+	c.token, c.err = token, err
+	m.call = nil
+	m.mu.Unlock()
+	close(c.done)
+}
+
+// fetchToken 调用fetch获取新的token
+func (m *TokenManager) fetchToken(ctx context.Context) (string, time.Time, error) {
+	token, expiresIn, err := m.fetch(ctx)
+	if err == nil && token == "" {
+		err = errors.New("fetch token: empty token")
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	lifetime := time.Duration(expiresIn) * time.Second
+	m.mu.Lock()
+	m.lifetime = lifetime
+	m.mu.Unlock()
+	return token, m.now().Add(lifetime), nil
+}
+
+// usable store中的token不是被作废的token，且没有进入提前刷新的时间段
+func (m *TokenManager) usable(token, invalid string, expires time.Time) bool {
+	m.mu.Lock()
+	margin := m.margin()
+	m.mu.Unlock()
+	return token != "" && token != invalid && m.now().Before(expires.Add(-margin))
+}
+
+// fetchShared 通过store与其他进程共享token: 先读取store，没有可用的token时取得锁后刷新，
+// 锁被其他进程持有时等待其刷新完成
+func (m *TokenManager) fetchShared(ctx context.Context, store TokenStore, key, invalid string) (string, time.Time, error) {
+	for {
+		token, expires, err := store.Get(ctx, key)
+		if err != nil && err != ErrTokenNotFound {
+			return "", time.Time{}, err
+		}
+		if err == nil && m.usable(token, invalid, expires) {
+			return token, expires, nil
+		}
+
+		lease, err := store.Lock(ctx, key, defaultLockTTL)
+		if err == nil {
+			return m.fetchLocked(ctx, store, key, lease, invalid)
+		}
+		if err != ErrLocked {
+			return "", time.Time{}, err
+		}
+		// 其他进程正在刷新，等待后重新读取
+		select {
+		case <-time.After(defaultPollInterval):
+		case <-ctx.Done():
+			return "", time.Time{}, ctx.Err()
+		}
+	}
+}
+
+// fetchLocked 持有锁时刷新token并保存到store
+func (m *TokenManager) fetchLocked(ctx context.Context, store TokenStore, key, lease, invalid string) (string, time.Time, error) {
+	defer store.Unlock(context.Background(), key, lease)
+	// 取得锁之前，其他进程可能刚刚完成刷新
+	token, expires, err := store.Get(ctx, key)
+	if err == nil && m.usable(token, invalid, expires) {
+		return token, expires, nil
+	}
+	if token, expires, err = m.fetchToken(ctx); err != nil {
+		return "", time.Time{}, err
+	}
+	if err = store.Set(ctx, key, token, expires); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// wait 等待刷新完成或者ctx结束
+func (m *TokenManager) wait(ctx context.Context, c *tokenCall) (string, error) {
+	select {
+	case <-c.done:
+		return c.token, c.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,314 @@
+package mp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTokenNotFound TokenStore中没有保存token，或者token已经过期
+	ErrTokenNotFound = errors.New("token store: token not found")
+	// ErrLocked 锁已经被其他持有者取得
+	ErrLocked = errors.New("token store: locked")
+)
+
+// TokenStore 保存token，供多个进程共享。多个进程使用同一个TokenStore和key时，
+// 只有取得锁的进程向微信服务器刷新token，其他进程等待并读取刷新后的token，
+// 避免互相作废对方刚获取的access_token。
+//
+// 实现TokenStore需要满足以下约定:
+//  1. Get返回Set保存的token和过期时间，没有保存或者已经过期时返回ErrTokenNotFound;
+//  2. Lock对同一个key在ttl时间内只允许一个持有者，返回的lease标识持有者，
+//     锁已经被持有时返回ErrLocked; 持有者异常退出时，锁在ttl到期后自动失效;
+//  3. Unlock只释放lease对应的锁，锁已经过期并被其他持有者取得时，不能释放其他持有者的锁;
+//  4. 所有方法都可以被多个goroutine同时调用，ctx结束时应尽快返回
+type TokenStore interface {
+	// Get 读取key对应的token和过期时间
+	Get(ctx context.Context, key string) (token string, expires time.Time, err error)
+	// Set 保存key对应的token和过期时间
+	Set(ctx context.Context, key, token string, expires time.Time) error
+	// Lock 取得key对应的锁，持续时间ttl
+	Lock(ctx context.Context, key string, ttl time.Duration) (lease string, err error)
+	// Unlock 释放lease持有的锁
+	Unlock(ctx context.Context, key, lease string) error
+}
+
+// newLease 生成随机的锁持有者标识
+func newLease() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("token store: new lease %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// storedToken TokenStore中保存的token
+type storedToken struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// storedLock TokenStore中保存的锁
+type storedLock struct {
+	Lease   string    `json:"lease"`
+	Expires time.Time `json:"expires"`
+}
+
+// MemoryTokenStore 保存在内存中的TokenStore，只能在同一个进程中共享，
+// 例如多个*WeiXin使用同一个公众号时
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]storedToken
+	locks  map[string]storedLock
+}
+
+// NewMemoryTokenStore 创建*MemoryTokenStore
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string]storedToken),
+		locks:  make(map[string]storedLock),
+	}
+}
+
+// Get 实现TokenStore接口
+func (s *MemoryTokenStore) Get(ctx context.Context, key string) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[key]
+	if !ok || !time.Now().Before(t.Expires) {
+		return "", time.Time{}, ErrTokenNotFound
+	}
+	return t.Token, t.Expires, nil
+}
+
+// Set 实现TokenStore接口
+func (s *MemoryTokenStore) Set(ctx context.Context, key, token string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = storedToken{token, expires}
+	return nil
+}
+
+// Lock 实现TokenStore接口
+func (s *MemoryTokenStore) Lock(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	lease, err := newLease()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.locks[key]; ok && now.Before(l.Expires) {
+		return "", ErrLocked
+	}
+	s.locks[key] = storedLock{lease, now.Add(ttl)}
+	return lease, nil
+}
+
+// Unlock 实现TokenStore接口
+func (s *MemoryTokenStore) Unlock(ctx context.Context, key, lease string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.locks[key]; ok && l.Lease == lease {
+		delete(s.locks, key)
+	}
+	return nil
+}
+
+// FileTokenStore 保存在目录中的TokenStore，同一台主机上共享目录的多个进程可以使用，
+// 每个key对应目录中的key.json和key.lock两个文件，key需要是合法的文件名
+type FileTokenStore struct {
+	// Dir 保存文件的目录
+	Dir string
+}
+
+// NewFileTokenStore 创建*FileTokenStore，dir不存在时创建
+func NewFileTokenStore(dir string) (*FileTokenStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("token store: %s", err)
+	}
+	return &FileTokenStore{Dir: dir}, nil
+}
+
+func (s *FileTokenStore) path(key, ext string) string {
+	return filepath.Join(s.Dir, filepath.Base(key)+ext)
+}
+
+// Get 实现TokenStore接口
+func (s *FileTokenStore) Get(ctx context.Context, key string) (string, time.Time, error) {
+	b, err := ioutil.ReadFile(s.path(key, ".json"))
+	if os.IsNotExist(err) {
+		return "", time.Time{}, ErrTokenNotFound
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("token store: %s", err)
+	}
+	var t storedToken
+	if err = json.Unmarshal(b, &t); err != nil {
+		return "", time.Time{}, fmt.Errorf("token store: read %s: %s", key, err)
+	}
+	if t.Token == "" || !time.Now().Before(t.Expires) {
+		return "", time.Time{}, ErrTokenNotFound
+	}
+	return t.Token, t.Expires, nil
+}
+
+// Set 实现TokenStore接口，先写入临时文件再重命名，读取者不会读到写了一半的文件
+func (s *FileTokenStore) Set(ctx context.Context, key, token string, expires time.Time) error {
+	b, err := json.Marshal(storedToken{token, expires})
+	if err != nil {
+		return err
+	}
+	tmp, err := s.writeTemp(key, b)
+	if err != nil {
+		return fmt.Errorf("token store: write %s: %s", key, err)
+	}
+	if err = os.Rename(tmp, s.path(key, ".json")); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("token store: write %s: %s", key, err)
+	}
+	return nil
+}
+
+// readLock 读取锁文件，同时返回文件信息，用于确认重命名的是同一个文件
+func (s *FileTokenStore) readLock(key string) (*storedLock, os.FileInfo, error) {
+	f, err := os.Open(s.path(key, ".lock"))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fi, err
+	}
+	var l storedLock
+	if err = json.Unmarshal(b, &l); err != nil {
+		return nil, fi, err
+	}
+	return &l, fi, nil
+}
+
+// writeTemp 将b写入目录中的临时文件，返回文件名
+func (s *FileTokenStore) writeTemp(key string, b []byte) (string, error) {
+	f, err := ioutil.TempFile(s.Dir, filepath.Base(key)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Lock 实现TokenStore接口。先将lease写入临时文件，再使用os.Link放到锁文件的位置，
+// 锁文件一旦存在内容就是完整的。锁文件无法解析时，按照修改时间加ttl判断是否过期。
+// 过期的锁先重命名为临时文件，确认重命名的正是判断为过期的文件后再删除，
+// 重命名了其他持有者刚创建的锁时放回原处
+func (s *FileTokenStore) Lock(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	lease, err := newLease()
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(storedLock{lease, time.Now().Add(ttl)})
+	if err != nil {
+		return "", err
+	}
+	tmp, err := s.writeTemp(key, b)
+	if err != nil {
+		return "", fmt.Errorf("token store: lock %s: %s", key, err)
+	}
+	defer os.Remove(tmp)
+	name := s.path(key, ".lock")
+	for i := 0; i < 3; i++ {
+		err := os.Link(tmp, name)
+		if err == nil {
+			return lease, nil
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("token store: lock %s: %s", key, err)
+		}
+		l, fi, err := s.readLock(key)
+		if os.IsNotExist(err) {
+			// 锁文件刚被释放，直接重试
+			continue
+		}
+		if fi == nil {
+			return "", fmt.Errorf("token store: lock %s: %s", key, err)
+		}
+		expires := fi.ModTime().Add(ttl)
+		if err == nil {
+			expires = l.Expires
+		}
+		if time.Now().Before(expires) {
+			return "", ErrLocked
+		}
+		if err := s.removeLock(name, lease, fi); err != nil {
+			return "", fmt.Errorf("token store: lock %s: %s", key, err)
+		}
+	}
+	return "", ErrLocked
+}
+
+// removeLock 删除锁文件name，stale是判断可以删除时的文件信息，
+// 锁文件已经被其他持有者替换时不删除
+func (s *FileTokenStore) removeLock(name, lease string, stale os.FileInfo) error {
+	if fi, err := os.Stat(name); err != nil || !os.SameFile(fi, stale) {
+		return nil
+	}
+	moved := name + ".stale." + lease
+	if err := os.Rename(name, moved); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	fi, err := os.Stat(moved)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(fi, stale) {
+		return os.Remove(moved)
+	}
+	// 检查和重命名之间其他持有者替换了锁文件，将它的锁放回原处
+	if err := os.Link(moved, name); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return os.Remove(moved)
+}
+
+// Unlock 实现TokenStore接口
+func (s *FileTokenStore) Unlock(ctx context.Context, key, lease string) error {
+	l, fi, err := s.readLock(key)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("token store: unlock %s: %s", key, err)
+	}
+	if l.Lease != lease {
+		return nil
+	}
+	if err = s.removeLock(s.path(key, ".lock"), lease, fi); err != nil {
+		return fmt.Errorf("token store: unlock %s: %s", key, err)
+	}
+	return nil
+}
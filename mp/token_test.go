@@ -0,0 +1,291 @@
+package mp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenManager(t *testing.T) {
+	var fetched int32
+	fetch := func(ctx context.Context) (string, int, error) {
+		n := atomic.AddInt32(&fetched, 1)
+		time.Sleep(10 * time.Millisecond)
+		return fmt.Sprintf("token-%d", n), 7200, nil
+	}
+
+	t.Run("singleflight", func(t *testing.T) {
+		atomic.StoreInt32(&fetched, 0)
+		m := NewTokenManager(fetch)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := m.Token(context.Background())
+				if err != nil || token != "token-1" {
+					t.Errorf("Token() = %s, %v", token, err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := atomic.LoadInt32(&fetched); n != 1 {
+			t.Fatalf("fetched %d times, want 1", n)
+		}
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		atomic.StoreInt32(&fetched, 0)
+		m := NewTokenManager(fetch)
+		old, err := m.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := m.Refresh(context.Background(), old)
+		if err != nil || token != "token-2" {
+			t.Fatalf("Refresh() = %s, %v", token, err)
+		}
+		// old已经被替换，不再重新获取
+		token, err = m.Refresh(context.Background(), old)
+		if err != nil || token != "token-2" {
+			t.Fatalf("Refresh() again = %s, %v", token, err)
+		}
+		if n := atomic.LoadInt32(&fetched); n != 2 {
+			t.Fatalf("fetched %d times, want 2", n)
+		}
+	})
+
+	t.Run("proactive", func(t *testing.T) {
+		atomic.StoreInt32(&fetched, 0)
+		now := time.Now()
+		m := NewTokenManager(fetch)
+		m.now = func() time.Time { return now }
+		if _, err := m.Token(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// 进入提前刷新时间段时仍然返回旧token，同时后台刷新
+		now = now.Add(7200*time.Second - DefaultTokenMargin)
+		token, err := m.Token(context.Background())
+		if err != nil || token != "token-1" {
+			t.Fatalf("Token() = %s, %v", token, err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if token, _ = m.Token(context.Background()); token == "token-2" {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("token not refreshed in background, got %s", token)
+	})
+
+	t.Run("short expires_in", func(t *testing.T) {
+		var fetched int32
+		fetch := func(ctx context.Context) (string, int, error) {
+			n := atomic.AddInt32(&fetched, 1)
+			return fmt.Sprintf("token-%d", n), 60, nil
+		}
+		var mu sync.Mutex
+		now := time.Now()
+		m := NewTokenManager(fetch)
+		m.now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}
+		advance := func(d time.Duration) {
+			mu.Lock()
+			now = now.Add(d)
+			mu.Unlock()
+		}
+		if _, err := m.Token(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// 有效期60秒短于DefaultTokenMargin，在过期前30秒才刷新，而不是每次调用都刷新
+		advance(10 * time.Second)
+		for i := 0; i < 10; i++ {
+			if token, err := m.Token(context.Background()); err != nil || token != "token-1" {
+				t.Fatalf("Token() = %s, %v", token, err)
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		if n := atomic.LoadInt32(&fetched); n != 1 {
+			t.Fatalf("fetched %d times, want 1", n)
+		}
+		advance(25 * time.Second)
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if token, _ := m.Token(context.Background()); token == "token-2" {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("token not refreshed in background")
+	})
+}
+
+func TestTokenManagerStore(t *testing.T) {
+	var fetched int32
+	fetch := func(ctx context.Context) (string, int, error) {
+		n := atomic.AddInt32(&fetched, 1)
+		time.Sleep(20 * time.Millisecond)
+		return fmt.Sprintf("token-%d", n), 7200, nil
+	}
+	dir, err := ioutil.TempDir("", "token_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileStore, err := NewFileTokenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]TokenStore{
+		"memory": NewMemoryTokenStore(),
+		"file":   fileStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			atomic.StoreInt32(&fetched, 0)
+			// 模拟多个进程，每个进程有自己的TokenManager
+			managers := make([]*TokenManager, 5)
+			for i := range managers {
+				managers[i] = NewTokenManager(fetch)
+				managers[i].SetStore(store, "access_token_"+name)
+			}
+			var wg sync.WaitGroup
+			for _, m := range managers {
+				wg.Add(1)
+				go func(m *TokenManager) {
+					defer wg.Done()
+					token, err := m.Token(context.Background())
+					if err != nil || token != "token-1" {
+						t.Errorf("Token() = %s, %v", token, err)
+					}
+				}(m)
+			}
+			wg.Wait()
+			if n := atomic.LoadInt32(&fetched); n != 1 {
+				t.Fatalf("fetched %d times, want 1", n)
+			}
+
+			// access_token失效后，store中的旧token不能再使用
+			token, err := managers[0].Refresh(context.Background(), "token-1")
+			if err != nil || token != "token-2" {
+				t.Fatalf("Refresh() = %s, %v", token, err)
+			}
+			token, err = managers[1].Refresh(context.Background(), "token-1")
+			if err != nil || token != "token-2" {
+				t.Fatalf("Refresh() by other = %s, %v", token, err)
+			}
+			if n := atomic.LoadInt32(&fetched); n != 2 {
+				t.Fatalf("fetched %d times, want 2", n)
+			}
+		})
+	}
+}
+
+func TestFileTokenStoreLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileTokenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	lease, err := store.Lock(ctx, "key", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Lock(ctx, "key", time.Minute); err != ErrLocked {
+		t.Fatalf("Lock() second time error = %v, want ErrLocked", err)
+	}
+	// 其他持有者不能释放锁
+	if err = store.Unlock(ctx, "key", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Lock(ctx, "key", time.Minute); err != ErrLocked {
+		t.Fatalf("Lock() after other unlock error = %v, want ErrLocked", err)
+	}
+	if err = store.Unlock(ctx, "key", lease); err != nil {
+		t.Fatal(err)
+	}
+	// 过期的锁可以重新取得
+	if _, err = store.Lock(ctx, "key", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Lock(ctx, "key", time.Minute); err != nil {
+		t.Fatalf("Lock() expired error = %v", err)
+	}
+}
+
+func TestFileTokenStoreLockConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileTokenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	name := store.path("key", ".lock")
+
+	// 内容无法解析的锁文件在修改时间加ttl之前仍然有效
+	if err := ioutil.WriteFile(name, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lock(ctx, "key", time.Minute); err != ErrLocked {
+		t.Fatalf("Lock() with empty lock file error = %v, want ErrLocked", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(name, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// 从过期的锁文件开始，多个goroutine同时争抢，任何时候最多一个持有者
+	var holders, acquired int32
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				lease, err := store.Lock(ctx, "key", time.Minute)
+				if err == ErrLocked {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n := atomic.AddInt32(&holders, 1); n != 1 {
+					t.Errorf("%d holders at the same time", n)
+				}
+				atomic.AddInt32(&acquired, 1)
+				time.Sleep(100 * time.Microsecond)
+				atomic.AddInt32(&holders, -1)
+				if err := store.Unlock(ctx, "key", lease); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if acquired == 0 {
+		t.Fatal("lock never acquired")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Fatalf("%d files left in store dir", len(files))
+	}
+}
@@ -0,0 +1,199 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// WxUserGet 获取关注者列表API
+const WxUserGet = "cgi-bin/user/get"
+
+const (
+	// MaxFollowersPerPage 获取关注者列表时每次最多返回的openid数量
+	MaxFollowersPerPage = 10000
+	// MaxUsersInfoBatch 批量获取用户基本信息时每次最多的用户数量
+	MaxUsersInfoBatch = 100
+)
+
+// Followers 关注者列表的一页
+type Followers struct {
+	// Total 关注者总数
+	Total int `json:"total"`
+	// Count 这一页的openid数量
+	Count int `json:"count"`
+	// Data 这一页的openid
+	Data *Data `json:"data,omitempty"`
+	// NextOpenID 这一页最后一个openid，作为next获取下一页
+	NextOpenID string `json:"next_openid"`
+}
+
+// GetFollowers 获取next之后的关注者，next为空时从头开始拉取，每次最多MaxFollowersPerPage个
+func (s *Service) GetFollowers(next string) (*Followers, error) {
+	return s.GetFollowersContext(context.Background(), next)
+}
+
+// GetFollowersContext 获取next之后的关注者，next为空时从头开始拉取，每次最多MaxFollowersPerPage个，
+// ctx取消时中止请求
+func (s *Service) GetFollowersContext(ctx context.Context, next string) (*Followers, error) {
+	var query url.Values
+	if next != "" {
+		query = url.Values{"next_openid": {next}}
+	}
+	var f Followers
+	if err := s.c.Get(ctx, WxUserGet, query, &f); err != nil {
+		return nil, fmt.Errorf("get followers after %q %w", next, err)
+	}
+	return &f, nil
+}
+
+// FollowerIterator 逐个遍历关注者或者黑名单的openid，需要时才拉取下一页，不能被多个goroutine同时使用。
+// 用法：
+//
+//	it := s.Followers("")
+//	for it.Next(ctx) {
+//		openid := it.OpenID()
+//	}
+//	if err := it.Err(); err != nil {
+//		// 使用it.Cursor()保存进度，稍后s.Followers(cursor)继续
+//	}
+type FollowerIterator struct {
+	// list 拉取next之后的一页
+	list func(ctx context.Context, next string) (*Followers, error)
+	// next 拉取下一页使用的next_openid
+	next string
+	// cursor 最近一次返回的openid
+	cursor string
+	page   []string
+	total  int
+	done   bool
+	err    error
+}
+
+// Followers 返回从cursor之后开始遍历关注者的*FollowerIterator，cursor为空时从头开始，
+// 中断后使用FollowerIterator.Cursor()的返回值继续
+func (s *Service) Followers(cursor string) *FollowerIterator {
+	return &FollowerIterator{list: s.GetFollowersContext, next: cursor, cursor: cursor, total: -1}
+}
+
+// fetch 拉取下一页
+func (it *FollowerIterator) fetch(ctx context.Context) bool {
+	f, err := it.list(ctx, it.next)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.total = f.Total
+	if f.Count == 0 || f.Data == nil || len(f.Data.OpenID) == 0 {
+		it.done = true
+		return false
+	}
+	it.page = f.Data.OpenID
+	it.next = f.NextOpenID
+	if it.next == "" {
+		it.next = it.page[len(it.page)-1]
+	}
+	return true
+}
+
+// Next 移动到下一个关注者，没有更多关注者或者出错时返回false
+func (it *FollowerIterator) Next(ctx context.Context) bool {
+	if it.err != nil || it.done {
+		return false
+	}
+	if len(it.page) == 0 && !it.fetch(ctx) {
+		return false
+	}
+	it.cursor = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// NextBatch 返回接下来最多n个openid，没有更多关注者或者出错时返回空，出错时通过Err获取错误
+func (it *FollowerIterator) NextBatch(ctx context.Context, n int) []string {
+	var batch []string
+	for len(batch) < n && it.Next(ctx) {
+		batch = append(batch, it.cursor)
+	}
+	return batch
+}
+
+// OpenID 返回当前关注者的openid
+func (it *FollowerIterator) OpenID() string {
+	return it.cursor
+}
+
+// Cursor 返回已经遍历的最后一个openid，作为Followers的参数继续遍历
+func (it *FollowerIterator) Cursor() string {
+	return it.cursor
+}
+
+// Total 返回关注者或者黑名单的总数，拉取第一页之前返回-1
+func (it *FollowerIterator) Total() int {
+	return it.total
+}
+
+// Err 返回遍历中出现的错误
+func (it *FollowerIterator) Err() error {
+	return it.err
+}
+
+// CursorError EachUsersInfo拉取关注者列表或者用户基本信息失败时返回的错误，
+// Cursor是最后一次成功交给f的cursor，还没有调用f时是传入的cursor，使用它继续遍历不会遗漏关注者
+type CursorError struct {
+	// Cursor 继续遍历使用的cursor
+	Cursor string
+	// Err 接口调用的错误
+	Err error
+}
+
+// Error 实现error接口
+func (e *CursorError) Error() string {
+	return fmt.Sprintf("each users info after %q %v", e.Cursor, e.Err)
+}
+
+// Unwrap 返回接口调用的错误
+func (e *CursorError) Unwrap() error {
+	return e.Err
+}
+
+// EachUsersInfo 从cursor之后开始遍历关注者，每MaxUsersInfoBatch个openid批量获取一次用户基本信息并调用f，
+// f的cursor是这一批最后一个openid，可以保存下来在中断后继续。
+// f返回错误时停止遍历并原样返回这个错误，这一批需要从上一次f的cursor重新开始；
+// 接口调用失败时返回*CursorError，使用它的Cursor继续
+func (s *Service) EachUsersInfo(ctx context.Context, cursor, lang string, f func(users []*User, cursor string) error) error {
+	it := s.Followers(cursor)
+	for {
+		// 拉取下一页失败时NextBatch返回已经取得的openid，先处理完这一批，下一次循环再返回错误
+		batch := it.NextBatch(ctx, MaxUsersInfoBatch)
+		if len(batch) == 0 {
+			if err := it.Err(); err != nil {
+				return &CursorError{Cursor: cursor, Err: err}
+			}
+			return nil
+		}
+		list := make([]*Item, len(batch))
+		for i, openid := range batch {
+			list[i] = &Item{OpenID: openid, Lang: lang}
+		}
+		users, err := s.GetUsersInfoContext(ctx, list)
+		if err != nil {
+			return &CursorError{Cursor: cursor, Err: err}
+		}
+		next := it.Cursor()
+		if err := f(users.UserInfoList, next); err != nil {
+			return err
+		}
+		cursor = next
+	}
+}
+
+// GetFollowers 获取next之后的关注者，next为空时从头开始拉取
+func GetFollowers(host, accessToken, next string) (*Followers, error) {
+	return newService(host, accessToken).GetFollowers(next)
+}
+
+// GetFollowersContext 获取next之后的关注者，next为空时从头开始拉取，ctx取消时中止请求
+func GetFollowersContext(ctx context.Context, host, accessToken, next string) (*Followers, error) {
+	return newService(host, accessToken).GetFollowersContext(ctx, next)
+}
@@ -0,0 +1,156 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"qingtao/weixin/mp/core"
+)
+
+// followersServer 模拟n个关注者，每页pageSize个openid
+func followersServer(t *testing.T, n, pageSize int) *httptest.Server {
+	openids := make([]string, n)
+	for i := range openids {
+		openids[i] = fmt.Sprintf("openid%05d", i)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + WxUserGet:
+			start := 0
+			if next := r.URL.Query().Get("next_openid"); next != "" {
+				i, _ := strconv.Atoi(next[len("openid"):])
+				start = i + 1
+			}
+			end := start + pageSize
+			if end > n {
+				end = n
+			}
+			f := Followers{Total: n, Count: end - start}
+			if f.Count > 0 {
+				f.Data = &Data{OpenID: openids[start:end]}
+				f.NextOpenID = openids[end-1]
+			}
+			json.NewEncoder(w).Encode(f)
+		case "/" + WxUsersInfoPath:
+			var req struct {
+				UserList []*Item `json:"user_list"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if len(req.UserList) > MaxUsersInfoBatch {
+				fmt.Fprint(w, `{"errcode":45065,"errmsg":"too many users"}`)
+				return
+			}
+			var users Users
+			for _, item := range req.UserList {
+				users.UserInfoList = append(users.UserInfoList, &User{Subscribe: 1, OpenID: item.OpenID})
+			}
+			json.NewEncoder(w).Encode(users)
+		}
+	}))
+}
+
+func TestFollowerIterator(t *testing.T) {
+	srv := followersServer(t, 25, 10)
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+	ctx := context.Background()
+
+	it := s.Followers("")
+	var got []string
+	for it.Next(ctx) {
+		got = append(got, it.OpenID())
+		if len(got) == 12 {
+			break
+		}
+	}
+	// 从中断的位置继续
+	it = s.Followers(it.Cursor())
+	for it.Next(ctx) {
+		got = append(got, it.OpenID())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 25 || got[12] != "openid00012" || got[24] != "openid00024" || it.Total() != 25 {
+		t.Fatalf("got %d openids: %v, total %d", len(got), got, it.Total())
+	}
+}
+
+func TestEachUsersInfo(t *testing.T) {
+	srv := followersServer(t, 250, MaxFollowersPerPage)
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	var batches []int
+	var cursors []string
+	err := s.EachUsersInfo(context.Background(), "", "zh_CN", func(users []*User, cursor string) error {
+		batches = append(batches, len(users))
+		cursors = append(cursors, cursor)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(batches) != "[100 100 50]" || cursors[0] != "openid00099" || cursors[2] != "openid00249" {
+		t.Fatalf("batches %v cursors %v", batches, cursors)
+	}
+
+	stop := errors.New("stop")
+	err = s.EachUsersInfo(context.Background(), "openid00199", "", func(users []*User, cursor string) error {
+		if len(users) != 50 || users[0].OpenID != "openid00200" {
+			t.Errorf("resumed batch %d %s", len(users), users[0].OpenID)
+		}
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func TestEachUsersInfoError(t *testing.T) {
+	backend := followersServer(t, 250, MaxFollowersPerPage)
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	// 第二批获取用户信息失败
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+WxUsersInfoPath && atomic.AddInt32(&calls, 1) == 2 {
+			fmt.Fprint(w, `{"errcode":45009,"errmsg":"reach max api daily quota limit"}`)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	var last string
+	err := s.EachUsersInfo(context.Background(), "", "", func(users []*User, cursor string) error {
+		last = cursor
+		return nil
+	})
+	var cerr *CursorError
+	if !errors.As(err, &cerr) || cerr.Cursor != "openid00099" || cerr.Cursor != last || core.ErrCode(err) != 45009 {
+		t.Fatalf("err %v", err)
+	}
+
+	var first string
+	err = s.EachUsersInfo(context.Background(), cerr.Cursor, "", func(users []*User, cursor string) error {
+		if first == "" {
+			first = users[0].OpenID
+		}
+		return nil
+	})
+	if err != nil || first != "openid00100" {
+		t.Fatalf("resume from %s: first %s, err %v", cerr.Cursor, first, err)
+	}
+}
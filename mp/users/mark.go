@@ -1,33 +1,34 @@
 package users
 
-import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strings"
-)
+import "context"
 
 // WxUserUpdaterMark 设置用户备注
 const WxUserUpdaterMark = "cgi-bin/user/info/updateremark"
 
 // MarkUser 备注用户, openid 是用户标识符，根据微信公众平台的api说明，remark不能超过30个字符
-func MarkUser(host, accessToken, openid, remark string) (*Response, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, WxUserUpdaterMark, accessToken)
-	JSON := `{"openid":"` + openid + `","remark":"` + remark + `"}`
-	res, err := http.Post(URL, JSONContentType, strings.NewReader(JSON))
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+func (s *Service) MarkUser(openid, remark string) (*Response, error) {
+	return s.MarkUserContext(context.Background(), openid, remark)
+}
 
+// MarkUserContext 备注用户, openid 是用户标识符，根据微信公众平台的api说明，remark不能超过30个字符，ctx取消时中止请求
+func (s *Service) MarkUserContext(ctx context.Context, openid, remark string) (*Response, error) {
+	mark := struct {
+		OpenID string `json:"openid"`
+		Remark string `json:"remark"`
+	}{openid, remark}
 	var resp Response
-	if err = json.Unmarshal(b, &resp); err != nil {
+	if err := s.c.QueryJSON(ctx, WxUserUpdaterMark, nil, mark, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
+
+// MarkUser 备注用户, openid 是用户标识符，根据微信公众平台的api说明，remark不能超过30个字符
+func MarkUser(host, accessToken, openid, remark string) (*Response, error) {
+	return newService(host, accessToken).MarkUser(openid, remark)
+}
+
+// MarkUserContext 备注用户, openid 是用户标识符，根据微信公众平台的api说明，remark不能超过30个字符，ctx取消时中止请求
+func MarkUserContext(ctx context.Context, host, accessToken, openid, remark string) (*Response, error) {
+	return newService(host, accessToken).MarkUserContext(ctx, openid, remark)
+}
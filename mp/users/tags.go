@@ -3,17 +3,14 @@ package users
 // 2018/03/18
 // TODO: 添加完成用户管理后，开始实际测试各API调用情况
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"strings"
 )
 
 // Response 只包含errcode和errmsg的响应信息
 type Response struct {
-	ErrCode uint32 `json:"errcode,omitempty"`
+	ErrCode int    `json:"errcode,omitempty"`
 	ErrMsg  string `json:"errmsg,omitempty"`
 }
 
@@ -26,7 +23,7 @@ const WxTagsCreate = `cgi-bin/tags/create`
 // TagResponse 创建标签时返回的响应结构
 type TagResponse struct {
 	Tag     *Tag   `json:"tag,omitempty"`
-	ErrCode uint32 `json:"errcode,omitempty"`
+	ErrCode int    `json:"errcode,omitempty"`
 	ErrMsg  string `json:"errmsg,omitempty"`
 }
 
@@ -36,35 +33,26 @@ type Tag struct {
 	Name string `json:"name,omitempty"`
 }
 
-// changeTag 修改标签
-func changeTag(host, accessToken, action, tagname string, id int) (*TagResponse, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, action, accessToken)
-	JSON := fmt.Sprintf(`{"tag":{"id":%d,name":"%s"}}`, id, tagname)
-	if tagname == "" {
-		JSON = fmt.Sprintf(`{"tag":{"id":%d}}`, id)
-	} else if id < 3 {
-		JSON = fmt.Sprintf(`{"tag":{"name":"%s"}}`, tagname)
-	}
-	res, err := http.Post(URL, JSONContentType, strings.NewReader(JSON))
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
+// changeTag 修改标签，创建时id为0，删除时tagname为空
+func (s *Service) changeTag(ctx context.Context, action, tagname string, id int) (*TagResponse, error) {
+	req := struct {
+		Tag *Tag `json:"tag"`
+	}{&Tag{uint32(id), tagname}}
 	var tres TagResponse
-	if err = json.Unmarshal(b, &tres); err != nil {
+	if err := s.c.PostJSON(ctx, action, nil, req, &tres); err != nil {
 		return nil, err
 	}
 	return &tres, nil
 }
 
 // CreateTag 添加标签
-func CreateTag(host, accessToken, tagname string) (*TagResponse, error) {
-	return changeTag(host, accessToken, WxTagsCreate, tagname, 0)
+func (s *Service) CreateTag(tagname string) (*TagResponse, error) {
+	return s.CreateTagContext(context.Background(), tagname)
+}
+
+// CreateTagContext 添加标签，ctx取消时中止请求
+func (s *Service) CreateTagContext(ctx context.Context, tagname string) (*TagResponse, error) {
+	return s.changeTag(ctx, WxTagsCreate, tagname, 0)
 }
 
 // WxTagsGet 获取已创建的标签API
@@ -73,23 +61,19 @@ const WxTagsGet = "cgi-bin/tags/get"
 // TagsResponse 获取标签列表时的响应
 type TagsResponse struct {
 	Tags    []*Tag `json:"tags,omitempty"`
-	ErrCode uint32 `json:"errcode,omitempty"`
+	ErrCode int    `json:"errcode,omitempty"`
 	ErrMsg  string `json:"errmsg,omitempty"`
 }
 
 // GetTags 获取已有标签
-func GetTags(host, accessToken string) (*TagsResponse, error) {
-	res, err := http.Get(fmt.Sprintf("https://%s/%s?access_token=%s", host, WxTagsGet, accessToken))
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+func (s *Service) GetTags() (*TagsResponse, error) {
+	return s.GetTagsContext(context.Background())
+}
+
+// GetTagsContext 获取已有标签，ctx取消时中止请求
+func (s *Service) GetTagsContext(ctx context.Context) (*TagsResponse, error) {
 	var tsres TagsResponse
-	if err = json.Unmarshal(b, &tsres); err != nil {
+	if err := s.c.Get(ctx, WxTagsGet, nil, &tsres); err != nil {
 		return nil, err
 	}
 	return &tsres, nil
@@ -99,31 +83,43 @@ func GetTags(host, accessToken string) (*TagsResponse, error) {
 const WxTagsUpdate = "cgi-bin/tags/update"
 
 // UpdateTag 修改标签，id不能是0/1/2
-func UpdateTag(host, accessToken, tagname string, id int) (*TagResponse, error) {
+func (s *Service) UpdateTag(tagname string, id int) (*TagResponse, error) {
+	return s.UpdateTagContext(context.Background(), tagname, id)
+}
+
+// UpdateTagContext 修改标签，id不能是0/1/2，ctx取消时中止请求
+func (s *Service) UpdateTagContext(ctx context.Context, tagname string, id int) (*TagResponse, error) {
 	// 检查id是否大于等于3，实际是微信公众平台会返回错误代码:45058
 	if id < 3 {
 		return nil, fmt.Errorf("ID of tag must be greater or equal to three, but it is %d", id)
 	}
-	return changeTag(host, accessToken, WxTagsUpdate, tagname, id)
+	return s.changeTag(ctx, WxTagsUpdate, tagname, id)
 }
 
 // WxTagsDelete 删除标签API
 const WxTagsDelete = "cgi-bin/tags/delete"
 
 // DeleteTag 删除标签
-func DeleteTag(host, accessToken string, id int) (*TagResponse, error) {
+func (s *Service) DeleteTag(id int) (*TagResponse, error) {
+	return s.DeleteTagContext(context.Background(), id)
+}
+
+// DeleteTagContext 删除标签，ctx取消时中止请求
+func (s *Service) DeleteTagContext(ctx context.Context, id int) (*TagResponse, error) {
 	// 检查id是否大于等于3，实际是微信公众平台会返回错误代码:45058
 	if id < 3 {
 		return nil, fmt.Errorf("ID of tag must be greater or equal to three, but it is %d", id)
 	}
-	return changeTag(host, accessToken, WxTagsDelete, "", id)
+	return s.changeTag(ctx, WxTagsDelete, "", id)
 }
 
 // UserOfTag 标签下的粉丝列表
 type UserOfTag struct {
-	Count      uint32  `json:"count,omitempty"`
-	Data       []*Data `json:"data,omitempty"`
-	NextOpenID string  `json:"next_openid,omitempty"`
+	Count      uint32 `json:"count,omitempty"`
+	Data       *Data  `json:"data,omitempty"`
+	NextOpenID string `json:"next_openid,omitempty"`
+	ErrCode    int    `json:"errcode,omitempty"`
+	ErrMsg     string `json:"errmsg,omitempty"`
 }
 
 // Data in UsersOfTag
@@ -134,32 +130,26 @@ type Data struct {
 // WxGetTagUsers 获取标签下粉丝列表API
 const WxGetTagUsers = "cgi-bin/user/tag/get"
 
-// GetUsersOfTag 获取标签下的用户
-func GetUsersOfTag(host, accessToken, next string, id int) (*UserOfTag, error) {
-	JSON := fmt.Sprintf(`{"next_openid":"%s","tagid":%d}`, next, id)
-	if next == "" {
-		JSON = fmt.Sprintf(`{"tagid":%d}`, id)
-	}
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, WxGetTagUsers, accessToken)
-	res, err := http.Post(URL, JSONContentType, strings.NewReader(JSON))
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+// GetUsersOfTag 获取标签下的用户，next为空时从头开始拉取
+func (s *Service) GetUsersOfTag(next string, id int) (*UserOfTag, error) {
+	return s.GetUsersOfTagContext(context.Background(), next, id)
+}
 
+// GetUsersOfTagContext 获取标签下的用户，next为空时从头开始拉取，ctx取消时中止请求
+func (s *Service) GetUsersOfTagContext(ctx context.Context, next string, id int) (*UserOfTag, error) {
+	req := struct {
+		TagID      int    `json:"tagid"`
+		NextOpenID string `json:"next_openid,omitempty"`
+	}{id, next}
 	var users UserOfTag
-	if err = json.Unmarshal(b, &users); err != nil {
+	if err := s.c.QueryJSON(ctx, WxGetTagUsers, nil, req, &users); err != nil {
 		return nil, err
 	}
 	return &users, nil
 }
 
 // WxBatchTagging 批量打标签API
-const WxBatchTagging = "tags/members/batchtagging"
+const WxBatchTagging = "cgi-bin/tags/members/batchtagging"
 
 // BatchTag 提交的批量打标签数据
 type BatchTag struct {
@@ -168,67 +158,261 @@ type BatchTag struct {
 }
 
 // batchTagging 批量操作标签
-func batchTagging(host, accessToken, action string, btag *BatchTag) (*Response, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, action, accessToken)
-	b, err := json.Marshal(btag)
-	if err != nil {
-		return nil, err
-	}
-	res, err := http.Post(URL, JSONContentType, bytes.NewReader(b))
-	if err != nil {
-		return nil, err
-	}
-	b, err = ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
+func (s *Service) batchTagging(ctx context.Context, action string, btag *BatchTag) (*Response, error) {
 	var resp Response
-	if err = json.Unmarshal(b, &resp); err != nil {
+	if err := s.c.PostJSON(ctx, action, nil, btag, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
 
 // BatchTagging 批量打标签
-func BatchTagging(host, accessToken string, btag *BatchTag) (*Response, error) {
-	return batchTagging(host, accessToken, WxBatchTagging, btag)
+func (s *Service) BatchTagging(btag *BatchTag) (*Response, error) {
+	return s.BatchTaggingContext(context.Background(), btag)
+}
+
+// BatchTaggingContext 批量打标签，ctx取消时中止请求
+func (s *Service) BatchTaggingContext(ctx context.Context, btag *BatchTag) (*Response, error) {
+	return s.batchTagging(ctx, WxBatchTagging, btag)
 }
 
 // WxUnBatchTagging 批量取消标签的API
 const WxUnBatchTagging = "cgi-bin/tags/members/batchuntagging"
 
 // UnBatchTagging 批量取消标签
-func UnBatchTagging(host, accessToken string, btag *BatchTag) (*Response, error) {
-	return batchTagging(host, accessToken, WxUnBatchTagging, btag)
+func (s *Service) UnBatchTagging(btag *BatchTag) (*Response, error) {
+	return s.UnBatchTaggingContext(context.Background(), btag)
+}
+
+// UnBatchTaggingContext 批量取消标签，ctx取消时中止请求
+func (s *Service) UnBatchTaggingContext(ctx context.Context, btag *BatchTag) (*Response, error) {
+	return s.batchTagging(ctx, WxUnBatchTagging, btag)
+}
+
+// WxGetBlackList 获取公众号的黑名单列表API
+const WxGetBlackList = "cgi-bin/tags/members/getblacklist"
+
+// MaxBlackListBatch 拉黑和取消拉黑时每次最多的openid数量
+const MaxBlackListBatch = 20
+
+// GetBlackList 获取begin之后的黑名单，begin为空时从头开始拉取，每次最多10000个，
+// 返回值的格式与关注者列表相同
+func (s *Service) GetBlackList(begin string) (*Followers, error) {
+	return s.GetBlackListContext(context.Background(), begin)
+}
+
+// GetBlackListContext 获取begin之后的黑名单，begin为空时从头开始拉取，每次最多10000个，
+// 返回值的格式与关注者列表相同，ctx取消时中止请求
+func (s *Service) GetBlackListContext(ctx context.Context, begin string) (*Followers, error) {
+	req := struct {
+		BeginOpenID string `json:"begin_openid"`
+	}{begin}
+	var list Followers
+	if err := s.c.QueryJSON(ctx, WxGetBlackList, nil, req, &list); err != nil {
+		return nil, fmt.Errorf("get blacklist after %q %w", begin, err)
+	}
+	return &list, nil
+}
+
+// BlackList 返回从cursor之后开始遍历黑名单的*FollowerIterator，cursor为空时从头开始
+func (s *Service) BlackList(cursor string) *FollowerIterator {
+	return &FollowerIterator{list: s.GetBlackListContext, next: cursor, cursor: cursor, total: -1}
+}
+
+// WxBatchBlackList 拉黑用户API
+const WxBatchBlackList = "cgi-bin/tags/members/batchblacklist"
+
+// WxBatchUnBlackList 取消拉黑用户API
+const WxBatchUnBlackList = "cgi-bin/tags/members/batchunblacklist"
+
+// batchBlackList 按照每批MaxBlackListBatch个openid依次调用action，
+// 出错时停止，已经提交的批次不会撤销
+func (s *Service) batchBlackList(ctx context.Context, action string, openids []string) (*Response, error) {
+	if len(openids) == 0 {
+		return nil, errors.New("openid list is empty")
+	}
+	var resp Response
+	for i := 0; i < len(openids); i += MaxBlackListBatch {
+		end := i + MaxBlackListBatch
+		if end > len(openids) {
+			end = len(openids)
+		}
+		req := struct {
+			OpenIDList []string `json:"openid_list"`
+		}{openids[i:end]}
+		resp = Response{}
+		if err := s.c.PostJSON(ctx, action, nil, req, &resp); err != nil {
+			return nil, fmt.Errorf("%d of %d openids done, openids[%d:%d] %w", i, len(openids), i, end, err)
+		}
+	}
+	return &resp, nil
+}
+
+// BatchBlackList 拉黑用户，openids超过MaxBlackListBatch个时分批提交，
+// 某一批失败时停止并返回错误，之前的批次已经生效
+func (s *Service) BatchBlackList(openids []string) (*Response, error) {
+	return s.BatchBlackListContext(context.Background(), openids)
+}
+
+// BatchBlackListContext 拉黑用户，openids超过MaxBlackListBatch个时分批提交，
+// 某一批失败时停止并返回错误，之前的批次已经生效，ctx取消时中止请求
+func (s *Service) BatchBlackListContext(ctx context.Context, openids []string) (*Response, error) {
+	resp, err := s.batchBlackList(ctx, WxBatchBlackList, openids)
+	if err != nil {
+		return nil, fmt.Errorf("batch blacklist: %w", err)
+	}
+	return resp, nil
+}
+
+// BatchUnBlackList 取消拉黑用户，openids超过MaxBlackListBatch个时分批提交，
+// 某一批失败时停止并返回错误，之前的批次已经生效
+func (s *Service) BatchUnBlackList(openids []string) (*Response, error) {
+	return s.BatchUnBlackListContext(context.Background(), openids)
+}
+
+// BatchUnBlackListContext 取消拉黑用户，openids超过MaxBlackListBatch个时分批提交，
+// 某一批失败时停止并返回错误，之前的批次已经生效，ctx取消时中止请求
+func (s *Service) BatchUnBlackListContext(ctx context.Context, openids []string) (*Response, error) {
+	resp, err := s.batchBlackList(ctx, WxBatchUnBlackList, openids)
+	if err != nil {
+		return nil, fmt.Errorf("batch unblacklist: %w", err)
+	}
+	return resp, nil
 }
 
 // UserTagsList 获取用户所属的标签列表, 一个用户可以最多有20个标签
 type UserTagsList struct {
-	TagIDList []uint32 `json:"tagi_list,omitempty"`
+	TagIDList []uint32 `json:"tagid_list,omitempty"`
+	ErrCode   int      `json:"errcode,omitempty"`
+	ErrMsg    string   `json:"errmsg,omitempty"`
 }
 
 // WxTagsGetIDList 获取用户身上的标签API
 const WxTagsGetIDList = "cgi-bin/tags/getidlist"
 
 // GetTagsOfUser 获取用户所属标签
-func GetTagsOfUser(host, accessToken, openid string) (*UserTagsList, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, WxTagsGetIDList, accessToken)
-	JSON := `{"openid":"` + openid + `"}`
-	res, err := http.Post(URL, JSONContentType, strings.NewReader(JSON))
-	if err != nil {
-		return nil, err
-	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+func (s *Service) GetTagsOfUser(openid string) (*UserTagsList, error) {
+	return s.GetTagsOfUserContext(context.Background(), openid)
+}
 
+// GetTagsOfUserContext 获取用户所属标签，ctx取消时中止请求
+func (s *Service) GetTagsOfUserContext(ctx context.Context, openid string) (*UserTagsList, error) {
+	req := struct {
+		OpenID string `json:"openid"`
+	}{openid}
 	var resp UserTagsList
-	if err = json.Unmarshal(b, &resp); err != nil {
+	if err := s.c.QueryJSON(ctx, WxTagsGetIDList, nil, req, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
+
+// CreateTag 添加标签
+func CreateTag(host, accessToken, tagname string) (*TagResponse, error) {
+	return newService(host, accessToken).CreateTag(tagname)
+}
+
+// CreateTagContext 添加标签，ctx取消时中止请求
+func CreateTagContext(ctx context.Context, host, accessToken, tagname string) (*TagResponse, error) {
+	return newService(host, accessToken).CreateTagContext(ctx, tagname)
+}
+
+// GetTags 获取已有标签
+func GetTags(host, accessToken string) (*TagsResponse, error) {
+	return newService(host, accessToken).GetTags()
+}
+
+// GetTagsContext 获取已有标签，ctx取消时中止请求
+func GetTagsContext(ctx context.Context, host, accessToken string) (*TagsResponse, error) {
+	return newService(host, accessToken).GetTagsContext(ctx)
+}
+
+// UpdateTag 修改标签，id不能是0/1/2
+func UpdateTag(host, accessToken, tagname string, id int) (*TagResponse, error) {
+	return newService(host, accessToken).UpdateTag(tagname, id)
+}
+
+// UpdateTagContext 修改标签，id不能是0/1/2，ctx取消时中止请求
+func UpdateTagContext(ctx context.Context, host, accessToken, tagname string, id int) (*TagResponse, error) {
+	return newService(host, accessToken).UpdateTagContext(ctx, tagname, id)
+}
+
+// DeleteTag 删除标签
+func DeleteTag(host, accessToken string, id int) (*TagResponse, error) {
+	return newService(host, accessToken).DeleteTag(id)
+}
+
+// DeleteTagContext 删除标签，ctx取消时中止请求
+func DeleteTagContext(ctx context.Context, host, accessToken string, id int) (*TagResponse, error) {
+	return newService(host, accessToken).DeleteTagContext(ctx, id)
+}
+
+// GetUsersOfTag 获取标签下的用户
+func GetUsersOfTag(host, accessToken, next string, id int) (*UserOfTag, error) {
+	return newService(host, accessToken).GetUsersOfTag(next, id)
+}
+
+// GetUsersOfTagContext 获取标签下的用户，ctx取消时中止请求
+func GetUsersOfTagContext(ctx context.Context, host, accessToken, next string, id int) (*UserOfTag, error) {
+	return newService(host, accessToken).GetUsersOfTagContext(ctx, next, id)
+}
+
+// BatchTagging 批量打标签
+func BatchTagging(host, accessToken string, btag *BatchTag) (*Response, error) {
+	return newService(host, accessToken).BatchTagging(btag)
+}
+
+// BatchTaggingContext 批量打标签，ctx取消时中止请求
+func BatchTaggingContext(ctx context.Context, host, accessToken string, btag *BatchTag) (*Response, error) {
+	return newService(host, accessToken).BatchTaggingContext(ctx, btag)
+}
+
+// UnBatchTagging 批量取消标签
+func UnBatchTagging(host, accessToken string, btag *BatchTag) (*Response, error) {
+	return newService(host, accessToken).UnBatchTagging(btag)
+}
+
+// UnBatchTaggingContext 批量取消标签，ctx取消时中止请求
+func UnBatchTaggingContext(ctx context.Context, host, accessToken string, btag *BatchTag) (*Response, error) {
+	return newService(host, accessToken).UnBatchTaggingContext(ctx, btag)
+}
+
+// GetBlackList 获取begin之后的黑名单
+func GetBlackList(host, accessToken, begin string) (*Followers, error) {
+	return newService(host, accessToken).GetBlackList(begin)
+}
+
+// GetBlackListContext 获取begin之后的黑名单，ctx取消时中止请求
+func GetBlackListContext(ctx context.Context, host, accessToken, begin string) (*Followers, error) {
+	return newService(host, accessToken).GetBlackListContext(ctx, begin)
+}
+
+// BatchBlackList 拉黑用户
+func BatchBlackList(host, accessToken string, openids []string) (*Response, error) {
+	return newService(host, accessToken).BatchBlackList(openids)
+}
+
+// BatchBlackListContext 拉黑用户，ctx取消时中止请求
+func BatchBlackListContext(ctx context.Context, host, accessToken string, openids []string) (*Response, error) {
+	return newService(host, accessToken).BatchBlackListContext(ctx, openids)
+}
+
+// BatchUnBlackList 取消拉黑用户
+func BatchUnBlackList(host, accessToken string, openids []string) (*Response, error) {
+	return newService(host, accessToken).BatchUnBlackList(openids)
+}
+
+// BatchUnBlackListContext 取消拉黑用户，ctx取消时中止请求
+func BatchUnBlackListContext(ctx context.Context, host, accessToken string, openids []string) (*Response, error) {
+	return newService(host, accessToken).BatchUnBlackListContext(ctx, openids)
+}
+
+// GetTagsOfUser 获取用户所属标签
+func GetTagsOfUser(host, accessToken, openid string) (*UserTagsList, error) {
+	return newService(host, accessToken).GetTagsOfUser(openid)
+}
+
+// GetTagsOfUserContext 获取用户所属标签，ctx取消时中止请求
+func GetTagsOfUserContext(ctx context.Context, host, accessToken, openid string) (*UserTagsList, error) {
+	return newService(host, accessToken).GetTagsOfUserContext(ctx, openid)
+}
@@ -0,0 +1,116 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"qingtao/weixin/mp/core"
+)
+
+func TestBlackList(t *testing.T) {
+	var mu sync.Mutex
+	black := make(map[string]bool)
+	var batches []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/" + WxBatchBlackList, "/" + WxBatchUnBlackList:
+			var req struct {
+				OpenIDList []string `json:"openid_list"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if len(req.OpenIDList) > MaxBlackListBatch {
+				fmt.Fprint(w, `{"errcode":40032,"errmsg":"invalid openid list size"}`)
+				return
+			}
+			if len(req.OpenIDList) > 0 && req.OpenIDList[0] == "invalid" {
+				fmt.Fprint(w, `{"errcode":40003,"errmsg":"invalid openid"}`)
+				return
+			}
+			batches = append(batches, len(req.OpenIDList))
+			for _, openid := range req.OpenIDList {
+				black[openid] = r.URL.Path == "/"+WxBatchBlackList
+			}
+			fmt.Fprint(w, `{"errcode":0,"errmsg":"ok"}`)
+		case "/" + WxGetBlackList:
+			var req struct {
+				BeginOpenID string `json:"begin_openid"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			// 每页最多10个，按照openid的顺序
+			var list []string
+			for i := 0; i < 100; i++ {
+				openid := fmt.Sprintf("openid%03d", i)
+				if black[openid] && openid > req.BeginOpenID && len(list) < 10 {
+					list = append(list, openid)
+				}
+			}
+			f := Followers{Total: len(black), Count: len(list)}
+			if len(list) > 0 {
+				f.Data = &Data{OpenID: list}
+				f.NextOpenID = list[len(list)-1]
+			}
+			json.NewEncoder(w).Encode(f)
+		}
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	openids := make([]string, 45)
+	for i := range openids {
+		openids[i] = fmt.Sprintf("openid%03d", i)
+	}
+	if _, err := s.BatchBlackList(openids); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(batches) != "[20 20 5]" {
+		t.Fatalf("batches %v", batches)
+	}
+	if _, err := s.BatchUnBlackList(openids[:3]); err != nil {
+		t.Fatal(err)
+	}
+
+	it := s.BlackList("")
+	var got []string
+	for it.Next(context.Background()) {
+		got = append(got, it.OpenID())
+	}
+	if it.Err() != nil || len(got) != 42 || got[0] != "openid003" || got[41] != "openid044" {
+		t.Fatalf("blacklist %d %v %v", len(got), got, it.Err())
+	}
+
+	_, err := s.BatchBlackList(append(openids[:20:20], "invalid"))
+	if core.ErrCode(err) != 40003 {
+		t.Fatalf("err %v", err)
+	}
+	if _, err := s.BatchUnBlackList(nil); err == nil {
+		t.Fatal("empty openid list")
+	}
+}
+
+func TestSystemBusy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errcode":-1,"errmsg":"system error"}`)
+	}))
+	defer srv.Close()
+	s := NewService(&core.Client{BaseURL: srv.URL, Tokens: core.StaticToken("token")})
+
+	_, err := s.GetUserInfo("openid", "")
+	var e *core.APIError
+	if !errors.As(err, &e) || !core.IsSystemBusy(err) {
+		t.Fatalf("GetUserInfo err %v", err)
+	}
+	if _, err := s.GetTags(); !core.IsSystemBusy(err) {
+		t.Fatalf("GetTags err %v", err)
+	}
+	if _, err := s.GetUsersInfo([]*Item{{OpenID: "openid"}}); !core.IsSystemBusy(err) {
+		t.Fatalf("GetUsersInfo err %v", err)
+	}
+}
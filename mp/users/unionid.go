@@ -1,11 +1,9 @@
 package users
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
-	"qingtao/weixin/mp/internal/tools"
+	"net/url"
 )
 
 /*
@@ -43,7 +41,7 @@ type User struct {
 	QrSceneStr     string `json:"qr_scene_str,omitempty"`
 
 	// ErrCode 错误代码
-	ErrCode uint32 `json:"errcode,omitempty"`
+	ErrCode int `json:"errcode,omitempty"`
 	// Errmsg 错误信息
 	ErrMsg string `json:"errmsg,omitempty"`
 }
@@ -52,39 +50,27 @@ type User struct {
 const WxUserInfoPath = "cgi-bin/user/info"
 
 // GetUserInfo 根据API接口通过GET方法获取用户基本信息
-func GetUserInfo(host, accessToken, openid, lang string) (*User, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s&openid=%s", host, WxUserInfoPath, accessToken, openid)
+func (s *Service) GetUserInfo(openid, lang string) (*User, error) {
+	return s.GetUserInfoContext(context.Background(), openid, lang)
+}
+
+// GetUserInfoContext 根据API接口通过GET方法获取用户基本信息，ctx取消时中止请求
+func (s *Service) GetUserInfoContext(ctx context.Context, openid, lang string) (*User, error) {
+	query := url.Values{"openid": {openid}}
 	if lang != "" {
-		URL = URL + "&lang=" + lang
+		query.Set("lang", lang)
 	}
-	res, err := http.Get(URL)
-	if err != nil {
-		return nil, err
-	}
-
 	var user User
-	if err = tools.UnmarshalJSON(res, &user); err != nil {
-		return nil, fmt.Errorf("when get user info %s: error: %s", openid, err)
+	if err := s.c.Get(ctx, WxUserInfoPath, query, &user); err != nil {
+		return nil, fmt.Errorf("when get user info %s: error: %w", openid, err)
 	}
-
-	/*
-		b, err := tools.ReadResponse(res)
-		if err != nil {
-			return nil, fmt.Errorf("When get user info %s", err)
-		}
-		if err = json.Unmarshal(b, &user);
-		err != nil {
-			return nil, fmt.Errorf("when get user info %s: error: %s", openid, err)
-		}
-	*/
-
 	return &user, nil
 }
 
 // Users 批量获取用户基本信息时API返回的结构体
 type Users struct {
 	UserInfoList []*User `json:"user_info_list,omitempty"`
-	ErrCode      uint32  `json:"errcode,omitempty"`
+	ErrCode      int     `json:"errcode,omitempty"`
 	ErrMsg       string  `json:"errmsg,omitempty"`
 }
 
@@ -98,25 +84,40 @@ type Item struct {
 }
 
 // GetUsersInfo 批量获取用户基本信息
-func GetUsersInfo(host, accessToken string, userlist []*Item) (*Users, error) {
-	URL := fmt.Sprintf("https://%s/%s?access_token=%s", host, WxUsersInfoPath, accessToken)
+func (s *Service) GetUsersInfo(userlist []*Item) (*Users, error) {
+	return s.GetUsersInfoContext(context.Background(), userlist)
+}
+
+// GetUsersInfoContext 批量获取用户基本信息，ctx取消时中止请求
+func (s *Service) GetUsersInfoContext(ctx context.Context, userlist []*Item) (*Users, error) {
 	var userList = struct {
-		UserList []*Item `json:"user_lsit"`
+		UserList []*Item `json:"user_list"`
 	}{
 		UserList: userlist,
 	}
-
-	b, err := json.Marshal(userList)
-	if err != nil {
-		return nil, err
-	}
-	res, err := http.Post(URL, JSONContentType, bytes.NewReader(b))
-	if err != nil {
-		return nil, err
-	}
 	var users Users
-	if err = tools.UnmarshalJSON(res, &users); err != nil {
+	if err := s.c.QueryJSON(ctx, WxUsersInfoPath, nil, userList, &users); err != nil {
 		return nil, err
 	}
 	return &users, nil
 }
+
+// GetUserInfo 根据API接口通过GET方法获取用户基本信息
+func GetUserInfo(host, accessToken, openid, lang string) (*User, error) {
+	return newService(host, accessToken).GetUserInfo(openid, lang)
+}
+
+// GetUserInfoContext 根据API接口通过GET方法获取用户基本信息，ctx取消时中止请求
+func GetUserInfoContext(ctx context.Context, host, accessToken, openid, lang string) (*User, error) {
+	return newService(host, accessToken).GetUserInfoContext(ctx, openid, lang)
+}
+
+// GetUsersInfo 批量获取用户基本信息
+func GetUsersInfo(host, accessToken string, userlist []*Item) (*Users, error) {
+	return newService(host, accessToken).GetUsersInfo(userlist)
+}
+
+// GetUsersInfoContext 批量获取用户基本信息，ctx取消时中止请求
+func GetUsersInfoContext(ctx context.Context, host, accessToken string, userlist []*Item) (*Users, error) {
+	return newService(host, accessToken).GetUsersInfoContext(ctx, userlist)
+}
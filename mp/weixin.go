@@ -2,13 +2,23 @@
 package mp
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"qingtao/weixin/mp/core"
+	"qingtao/weixin/mp/oauth"
 )
 
 const (
@@ -23,6 +33,9 @@ type WeiXin struct {
 	// 微信开发者ID
 	// AppID 应用ID
 	AppID string `xml:"AppId" json:"AppId"`
+	// OriginalID 公众号的原始ID，即接收消息的ToUserName，例如gh_123456789abc，
+	// Registry按照它分发消息
+	OriginalID string `xml:"OriginalId,omitempty" json:"OriginalId,omitempty"`
 	// AppSecret 应用密钥
 	AppSecret string
 	// Token 令牌
@@ -31,42 +44,64 @@ type WeiXin struct {
 	EncodingAESKey string
 	// EncodingAESKey 旧的消息加密密钥
 	OldEncodingAESKey string
+	// OldEncodingAESKeys 更早的消息加密密钥，在OldEncodingAESKey之后尝试
+	OldEncodingAESKeys []string `xml:",omitempty" json:",omitempty"`
 
-	// 保存access_token
-	accessToken string
-	// access_token的有效期
-	expires int
+	// mu 保护tokens、ticketManagers、client、store、limiter、router、server和keys
+	mu sync.Mutex
+	// tokens 管理access_token
+	tokens *TokenManager
+	// ticketManagers 按照类型管理JS-SDK和卡券的ticket
+	ticketManagers map[string]*TokenManager
+	// client 使用tokens的API客户端
+	client *Client
+	// store 多个进程共享access_token
+	store TokenStore
+	// limiter 限制接口调用频率和每日次数
+	limiter *Limiter
+	// router 分发接收到的消息和事件
+	router *Router
+	// server 接收微信服务器推送消息的http.Handler
+	server *Server
+	// keys 消息加解密的密钥
+	keys *KeyRing
+	// logger 记录接口调用和接收消息的日志，SetLogger替换后已经创建的组件也使用新的Logger
+	logger swapLogger
 }
 
-// New 读取filename文件, 生成新的*WeiXin, 失败时返回error非空
+// New 读取XML或者JSON格式的filename文件, 生成新的*WeiXin, 与Load相同, 配置错误时返回error非空
 func New(filename string) (*WeiXin, error) {
-	b, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("read weixin config: %s", err)
-	}
-	var wx WeiXin
-	if err = xml.Unmarshal(b, &wx); err != nil {
-		return nil, fmt.Errorf("read weixin config: %s", err)
-	}
-	return &wx, nil
+	return Load(filename)
 }
 
-// CreateWeiXinFile 创建weixin参数的xml文件, 按照申请的微信公众平台修改参数
+// CreateWeiXinFile 创建weixin参数的配置文件模版, 扩展名为.json时使用JSON格式, 否则使用XML格式,
+// AppSecret等密钥为空, 按照申请的微信公众平台填写; 文件权限为0600, 文件已经存在时返回错误
 func CreateWeiXinFile(filename string) error {
 	wx := &WeiXin{
-		Host:           "api.weixin.qq.com",
-		AppID:          "appid",
-		AppSecret:      "appsecret",
-		Token:          "token",
-		EncodingAESKey: "1234567890123456789012345678901234567890123",
+		Host:  core.DefaultHost,
+		AppID: "appid",
+	}
+	var b []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		b, err = json.MarshalIndent(wx, "", "  ")
+	} else {
+		b, err = xml.MarshalIndent(wx, "", "  ")
+		// 添加xml.Header到文件第一行
+		b = append([]byte(xml.Header), b...)
 	}
-	b, err := xml.MarshalIndent(wx, "", "  ")
 	if err != nil {
 		return err
 	}
-	// 添加xml.Header到文件第一行
-	b = append([]byte(xml.Header), b[0:]...)
-	return ioutil.WriteFile(filename, b, 0664)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 const (
@@ -92,40 +127,179 @@ type Token struct {
 	ErrMsg string `json:"errmsg,omitempty"`
 }
 
-// GetAccessToken 获取微信公众平台的access_token，
-// error为nil时，access_token = Token.AccessToken和expires_in = Token.ExpiresIn
-//	TODO:
-//	  * 将获取和更新access_token分离成服务
-func (wx *WeiXin) GetAccessToken() error {
-	// 组合URL
-	uri := fmt.Sprintf("https://%s/%s?grant_type=%s&appid=%s&secret=%s",
-		wx.Host, WxTokenPath, WxGrantType, wx.AppID, wx.AppSecret)
-	res, err := http.Get(uri)
-	if err != nil {
-		return fmt.Errorf("appid %s get access_token: %s",
-			wx.AppID, err)
+// fetchAccessToken 从微信公众平台获取新的access_token和有效期
+func (wx *WeiXin) fetchAccessToken(ctx context.Context) (string, int, error) {
+	query := url.Values{
+		"grant_type": {WxGrantType},
+		"appid":      {wx.AppID},
+		"secret":     {wx.AppSecret},
 	}
-	b, err := ioutil.ReadAll(res.Body)
+	var t Token
+	err := wx.Client().Call(ctx, &core.Request{Path: WxTokenPath, Query: query, NoToken: true}, &t)
 	if err != nil {
-		return fmt.Errorf("appid %s read response: %s", wx.AppID, err)
+		return "", 0, fmt.Errorf("appid %s get access_token: %w", wx.AppID, err)
 	}
-	defer res.Body.Close()
 
-	var t Token
-	if err = json.Unmarshal(b, &t); err != nil {
-		return fmt.Errorf("appid %s unmarshal response json: %s",
-			wx.AppID, err)
+	// 检查t.AccessToken为空，返回错误代码和错误信息
+	if t.AccessToken == "" {
+		return "", 0, fmt.Errorf("appid %s get access_token errcode: %d, errmsg: %s", wx.AppID, t.ErrCode, t.ErrMsg)
 	}
+	wx.log().Info("weixin access_token fetched", "access_token", core.Redact(t.AccessToken), "expires_in", t.ExpiresIn)
+	return t.AccessToken, t.ExpiresIn, nil
+}
 
-	// 检查t.AccessToken为空，返回nil，错误代码和错误信息
-	if t.AccessToken == "" {
-		return fmt.Errorf("appid %s get access_token errcode: %d, errmsg: %s", wx.AppID, t.ErrCode, t.ErrMsg)
+// Tokens 返回管理access_token的*TokenManager，第一次调用时创建，
+// 可以作为core.TokenSource在mp/cs、mp/users和mp/media的接口中使用
+func (wx *WeiXin) Tokens() *TokenManager {
+	wx.mu.Lock()
+	defer wx.mu.Unlock()
+	return wx.tokensLocked()
+}
+
+// tokensLocked 调用时必须持有wx.mu
+func (wx *WeiXin) tokensLocked() *TokenManager {
+	if wx.tokens == nil {
+		wx.tokens = NewTokenManager(wx.fetchAccessToken)
+		if wx.store != nil {
+			wx.tokens.SetStore(wx.store, wx.tokenKey())
+		}
+	}
+	return wx.tokens
+}
+
+// Client 返回使用wx.Host和wx.Tokens()的API客户端，第一次调用时创建
+func (wx *WeiXin) Client() *Client {
+	wx.mu.Lock()
+	defer wx.mu.Unlock()
+	if wx.client == nil {
+		wx.client = NewClient(wx.Host, wx.tokensLocked())
+		wx.client.Limiter = wx.limiter
+		wx.client.Logger = wx.loggerLocked()
+	}
+	return wx.client
+}
+
+// OAuth 返回使用wx.AppID、wx.AppSecret和wx.Client()的网页授权接口，
+// 每次调用返回新的*oauth.Service，需要设置StateKey时保存返回值
+func (wx *WeiXin) OAuth() *oauth.Service {
+	return oauth.NewService(wx.Client().Client, wx.AppID, wx.AppSecret)
+}
+
+// Logger 分级的结构化日志接口，方法与*slog.Logger相同
+type Logger = core.Logger
+
+// SetLogger 设置记录接口调用和接收消息的Logger，可以使用slog.Default()，
+// 每条日志都带有appid字段，不会记录access_token、AppSecret和消息内容，默认不输出日志。
+// 可以随时调用，已经创建的Client、Server和Async也改为使用l
+func (wx *WeiXin) SetLogger(l Logger) {
+	wx.logger.set(l)
+}
+
+// loggerLocked 返回带有appid字段的Logger，调用时必须持有wx.mu
+func (wx *WeiXin) loggerLocked() Logger {
+	return core.WithArgs(&wx.logger, "appid", wx.AppID)
+}
+
+// log 返回带有appid字段的Logger
+func (wx *WeiXin) log() Logger {
+	wx.mu.Lock()
+	defer wx.mu.Unlock()
+	return wx.loggerLocked()
+}
+
+// swapLogger 转发到最近一次set的Logger，可以在使用的同时替换
+type swapLogger struct {
+	v atomic.Value
+}
+
+// loggerBox atomic.Value要求每次保存的类型相同
+type loggerBox struct {
+	l Logger
+}
+
+// set 替换为l，l为nil时不输出日志
+func (s *swapLogger) set(l Logger) {
+	s.v.Store(loggerBox{l})
+}
+
+// current 返回当前的Logger
+func (s *swapLogger) current() Logger {
+	if b, ok := s.v.Load().(loggerBox); ok && b.l != nil {
+		return b.l
+	}
+	return core.NopLogger
+}
+
+// Debug 实现Logger接口
+func (s *swapLogger) Debug(msg string, args ...interface{}) { s.current().Debug(msg, args...) }
+
+// Info 实现Logger接口
+func (s *swapLogger) Info(msg string, args ...interface{}) { s.current().Info(msg, args...) }
+
+// Warn 实现Logger接口
+func (s *swapLogger) Warn(msg string, args ...interface{}) { s.current().Warn(msg, args...) }
+
+// Error 实现Logger接口
+func (s *swapLogger) Error(msg string, args ...interface{}) { s.current().Error(msg, args...) }
+
+// Router 返回分发消息和事件的*Router，第一次调用时创建，
+// HandleEvent和HandleEncryptEvent使用它生成被动回复的消息
+func (wx *WeiXin) Router() *Router {
+	wx.mu.Lock()
+	defer wx.mu.Unlock()
+	if wx.router == nil {
+		wx.router = NewRouter()
+	}
+	return wx.router
+}
+
+// tokenKey access_token在TokenStore中使用的key
+func (wx *WeiXin) tokenKey() string {
+	return "access_token_" + wx.AppID
+}
+
+// SetTokenStore 设置保存access_token的TokenStore，多个进程使用同一个公众号时，
+// 设置共享的store后只有一个进程会向微信服务器刷新access_token
+func (wx *WeiXin) SetTokenStore(store TokenStore) {
+	wx.mu.Lock()
+	defer wx.mu.Unlock()
+	wx.store = store
+	if wx.tokens != nil {
+		wx.tokens.SetStore(store, wx.tokenKey())
+	}
+	for typ, m := range wx.ticketManagers {
+		m.SetStore(store, wx.ticketKey(typ))
 	}
-	wx.accessToken = t.AccessToken
-	wx.expires = t.ExpiresIn
+}
+
+// ErrClientCreated wx.Client()已经创建，不能再修改它的配置
+var ErrClientCreated = errors.New("weixin: client already created")
+
+// SetLimiter 设置限制接口调用频率和每日次数的*Limiter，通常使用NewLimiter(DefaultLimits())。
+// 必须在第一次调用wx.Client()之前设置，包括获取access_token和调用接口，
+// 之后*Client可能正在被其他goroutine使用，返回ErrClientCreated
+func (wx *WeiXin) SetLimiter(l *Limiter) error {
+	wx.mu.Lock()
+	defer wx.mu.Unlock()
+	if wx.client != nil {
+		return ErrClientCreated
+	}
+	wx.limiter = l
 	return nil
 }
 
+// GetAccessToken 立即从微信公众平台重新获取access_token，
+// 通常不需要调用，Tokens会在access_token过期前自动刷新
+func (wx *WeiXin) GetAccessToken() error {
+	return wx.GetAccessTokenContext(context.Background())
+}
+
+// GetAccessTokenContext 同GetAccessToken，ctx取消时中止请求
+func (wx *WeiXin) GetAccessTokenContext(ctx context.Context) error {
+	_, err := wx.Tokens().Refresh(ctx, "")
+	return err
+}
+
 // Sign 生成签名，ciphertext是空字符串时，只使用token, timestamp, nonce
 func Sign(token, timestamp, nonce, ciphertext string) string {
 	list := []string{token, timestamp, nonce}
@@ -150,159 +324,16 @@ func (wx *WeiXin) VerfiyWxToken(timestamp, nonce, signature, ciphertext string)
 	return false
 }
 
-// HandleEncryptEvent 处理微信推送的加密消息，如果msg_ignature为空或encrypt_type不是"aes", 使用HandleEvent继续处理后续响应
+// HandleEncryptEvent 处理微信服务器推送的消息，与HandleEvent相同，保留用于兼容
 func (wx *WeiXin) HandleEncryptEvent(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("encrypt event --- %v\n", r)
-	r.ParseForm()
-	msgSignature := r.FormValue("msg_signature")
-	encryptType := r.FormValue("encrypt_type")
-	if msgSignature == "" || encryptType != wxEncryptType {
-		wx.HandleEvent(w, r)
-		fmt.Println("skip encrypt event")
-		return
-	}
-	// 校验消息是否来自微信服务器
-	signature := r.FormValue("signature")
-	timestamp := r.FormValue("timestamp")
-	nonce := r.FormValue("nonce")
-
-	if !wx.VerfiyWxToken(timestamp, nonce, signature, "") {
-		// 只打印
-		fmt.Println("verify event from weixin failed")
-		return
-	}
-
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		fmt.Printf("handle encrypt message read body %s\n", err)
-		fmt.Fprint(w, "")
-		return
-	}
-	defer r.Body.Close()
-	fmt.Printf("emsg:\n%s\n", body)
-	var emsg EncryptMessage
-	if err := xml.Unmarshal(body, &emsg); err != nil {
-		fmt.Printf("handle encrypt message parse xml content first %s\n", err)
-		fmt.Fprint(w, "")
-		return
-	}
-	if !wx.VerfiyWxToken(timestamp, nonce, msgSignature, string(emsg.Encrypt)) {
-		fmt.Println("handle weixin message verfiy encrypt message failed.")
-		fmt.Fprint(w, "")
-		return
-	}
-
-	key := wx.EncodingAESKey
-	retry := false
-USEOLDKEY:
-	b, err := Decrypt(key, string(emsg.Encrypt))
-	if err != nil {
-		fmt.Printf("decrypt by current key %s\n", err)
-		fmt.Fprint(w, "")
-		return
-	}
-
-	//解密失败乱码，不打印
-	//fmt.Printf("decrypt emsg:\n%s\n", b)
-
-	plaintext, appid, err := ParseDecryptMessage(b)
-	if err != nil {
-		fmt.Printf("parse encrypt message %s\n", err)
-		if !retry {
-			fmt.Printf("retry old key %v\n", retry)
-			retry = true
-			// set key to OldEncodingAESKey
-			key = wx.OldEncodingAESKey
-			// goto retry using old key
-			goto USEOLDKEY
-		}
-		fmt.Fprint(w, "")
-		return
-	}
-	fmt.Printf("decrypt emsg:\n%s\n%s\n", plaintext, appid)
-
-	var msg Message
-	if err := xml.Unmarshal(plaintext, &msg); err != nil {
-		fmt.Printf("handle message: unmarshal decrypt plaintext %s\n", err)
-		fmt.Fprint(w, "")
-		return
-	}
-
-	rmsg := NewTextMessage(msg.FromUserName, msg.ToUserName, "加密消息应答")
-	b, err = xml.Marshal(rmsg)
-	if err != nil {
-		fmt.Printf("handle message: make response to reply %s\n", err)
-		fmt.Fprint(w, "")
-		return
-	}
-
-	ciphertext, err := Encrypt(key, appid, b)
-	if err != nil {
-		fmt.Printf("handle message: encrypt response %s\n", err)
-		fmt.Fprint(w, "")
-		return
-	}
-	eres := NewEncryptResponse(wx.AppID, wx.Token, timestamp, nonce, ciphertext)
-	resp, err := xml.Marshal(eres)
-	if err != nil {
-		fmt.Printf("handle message marshal xml response %s\n", err)
-		fmt.Fprint(w, "")
-		return
-	}
-	fmt.Printf("%s\n", resp)
-	w.Header().Set("Content-Type", "application/xml; encoding=utf-8")
-	fmt.Fprintf(w, "%s", resp)
+	wx.Server().ServeHTTP(w, r)
 }
 
-// HandleEvent 处理微信服务器验证token请求
-//	TODO:
-//	  1. 消息接收与转发队列
-//	  2. 应答队列
+// HandleEvent 处理微信服务器验证token请求和推送的消息，支持明文、兼容和安全模式，
+// 使用wx.Router()生成被动回复的消息，
+// Handler可能超过5秒时使用wx.Router().Use(wx.NewAsync(0).Middleware)改为客服消息回复
 func (wx *WeiXin) HandleEvent(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("nomal --- %v\n", r)
-	r.ParseForm()
-	signature := r.FormValue("signature")
-	timestamp := r.FormValue("timestamp")
-	nonce := r.FormValue("nonce")
-
-	if !wx.VerfiyWxToken(timestamp, nonce, signature, "") {
-		// 只打印
-		fmt.Println("verify weixin event failed.")
-		return
-	}
-	switch r.Method {
-	// GET方法用于微信服务器配置验证
-	case "GET":
-		echostr := r.FormValue("echostr")
-		fmt.Fprintf(w, "%s", echostr)
-	// POST方法接收明文事件
-	case "POST":
-		var msg Message
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			fmt.Printf("handle event read context %s", err)
-			fmt.Fprint(w, "")
-			return
-		}
-		defer r.Body.Close()
-		if err := xml.Unmarshal(body, &msg); err != nil {
-			fmt.Printf("handle event parse xml %s\n", err)
-			fmt.Fprint(w, "")
-			return
-		}
-		fmt.Printf("message: -----\n%#v\n", msg)
-		fmt.Println("------")
-		rmsg := NewTextMessage(msg.FromUserName, msg.ToUserName, "回复应答消息")
-		b, err := xml.Marshal(rmsg)
-		if err != nil {
-			fmt.Printf("handle event new message for reply %s\n", err)
-			fmt.Fprint(w, "")
-			return
-		}
-		fmt.Printf("%s\n", b)
-		w.Header().Set("Content-Type", "application/xml; encoding=utf-8")
-		fmt.Fprintf(w, "%s", b)
-	}
+	wx.Server().ServeHTTP(w, r)
 }
 
 // CallBackIP 微信服务器IP地址
@@ -319,19 +350,10 @@ type CallBackIP struct {
 // 如果公众号基于安全等考虑，需要获知微信服务器的IP地址列表，
 // 以便进行相关限制，可以通过该接口获得微信服务器IP地址列表或者IP网段信息。
 func (wx *WeiXin) GetCallBackIP() (*CallBackIP, error) {
-	uri := fmt.Sprintf("https://%s/%s?access_token=%s", wx.Host,
-		WxGetCallBackIPPath, wx.accessToken)
-	res, err := http.Get(uri)
-	if err != nil {
-		return nil, fmt.Errorf("get callback ip address of weixin: %s", err)
-	}
-	var ips CallBackIP
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("get callback ip read body: %s", err)
-	}
-	if err = json.Unmarshal(b, &ips); err != nil {
-		return nil, fmt.Errorf("get callback ip address of weixin: %s", err)
-	}
-	return &ips, nil
+	return wx.Client().GetCallBackIP()
+}
+
+// GetCallBackIPContext 获取微信服务器IP地址，ctx取消时中止请求
+func (wx *WeiXin) GetCallBackIPContext(ctx context.Context) (*CallBackIP, error) {
+	return wx.Client().GetCallBackIPContext(ctx)
 }
@@ -18,8 +18,8 @@ func TestWx(t *testing.T) {
 		if err = wx.GetAccessToken(); err != nil {
 			t.Fatal(err)
 		}
-		t.Logf("access_token: %s\nexpires_in: %d\n", wx.accessToken, wx.expires)
-		m, err := wx.GetMenu(wx.accessToken)
+		t.Logf("expires: %s\n", wx.Tokens().Expires())
+		m, err := wx.GetMenu("")
 		if err != nil {
 			t.Fatalf("%#v\n", err)
 		}